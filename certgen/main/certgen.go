@@ -0,0 +1,95 @@
+package main
+
+import (
+	"JDSys/utils"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Genera la CA di un cluster di sviluppo ed i certificati dei nodi firmati da essa. La CA viene creata solamente
+se non è già presente nella cartella di output, così da poter aggiungere nuovi nodi ad un cluster esistente.
+Per ogni nome specificato vengono creati <nome>.pem e <nome>.key, da copiare sul nodo come TLS_CERT_FILE e TLS_KEY_FILE.
+
+Utilizzo: certgen [-out cartella] [-validity durata] [-hosts ip,...] nome...
+*/
+func main() {
+	out := flag.String("out", utils.TLS_PATH, "output directory")
+	validity := flag.Duration("validity", 365*24*time.Hour, "certificate validity")
+	hosts := flag.String("hosts", "", "comma separated IP addresses or DNS names added to every certificate")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: certgen [-out dir] [-validity duration] [-hosts ip,...] name...")
+		os.Exit(1)
+	}
+	err := os.MkdirAll(*out, 0700)
+	if err != nil {
+		fail(err)
+	}
+
+	caCert, caKey, err := loadOrCreateCA(*out, *validity)
+	if err != nil {
+		fail(err)
+	}
+	var extra []string
+	if *hosts != "" {
+		extra = strings.Split(*hosts, ",")
+	}
+	for _, name := range flag.Args() {
+		cert, key, err := utils.GenerateCertificate(caCert, caKey, name, append([]string{name}, extra...), *validity)
+		if err != nil {
+			fail(err)
+		}
+		err = writePair(filepath.Join(*out, name), cert, key)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println("Certificate created: " + filepath.Join(*out, name+".pem"))
+	}
+}
+
+/*
+Carica la CA dalla cartella di output, creandola se non esiste
+*/
+func loadOrCreateCA(dir string, validity time.Duration) ([]byte, []byte, error) {
+	base := filepath.Join(dir, "ca")
+	cert, certErr := os.ReadFile(base + ".pem")
+	key, keyErr := os.ReadFile(base + ".key")
+	if certErr == nil && keyErr == nil {
+		fmt.Println("Using existing CA: " + base + ".pem")
+		return cert, key, nil
+	}
+	cert, key, err := utils.GenerateCA("JDSys Cluster CA", validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writePair(base, cert, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Println("CA created: " + base + ".pem")
+	return cert, key, nil
+}
+
+/*
+Scrive certificato e chiave nei file <base>.pem e <base>.key
+*/
+func writePair(base string, cert []byte, key []byte) error {
+	err := os.WriteFile(base+".pem", cert, 0644)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".key", key, 0600)
+}
+
+/*
+Stampa l'errore e termina il programma
+*/
+func fail(err error) {
+	fmt.Println("Error: " + err.Error())
+	os.Exit(1)
+}
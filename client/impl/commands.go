@@ -3,9 +3,15 @@ package impl
 import (
 	"JDSys/utils"
 	"bufio"
+	"errors"
 	"fmt"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -16,12 +22,10 @@ func Get() {
 	utils.PrintClientTitlebar()
 	utils.PrintInBox("GET")
 	utils.PrintLineL1()
-	SecScanln("> Insert the Key of the desired entry")
+	key := SecScanln("> Insert the Key of the desired entry")
+	consistency := ScanConsistency()
 	utils.PrintLineL1()
-	//GetRPC(key)
-	key := "Key   | " + "1"
-	value := "Value | " + "Prova"
-	fmt.Println(utils.StringInBoxL2(key, value))
+	GetRPC(key, consistency)
 	EnterToContinue()
 }
 
@@ -35,8 +39,11 @@ func Put() {
 	utils.PrintLineL1()
 	key := SecScanln("> Insert the Entry Key")
 	value := SecScanln("> Insert the Entry Value")
+	maxVersions := ScanNumber("> Insert the Number of Versions to keep (0 for default)")
+	ttl := ScanNumber("> Insert the Time-To-Live in seconds (0 for no expiry)")
+	consistency := ScanConsistency()
 	utils.PrintLineL1()
-	PutRPC(key, value)
+	PutRPC(key, value, maxVersions, time.Duration(ttl)*time.Second, consistency)
 	EnterToContinue()
 }
 
@@ -50,8 +57,298 @@ func Append() {
 	utils.PrintLineL1()
 	key := SecScanln("> Insert the Key of the Entry to Update")
 	newValue := SecScanln("> Insert the Value to Append")
+	ttl := ScanNumber("> Insert the new Time-To-Live in seconds (0 to keep the current one)")
+	consistency := ScanConsistency()
 	utils.PrintLineL1()
-	AppendRPC(key, newValue)
+	AppendRPC(key, newValue, time.Duration(ttl)*time.Second, consistency)
+	EnterToContinue()
+}
+
+/*
+Permette al client di risolvere le versioni concorrenti di una chiave, scrivendo un nuovo valore
+che sostituisce tutte le versioni indicate dal contesto ottenuto con la Get
+*/
+func Resolve() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("RESOLVE")
+	utils.PrintLineL1()
+	key := SecScanln("> Insert the Key of the Entry to Resolve")
+	value := SecScanln("> Insert the Resolved Value")
+	context := SecScanln("> Insert the Context returned by Get")
+	utils.PrintLineL1()
+	ResolveRPC(key, value, context)
+	EnterToContinue()
+}
+
+/*
+Permette al client di leggere lo storico delle versioni di una chiave, oppure una sua versione precedente
+selezionata tramite il vector clock o l'istante a cui leggerla
+*/
+func Versions() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("VERSIONS")
+	utils.PrintLineL1()
+	key := SecScanln("> Insert the Key of the desired entry")
+	mode := SecScanln("> Read (1) all versions, (2) a specific version, (3) the entry as of a time")
+	version := ""
+	asOf := time.Time{}
+	switch mode {
+	case "2":
+		version = SecScanln("> Insert the Version returned by Versions")
+	case "3":
+		instant := SecScanln("> Insert the Time in UTC (YYYY-MM-DD hh-mm-ss)")
+		parsed, err := time.Parse("2006-01-02 15-04-05", instant)
+		if err != nil {
+			fmt.Println("Time not recognized, reading all versions")
+		}
+		asOf = parsed
+	}
+	utils.PrintLineL1()
+	VersionsRPC(key, version, asOf)
+	EnterToContinue()
+}
+
+/*
+Permette al client di effettuare una scrittura condizionata: Compare-And-Swap sul valore attuale,
+inserimento solo se la chiave non esiste, oppure inserimento solo se la chiave non è stata modificata
+dopo la versione letta
+*/
+func Conditional() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("CONDITIONAL PUT")
+	utils.PrintLineL1()
+	mode := SecScanln("> Write (1) if the value is the expected one, (2) if the key is absent, (3) if unchanged since a version, (4) if unchanged since a time")
+	key := SecScanln("> Insert the Entry Key")
+	switch mode {
+	case "1":
+		expected := SecScanln("> Insert the Expected Value")
+		value := SecScanln("> Insert the New Value")
+		utils.PrintLineL1()
+		CompareAndSwapRPC(key, expected, value)
+	case "2":
+		value := SecScanln("> Insert the Entry Value")
+		utils.PrintLineL1()
+		PutIfAbsentRPC(key, value)
+	case "3":
+		context := SecScanln("> Insert the Context returned by Get")
+		value := SecScanln("> Insert the New Value")
+		utils.PrintLineL1()
+		PutIfVersionRPC(key, value, context, time.Time{})
+	case "4":
+		since, err := time.Parse("2006-01-02 15-04-05", SecScanln("> Insert the Time in UTC (YYYY-MM-DD hh-mm-ss)"))
+		if err != nil {
+			fmt.Println("Time not recognized")
+			EnterToContinue()
+			return
+		}
+		value := SecScanln("> Insert the New Value")
+		utils.PrintLineL1()
+		PutIfVersionRPC(key, value, "", since)
+	default:
+		fmt.Println("Command not recognized")
+	}
+	EnterToContinue()
+}
+
+/*
+Permette al client di elencare le chiavi presenti nel sistema di storage che iniziano con un prefisso,
+visualizzandole una pagina alla volta
+*/
+func Scan() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("SCAN")
+	utils.PrintLineL1()
+	prefix := ""
+	if SecScanln("> Scan (1) all keys, (2) keys with a prefix") == "2" {
+		prefix = SecScanln("> Insert the Key Prefix")
+	}
+	limit := ScanNumber("> Insert the Number of Keys per page (0 for default)")
+	utils.PrintLineL1()
+
+	cursor := ""
+	for {
+		page, err := ScanRPC(prefix, cursor, limit)
+		if err != nil {
+			break
+		}
+		lines := []string{"Key | Value"}
+		for _, item := range page.Items {
+			value := item.Value
+			if item.OnCloud {
+				value = "<on cloud>"
+			}
+			lines = append(lines, item.Key+" | "+value)
+		}
+		fmt.Println(utils.StringInBoxLines(lines))
+		if page.NextCursor == "" {
+			fmt.Println("End of scan.")
+			break
+		}
+		fmt.Print("Press Enter for the next page, or 'q' to stop: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) == "q" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	EnterToContinue()
+}
+
+/*
+Permette al client di leggere oppure inserire più chiavi con un'unica richiesta al LB
+*/
+func Batch() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("BATCH")
+	utils.PrintLineL1()
+	mode := SecScanln("> (1) Get or (2) Put multiple keys")
+	keys := strings.Fields(SecScanln("> Insert the Keys separated by spaces"))
+	switch mode {
+	case "1":
+		utils.PrintLineL1()
+		MultiGetRPC(keys)
+	case "2":
+		values := strings.Fields(SecScanln("> Insert the Values separated by spaces, in the same order"))
+		if len(values) != len(keys) {
+			fmt.Println("The number of values must match the number of keys")
+			break
+		}
+		utils.PrintLineL1()
+		MultiPutRPC(keys, values)
+	default:
+		fmt.Println("Command not recognized")
+	}
+	EnterToContinue()
+}
+
+/*
+Permette al client di caricare un file come valore di una chiave. Il content-type viene ricavato
+dall'estensione del file oppure dal suo contenuto
+*/
+func Upload() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("UPLOAD")
+	utils.PrintLineL1()
+	key := SecScanln("> Insert the Entry Key")
+	path := ScanPath("> Insert the Path of the File to Upload")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Unable to read the file: " + err.Error())
+		EnterToContinue()
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	utils.PrintLineL1()
+	fmt.Println("Uploading " + strconv.Itoa(len(data)) + " bytes as " + contentType)
+	UploadRPC(key, data, contentType)
+	EnterToContinue()
+}
+
+/*
+Permette al client di scaricare su file il valore di una chiave
+*/
+func Download() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("DOWNLOAD")
+	utils.PrintLineL1()
+	key := SecScanln("> Insert the Key of the desired entry")
+	path := ScanPath("> Insert the Path of the File to Write")
+	utils.PrintLineL1()
+	value, err := DownloadRPC(key, 0, "")
+	if err == nil && !value.Found {
+		fmt.Println("Entry not found")
+	} else if err == nil {
+		written, err := writeValue(path, key, value)
+		if err != nil {
+			fmt.Println("Unable to write the file: " + err.Error())
+		} else {
+			fmt.Println("Written " + strconv.Itoa(written) + " bytes (" + value.ContentType + ") to " + path)
+		}
+		if value.Versions > 1 {
+			fmt.Println("The key has " + strconv.Itoa(value.Versions) + " concurrent versions, the most recent was downloaded")
+		}
+	}
+	EnterToContinue()
+}
+
+/*
+Scrive su file il valore scaricato. Un valore suddiviso in chunk viene scaricato e scritto un chunk alla volta,
+così da non mantenerlo interamente in memoria. In caso di errore il file parziale viene rimosso.
+*/
+func writeValue(path string, key string, value ValueReply) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	written := 0
+	for chunk := 0; err == nil; chunk++ {
+		var n int
+		n, err = file.Write(value.Data)
+		written += n
+		if err != nil || chunk+1 >= value.Chunks {
+			break
+		}
+		fmt.Println("Downloaded " + strconv.Itoa(written) + " of " + strconv.Itoa(value.Size) + " bytes")
+		value, err = DownloadRPC(key, chunk+1, value.Context)
+		if err == nil && !value.Found {
+			err = errors.New("Entry removed during the download")
+		}
+	}
+	if err == nil && value.Chunks > 0 && written != value.Size {
+		err = errors.New("Downloaded " + strconv.Itoa(written) + " bytes, expected " + strconv.Itoa(value.Size))
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return written, err
+}
+
+/*
+Permette al client di visualizzare le statistiche del nodo scelto dal Load Balancer
+*/
+func Stats() {
+	utils.ClearScreen()
+	utils.PrintClientTitlebar()
+	utils.PrintInBox("STATS")
+	utils.PrintLineL1()
+	stats, err := StatsRPC()
+	if err == nil {
+		var hints, oldest []string
+		for _, replica := range stats.ReplicaSet {
+			hints = append(hints, replica+": "+strconv.FormatUint(stats.Hints[replica], 10))
+			since := "-"
+			if !stats.OldestHint[replica].IsZero() {
+				since = stats.OldestHint[replica].UTC().Format(time.RFC3339)
+			}
+			oldest = append(oldest, replica+": "+since)
+		}
+		lines := []string{"Node        | " + stats.Address, "Entries     | " + strconv.Itoa(stats.Entries),
+			"Replica Set | " + strings.Join(stats.ReplicaSet, ", "), "Log Offset  | " + strconv.FormatUint(stats.LogOffset, 10),
+			"Hints       | " + strings.Join(hints, ", "), "Oldest Hint | " + strings.Join(oldest, ", "),
+			"Roles       | " + strconv.Itoa(stats.Roles["Primary"]) + " primary, " + strconv.Itoa(stats.Roles["Replica"]) + " replica, " +
+				strconv.Itoa(stats.Roles["Stale"]) + " stale"}
+		for _, transfer := range stats.Transfers {
+			ratio := 1.0
+			if transfer.RawBytes > 0 {
+				ratio = float64(transfer.WireBytes) / float64(transfer.RawBytes)
+			}
+			lines = append(lines, "Transfers   | "+transfer.Mode+" to "+transfer.Peer+": "+strconv.Itoa(transfer.Transfers)+" sent, "+
+				strconv.FormatInt(transfer.RawBytes, 10)+" -> "+strconv.FormatInt(transfer.WireBytes, 10)+" bytes ("+transfer.Compression+
+				", ratio "+strconv.FormatFloat(ratio, 'f', 2, 64)+") in "+transfer.Duration.String())
+		}
+		fmt.Println(utils.StringInBoxLines(lines))
+	}
 	EnterToContinue()
 }
 
@@ -101,6 +398,50 @@ func SecScanln(message string) string {
 	return arg[:len(arg)-1]
 }
 
+/*
+Prende in input da tastiera il percorso di un file locale. Il percorso non viene mai inviato ai nodi,
+per questo non è necessario il filtro di SecScanln
+*/
+func ScanPath(message string) string {
+	for {
+		fmt.Print(message + ": ")
+		path, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		path = strings.TrimSpace(path)
+		if path != "" {
+			return path
+		}
+	}
+}
+
+/*
+Prende in input da tastiera un numero non negativo, ritornando 0 se il valore inserito non è valido
+*/
+func ScanNumber(message string) int {
+	number, err := strconv.Atoi(SecScanln(message))
+	if err != nil || number < 0 {
+		return 0
+	}
+	return number
+}
+
+/*
+Prende in input da tastiera il livello di consistenza di un'operazione, tra ONE, QUORUM e ALL.
+Con DEFAULT si utilizza quello configurato per il cluster.
+*/
+func ScanConsistency() string {
+	for {
+		consistency := strings.ToUpper(SecScanln("> Insert the Consistency Level (ONE, QUORUM, ALL, DEFAULT)"))
+		if consistency == "DEFAULT" {
+			// Il nodo utilizza il livello di consistenza configurato per il cluster
+			return ""
+		}
+		if consistency == ONE || consistency == QUORUM || consistency == ALL {
+			return consistency
+		}
+		fmt.Println("Unknown consistency level, retry")
+	}
+}
+
 /*
 Mette in pausa il programma fino alla pressione del tasto 'Enter'
 */
@@ -13,6 +13,22 @@ var GET string = "Node.GetRPC"
 var PUT string = "Node.PutRPC"
 var DEL string = "Node.DeleteRPC"
 var APP string = "Node.AppendRPC"
+var VERS string = "Node.GetVersionsRPC"
+var CAS string = "Node.CompareAndSwapRPC"
+var ABSENT string = "Node.PutIfAbsentRPC"
+var IFVERS string = "Node.PutIfVersionRPC"
+var SCAN string = "Node.ScanRPC"
+var MGET string = "Node.MultiGetRPC"
+var MPUT string = "Node.MultiPutRPC"
+var GETVAL string = "Node.GetValueRPC"
+var STATS string = "Node.StatsRPC"
+
+var ONE string = "ONE"
+var QUORUM string = "QUORUM"
+var ALL string = "ALL"
+
+var APPLIED string = "Applied"
+var PRECONDITION_FAILED string = "PreconditionFailed"
 
 /*
 Struttura che mantiene i parametri delle RPC
@@ -22,14 +38,124 @@ type Args struct {
 	Value   string
 	Handler string
 	Deleted bool
+	Context string
+
+	MaxVersions int
+	Version     string
+	AsOf        time.Time
+
+	TTL time.Duration
+
+	Condition string
+	Expected  string
+	Since     time.Time
+
+	Prefix string
+	Cursor string
+	Limit  int
+
+	Keys   []string
+	Values []string
+
+	Data        []byte
+	ContentType string
+	Chunk       int
+
+	Consistency string
+}
+
+/*
+Valore binario di una chiave
+*/
+type ValueReply struct {
+	Found       bool
+	Data        []byte
+	ContentType string
+	Versions    int
+	Context     string
+	Chunks      int
+	Size        int
+}
+
+/*
+Statistiche del nodo che ha servito la richiesta
+*/
+type NodeStats struct {
+	Address    string
+	Entries    int
+	ReplicaSet []string
+	LogOffset  uint64
+	Acked      map[string]uint64
+	Hints      map[string]uint64
+	OldestHint map[string]time.Time
+	Roles      map[string]int
+	Transfers  []TransferStats
+}
+
+/*
+Statistiche dei trasferimenti inviati dal nodo verso un altro nodo per un servizio
+*/
+type TransferStats struct {
+	Mode        string
+	Peer        string
+	Compression string
+	Transfers   int
+	RawBytes    int64
+	WireBytes   int64
+	Duration    time.Duration
+}
+
+/*
+Risultato di un'operazione batch su una singola chiave
+*/
+type KeyResult struct {
+	Key   string
+	Value string
+	Error string
+}
+
+/*
+Risultati di un'operazione batch, nello stesso ordine delle chiavi richieste
+*/
+type MultiReply struct {
+	Results []KeyResult
+}
+
+/*
+Chiave ritornata dalla scansione
+*/
+type ScanItem struct {
+	Key     string
+	Value   string
+	OnCloud bool
+}
+
+/*
+Pagina di una scansione, NextCursor è vuoto sull'ultima pagina
+*/
+type ScanReply struct {
+	Items      []ScanItem
+	NextCursor string
+	More       bool
+	Successor  string
+}
+
+/*
+Risultato di una scrittura condizionata
+*/
+type ConditionalReply struct {
+	Status  string
+	Message string
+	Current string
 }
 
 /*
-Effettua la RPC per la GET
+Effettua la RPC per la GET, specificando il livello di consistenza della lettura
 */
-func GetRPC(key string) {
+func GetRPC(key string, consistency string) {
 	args := Args{}
 	args.Key = key
+	args.Consistency = consistency
 
 	var reply *string
 
@@ -42,12 +168,36 @@ func GetRPC(key string) {
 }
 
 /*
-Effettua la RPC per il PUT
+Effettua la RPC per leggere le versioni di una chiave. Specificando una versione oppure un istante
+si legge solamente la versione selezionata, altrimenti tutte le versioni mantenute
 */
-func PutRPC(key string, value string) {
+func VersionsRPC(key string, version string, asOf time.Time) {
+	args := Args{}
+	args.Key = key
+	args.Version = version
+	args.AsOf = asOf
+
+	var reply *string
+
+	c := make(chan error)
+
+	client, _ := utils.HttpConnect(utils.LB_DNS_NAME, utils.RPC_PORT)
+	defer client.Close()
+	go CallRPC(VERS, client, args, reply, c)
+	rr1_timeout(VERS, client, args, reply, c)
+}
+
+/*
+Effettua la RPC per il PUT, specificando il numero di versioni da mantenere per la chiave (0 per il default del nodo),
+il suo time-to-live (0 per nessuna scadenza) ed il livello di consistenza della scrittura
+*/
+func PutRPC(key string, value string, maxVersions int, ttl time.Duration, consistency string) {
 	args := Args{}
 	args.Key = key
 	args.Value = value
+	args.MaxVersions = maxVersions
+	args.TTL = ttl
+	args.Consistency = consistency
 
 	var reply *string
 
@@ -60,12 +210,222 @@ func PutRPC(key string, value string) {
 }
 
 /*
-Effettua la RPC per l'APPEND
+Effettua la RPC per il PUT di un valore binario, specificandone il content-type
+*/
+func UploadRPC(key string, data []byte, contentType string) {
+	args := Args{}
+	args.Key = key
+	args.Data = data
+	args.ContentType = contentType
+
+	var reply *string
+
+	c := make(chan error)
+
+	client, _ := utils.HttpConnect(utils.LB_DNS_NAME, utils.RPC_PORT)
+	defer client.Close()
+	go CallRPC(PUT, client, args, reply, c)
+	rr1_timeout(PUT, client, args, reply, c)
+}
+
+/*
+Effettua la RPC per ottenere il valore binario di una chiave, senza alcuna formattazione. Di un valore suddiviso
+in chunk si ottiene il solo chunk di indice chunk, verificando con il contesto della prima lettura che il valore
+non sia cambiato nel frattempo.
+*/
+func DownloadRPC(key string, chunk int, context string) (ValueReply, error) {
+	args := Args{}
+	args.Key = key
+	args.Chunk = chunk
+	args.Context = context
+
+	var reply ValueReply
+	err := CallTypedRPC(GETVAL, args, &reply)
+	if err != nil {
+		utils.PrintTs("RPC error " + err.Error())
+	}
+	return reply, err
+}
+
+/*
+Effettua la RPC per il PUT specificando il contesto della versione letta, così da risolvere le versioni concorrenti
+*/
+func ResolveRPC(key string, value string, context string) {
+	args := Args{}
+	args.Key = key
+	args.Value = value
+	args.Context = context
+
+	var reply *string
+
+	c := make(chan error)
+
+	client, _ := utils.HttpConnect(utils.LB_DNS_NAME, utils.RPC_PORT)
+	defer client.Close()
+	go CallRPC(PUT, client, args, reply, c)
+	rr1_timeout(PUT, client, args, reply, c)
+}
+
+/*
+Effettua la RPC per la Compare-And-Swap, che scrive il valore solo se quello attuale è il valore atteso
+*/
+func CompareAndSwapRPC(key string, expected string, value string) {
+	args := Args{}
+	args.Key = key
+	args.Expected = expected
+	args.Value = value
+	ConditionalRPC(CAS, args)
+}
+
+/*
+Effettua la RPC per inserire un'entry solamente se la chiave non esiste
+*/
+func PutIfAbsentRPC(key string, value string) {
+	args := Args{}
+	args.Key = key
+	args.Value = value
+	ConditionalRPC(ABSENT, args)
+}
+
+/*
+Effettua la RPC per inserire un'entry solamente se la chiave non è stata modificata dopo la versione letta,
+identificata dal contesto ottenuto con la Get oppure, se il contesto è vuoto, dall'istante della lettura
+*/
+func PutIfVersionRPC(key string, value string, context string, since time.Time) {
+	args := Args{}
+	args.Key = key
+	args.Value = value
+	args.Context = context
+	args.Since = since
+	ConditionalRPC(IFVERS, args)
+}
+
+/*
+Effettua una scrittura condizionata. La richiesta non viene ritrasmessa come nella semantica at-least-once,
+perchè una seconda esecuzione fallirebbe la precondizione pur essendo stata applicata la prima: scaduto il
+timeout il client non può sapere se la scrittura è avvenuta, e deve verificarlo con una Get.
+*/
+func ConditionalRPC(method string, args Args) {
+	var reply ConditionalReply
+	err := CallTypedRPC(method, args, &reply)
+	if err != nil && err.Error() == "Timeout" {
+		utils.PrintTs("Server unreachable! The write may or may not have been applied")
+		return
+	}
+	if err != nil {
+		utils.PrintTs("RPC error " + err.Error())
+		return
+	}
+	switch reply.Status {
+	case APPLIED:
+		fmt.Println(reply.Message)
+	case PRECONDITION_FAILED:
+		fmt.Println(reply.Message)
+		if reply.Current != "" {
+			fmt.Println("Current entry:")
+			fmt.Println(reply.Current)
+		}
+	default:
+		fmt.Println("Write failed: " + reply.Message)
+	}
+}
+
+/*
+Effettua la RPC per ottenere una pagina della scansione delle chiavi con il prefisso specificato.
+Il cursore è quello ritornato dalla pagina precedente, vuoto per la prima pagina.
+*/
+func ScanRPC(prefix string, cursor string, limit int) (ScanReply, error) {
+	args := Args{}
+	args.Prefix = prefix
+	args.Cursor = cursor
+	args.Limit = limit
+
+	var reply ScanReply
+	err := CallTypedRPC(SCAN, args, &reply)
+	if err != nil {
+		utils.PrintTs("RPC error " + err.Error())
+	}
+	return reply, err
+}
+
+/*
+Effettua la RPC per ottenere le statistiche del nodo scelto dal Load Balancer
+*/
+func StatsRPC() (NodeStats, error) {
+	var reply NodeStats
+	err := CallTypedRPC(STATS, Args{}, &reply)
+	if err != nil {
+		utils.PrintTs("RPC error " + err.Error())
+	}
+	return reply, err
+}
+
+/*
+Effettua la RPC per la GET di un insieme di chiavi
+*/
+func MultiGetRPC(keys []string) {
+	args := Args{}
+	args.Keys = keys
+	MultiRPC(MGET, args)
+}
+
+/*
+Effettua la RPC per il PUT di un insieme di entry, con i valori nello stesso ordine delle chiavi
+*/
+func MultiPutRPC(keys []string, values []string) {
+	args := Args{}
+	args.Keys = keys
+	args.Values = values
+	MultiRPC(MPUT, args)
+}
+
+/*
+Effettua un'operazione batch, visualizzando il risultato di ogni chiave
+*/
+func MultiRPC(method string, args Args) {
+	var reply MultiReply
+	err := CallTypedRPC(method, args, &reply)
+	if err != nil {
+		utils.PrintTs("RPC error " + err.Error())
+		return
+	}
+	for _, result := range reply.Results {
+		if result.Error != "" {
+			fmt.Println(result.Key + ": " + result.Error)
+		} else {
+			fmt.Println(result.Key + ":")
+			fmt.Println(result.Value)
+		}
+	}
+}
+
+/*
+Effettua una chiamata RPC con una risposta strutturata, attendendo al massimo il tempo di tutte le ritrasmissioni RR1.
+Ritorna l'errore "Timeout" se il server non risponde entro questo tempo.
+*/
+func CallTypedRPC(method string, args Args, reply interface{}) error {
+	client, _ := utils.HttpConnect(utils.LB_DNS_NAME, utils.RPC_PORT)
+	defer client.Close()
+
+	call := client.Go(method, args, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(utils.RR1_TIMEOUT * time.Duration(utils.RR1_RETRIES)):
+		return errors.New("Timeout")
+	}
+}
+
+/*
+Effettua la RPC per l'APPEND, specificando un nuovo time-to-live della chiave (0 per mantenere quello attuale)
+ed il livello di consistenza della scrittura
 */
-func AppendRPC(key string, value string) {
+func AppendRPC(key string, value string, ttl time.Duration, consistency string) {
 	args := Args{}
 	args.Key = key
 	args.Value = value
+	args.TTL = ttl
+	args.Consistency = consistency
 
 	var reply *string
 
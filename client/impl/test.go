@@ -55,7 +55,7 @@ func MeasureGet(key string) time.Duration {
 	utils.PrintTs("Measuring Get...")
 
 	start := utils.GetTimestamp()
-	GetRPC(key)
+	GetRPC(key, ONE)
 	end := utils.GetTimestamp()
 	ts := end.Sub(start)
 	utils.PrintTs(fmt.Sprintf("Get Executed in %f", ts.Seconds()))
@@ -69,7 +69,7 @@ func MeasurePut(key string, value string) time.Duration {
 	utils.PrintTs("Measuring Put...")
 
 	start := utils.GetTimestamp()
-	PutRPC(key, value)
+	PutRPC(key, value, 0, 0, ONE)
 	end := utils.GetTimestamp()
 	ts := end.Sub(start)
 	utils.PrintTs(fmt.Sprintf("Put Executed in %f", ts.Seconds()))
@@ -83,7 +83,7 @@ func MeasureAppend(key string, value string) time.Duration {
 	utils.PrintTs("Measuring Append...")
 
 	start := utils.GetTimestamp()
-	AppendRPC(key, value)
+	AppendRPC(key, value, 0, ONE)
 	end := utils.GetTimestamp()
 
 	ts := end.Sub(start)
@@ -23,6 +23,22 @@ func main() {
 		case cmd == "4":
 			impl.Append()
 		case cmd == "5":
+			impl.Resolve()
+		case cmd == "6":
+			impl.Versions()
+		case cmd == "7":
+			impl.Conditional()
+		case cmd == "8":
+			impl.Scan()
+		case cmd == "9":
+			impl.Batch()
+		case cmd == "10":
+			impl.Upload()
+		case cmd == "11":
+			impl.Download()
+		case cmd == "12":
+			impl.Stats()
+		case cmd == "13":
 			impl.Exit()
 		case cmd == "T" || cmd == "t":
 			impl.MeasureResponseTime()
@@ -7,13 +7,13 @@ require (
 	github.com/aws/aws-sdk-go v1.40.45
 	github.com/beevik/ntp v0.3.0
 	github.com/golang/protobuf v1.5.2
+	github.com/golang/snappy v0.0.1
 	go.mongodb.org/mongo-driver v1.7.2
 	google.golang.org/protobuf v1.27.1 // indirect
 )
 
 require (
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/golang/snappy v0.0.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/compress v1.9.5 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
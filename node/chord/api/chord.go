@@ -45,7 +45,7 @@ type ChordNode struct {
 	id     [sha256.Size]byte
 	ipaddr string
 
-	connections  map[string]net.TCPConn
+	connections  map[string]net.Conn
 	applications map[byte]ChordApp
 }
 
@@ -174,6 +174,64 @@ func (node *ChordNode) GetIpAddress() string {
 	return utils.RemovePort(node.ipaddr)
 }
 
+/*
+Indica se il nodo è il responsabile primario della chiave, cioè se questa è compresa tra l'ID del predecessore
+(escluso) e quello del nodo (incluso). Un nodo senza predecessore è responsabile di tutte le chiavi.
+*/
+func (node *ChordNode) IsResponsible(key [sha256.Size]byte) bool {
+	pred := node.GetPredecessor()
+	if pred == nil || pred.zero() || pred.id == node.id {
+		return true
+	}
+	return key == node.id || InRange(key, pred.id, node.id)
+}
+
+/*
+Ritorna l'intervallo di chiavi (start, end] di cui il nodo è responsabile primario, cioè tra l'ID del predecessore
+e quello del nodo. Un nodo senza predecessore ritorna start uguale ad end, che indica l'intero anello.
+*/
+func (node *ChordNode) GetRange() (start [sha256.Size]byte, end [sha256.Size]byte) {
+	pred := node.GetPredecessor()
+	if pred == nil || pred.zero() {
+		return node.id, node.id
+	}
+	return pred.id, node.id
+}
+
+/*
+Funzione ausiliaria che ritorna true se la chiave è compresa nell'intervallo (start, end] ritornato da GetRange
+*/
+func InKeyRange(key [sha256.Size]byte, start [sha256.Size]byte, end [sha256.Size]byte) bool {
+	if start == end {
+		return true
+	}
+	return key == end || InRange(key, start, end)
+}
+
+/*
+Ritorna gli indirizzi IP dei primi n successori attivi del nodo, seguendo la lista dei successori.
+I successori che non rispondono al ping vengono saltati, così come il nodo stesso in un anello con pochi nodi.
+*/
+func (node *ChordNode) GetSuccessors(n int) []string {
+	var succs []string
+	for i := 0; i < sha256.Size*8 && len(succs) < n; i++ {
+		successor := node.query(false, true, i, nil)
+		if successor.zero() || successor.ipaddr == node.ipaddr {
+			continue
+		}
+		addr := utils.RemovePort(successor.ipaddr)
+		if utils.StringInSlice(addr, succs) {
+			continue
+		}
+		_, err := node.send(pingMsg(), successor.ipaddr)
+		if err != nil {
+			continue
+		}
+		succs = append(succs, addr)
+	}
+	return succs
+}
+
 /*
 Ritorna l'indirizzo IP del nodo responsabile della chiave key cercata
 */
@@ -282,7 +340,7 @@ func Create(myaddr string) *ChordNode {
 
 	//initialize listener and network manager threads
 	node.listen(myaddr)
-	node.connections = make(map[string]net.TCPConn)
+	node.connections = make(map[string]net.Conn)
 	node.applications = make(map[byte]ChordApp)
 
 	//initialize maintenance and finger manager threads
@@ -28,11 +28,12 @@ func Send(msg []byte, addr string) (reply []byte, err error) {
 	}
 	//open a TCP connection with raddr, laddr is chosen automatically
 	//so we don't need to set a specific port and check if it's used
-	newconn, err := net.DialTCP("tcp", nil, raddr)
+	//the connection is encrypted with TLS if enabled in the configuration
+	newconn, err := utils.Dial(&net.Dialer{}, raddr.String())
 	if err != nil {
 		return
 	}
-	conn := *newconn
+	conn := newconn
 	checkError(err)
 	if err != nil {
 		return
@@ -75,14 +76,14 @@ func (node *ChordNode) send(msg []byte, addr string) (reply []byte, err error) {
 		if err != nil {
 			return
 		}
-		newconn, nerr := net.DialTCP("tcp", laddr, raddr)
+		newconn, nerr := utils.Dial(&net.Dialer{LocalAddr: laddr}, raddr.String())
 		if nerr != nil {
 			err = nerr
 			return
 		}
 		err = newconn.SetDeadline(time.Now().Add(3 * time.Minute))
 		checkError(err)
-		conn = *newconn
+		conn = newconn
 		node.connections[addr] = conn
 	}
 
@@ -99,14 +100,14 @@ func (node *ChordNode) send(msg []byte, addr string) (reply []byte, err error) {
 		if err != nil {
 			return
 		}
-		newconn, nerr := net.DialTCP("tcp", laddr, raddr)
+		newconn, nerr := utils.Dial(&net.Dialer{LocalAddr: laddr}, raddr.String())
 		if nerr != nil {
 			err = nerr
 			return
 		}
 		err = newconn.SetDeadline(time.Now().Add(3 * time.Minute))
 		checkError(err)
-		conn = *newconn
+		conn = newconn
 		_, err = conn.Write(msg)
 		if err != nil {
 			return
@@ -143,12 +144,12 @@ func (node *ChordNode) listen(addr string) {
 	laddr := new(net.TCPAddr)
 	laddr.IP = net.ParseIP(strings.Split(addr, ":")[0])
 	laddr.Port, _ = strconv.Atoi(strings.Split(addr, ":")[1])
-	listener, err := net.ListenTCP("tcp", laddr)
+	listener, err := utils.Listen(laddr.String())
 	checkError(err)
 	go func() {
 		defer utils.PrintTs("No longer listening")
 		for {
-			if conn, err := listener.AcceptTCP(); err == nil {
+			if conn, err := listener.Accept(); err == nil {
 				err = conn.SetDeadline(time.Now().Add(3 * time.Minute))
 				checkError(err)
 				go handleMessage(conn, c, c2)
@@ -0,0 +1,247 @@
+package impl
+
+import (
+	chord "JDSys/node/chord/api"
+	mongo "JDSys/node/mongo/api"
+	"JDSys/node/mongo/communication"
+	"JDSys/utils"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+/*
+Merkle tree di un intervallo di chiavi, con l'istante in cui è stato costruito
+*/
+type cachedTree struct {
+	tree  *mongo.MerkleTree
+	keys  keyRange
+	built time.Time
+}
+
+/*
+Ritorna il Merkle tree delle entry locali nell'intervallo (start, end]. Il tree viene mantenuto per MERKLE_CACHE_TIME,
+così che le richieste di uno stesso confronto, un livello alla volta, non lo ricostruiscano ogni volta.
+*/
+func (n *Node) rangeTree(start [32]byte, end [32]byte) *mongo.MerkleTree {
+	id := hex.EncodeToString(start[:]) + hex.EncodeToString(end[:])
+	n.merkleMutex.Lock()
+	defer n.merkleMutex.Unlock()
+	if n.merkleCache == nil {
+		n.merkleCache = make(map[string]*cachedTree)
+	}
+	if cached, ok := n.merkleCache[id]; ok && time.Since(cached.built) < utils.MERKLE_CACHE_TIME {
+		return cached.tree
+	}
+
+	tree := mongo.BuildMerkleTree(n.entriesInRange(start, end), utils.MERKLE_DEPTH)
+	n.merkleCache[id] = &cachedTree{tree: tree, keys: keyRange{start, end}, built: time.Now()}
+	return tree
+}
+
+/*
+Ritorna le entry locali, comprese quelle cancellate, con chiave nell'intervallo (start, end]
+*/
+func (n *Node) entriesInRange(start [32]byte, end [32]byte) []mongo.MongoEntry {
+	var entries []mongo.MongoEntry
+	for _, entry := range n.MongoClient.ListEntries() {
+		if chord.InKeyRange(utils.HashString(entry.Key), start, end) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+/*
+Invalida i Merkle tree mantenuti degli intervalli che contengono le chiavi specificate, dopo che queste sono
+state modificate nello storage locale da una scrittura, dal reaper o da entry ricevute da altri nodi
+*/
+func (n *Node) invalidateKeys(keys ...string) {
+	n.merkleMutex.Lock()
+	defer n.merkleMutex.Unlock()
+	for id, cached := range n.merkleCache {
+		for _, key := range keys {
+			if chord.InKeyRange(utils.HashString(key), cached.keys.start, cached.keys.end) {
+				delete(n.merkleCache, id)
+				break
+			}
+		}
+	}
+}
+
+/*
+Esegue l'anti-entropy del nodo e la propaga al successore, finchè la richiesta non torna al nodo che l'ha avviata
+*/
+func (n *Node) antiEntropyRound(handler string, succ string) {
+	start, end := n.ChordClient.GetRange()
+	for _, replica := range n.GetReplicaSet() {
+		err := n.syncRange(replica, start, end)
+		if err != nil {
+			utils.PrintTs("Anti-entropy with " + replica + " failed: " + err.Error())
+		}
+	}
+
+	if succ == handler {
+		utils.PrintTs("Reconciliation returned to the node invoked by the registry, ring updated correctly")
+		return
+	}
+	var reply string
+	client, _ := utils.HttpConnect(succ, utils.RPC_PORT)
+	defer client.Close()
+	utils.PrintTs("Reconciliation forwarded to successor: " + succ)
+	client.Call("Node.StartReconciliationRPC", &Args{Handler: handler}, &reply)
+}
+
+/*
+Confronta le chiavi nell'intervallo (start, end] con quelle di una replica. Si scambiano gli hash del Merkle tree
+un livello alla volta, scendendo solamente nei sotto-alberi che differiscono, e si trasferiscono in entrambe
+le direzioni le sole entry delle foglie diverse. Il traffico è quindi proporzionale alla divergenza delle repliche.
+Le entry vengono scambiate in gruppi compressi con l'algoritmo scelto dalla replica alla richiesta della radice,
+mentre gli hash del Merkle tree, non comprimibili, vengono inviati senza compressione.
+*/
+func (n *Node) syncRange(replica string, start [32]byte, end [32]byte) error {
+	utils.PrintHeaderL3("Anti-entropy with " + replica)
+	local := n.rangeTree(start, end)
+	indexes := []int{0}
+	compression := communication.NO_COMPRESSION
+	for level := 0; level <= local.Depth && len(indexes) > 0; level++ {
+		var remote MerkleReply
+		args := Args{RangeStart: start, RangeEnd: end, Level: level, Indexes: indexes}
+		if level == 0 {
+			args.Compressions = communication.OfferedCompressions()
+		}
+		err := callReplica(replica, "Node.MerkleRPC", args, &remote)
+		if err != nil {
+			return err
+		}
+		if level == 0 {
+			compression = remote.Compression
+		}
+		var differing []int
+		for i, index := range indexes {
+			if i >= len(remote.Hashes) || remote.Hashes[i] != local.Levels[level][index] {
+				differing = append(differing, index)
+			}
+		}
+		if level == local.Depth {
+			indexes = differing
+			break
+		}
+		indexes = nil
+		for _, index := range differing {
+			indexes = append(indexes, 2*index, 2*index+1)
+		}
+	}
+	if len(indexes) == 0 {
+		utils.PrintTs("Replica " + replica + " is in sync")
+		return nil
+	}
+
+	// Foglie diverse, si confrontano i digest delle singole entry
+	var remote DigestReply
+	err := callReplica(replica, "Node.MerkleLeavesRPC", Args{RangeStart: start, RangeEnd: end, Indexes: indexes}, &remote)
+	if err != nil {
+		return err
+	}
+	var pull []string
+	var push []string
+	for _, index := range indexes {
+		for key, digest := range local.Leaves[index] {
+			if remoteDigest, ok := remote.Digests[key]; !ok || remoteDigest != digest {
+				push = append(push, key)
+			}
+		}
+	}
+	for key, digest := range remote.Digests {
+		if localDigest, ok := local.Leaves[mongo.LeafIndex(key, local.Depth)][key]; !ok || localDigest != digest {
+			pull = append(pull, key)
+		}
+	}
+
+	// Prima si uniscono le copie della replica, così da inviarle le versioni già unite
+	me := n.ChordClient.GetIpAddress()
+	for i := 0; i < len(pull); i += utils.ANTI_ENTROPY_BATCH {
+		var reply EntriesReply
+		args := Args{Keys: pull[i:minInt(i+utils.ANTI_ENTROPY_BATCH, len(pull))], Source: me, Compression: compression}
+		err := callReplica(replica, "Node.ReadReplicasRPC", args, &reply)
+		if err != nil {
+			return err
+		}
+		entries, err := decompressEntries(reply.Batch, compression)
+		if err != nil {
+			return err
+		}
+		n.applyEntries(entries)
+	}
+
+	pushed := 0
+	for i := 0; i < len(push); i += utils.ANTI_ENTROPY_BATCH {
+		var entries []mongo.MongoEntry
+		for _, key := range push[i:minInt(i+utils.ANTI_ENTROPY_BATCH, len(push))] {
+			if entry := n.MongoClient.ReadEntry(key); entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+		started := time.Now()
+		batch, raw, err := compressEntries(entries, compression)
+		if err != nil {
+			return err
+		}
+		var reply string
+		err = callReplica(replica, "Node.StoreReplicasRPC", Args{Batch: batch, Compression: compression}, &reply)
+		if err != nil {
+			return err
+		}
+		communication.RecordTransfer(utils.ANTIE, replica, compression, raw, int64(len(batch)), time.Since(started))
+		pushed += len(entries)
+	}
+	utils.PrintTs("Anti-entropy with " + replica + ": " + strconv.Itoa(len(indexes)) + " leaves differ, " +
+		strconv.Itoa(len(pull)) + " entries pulled, " + strconv.Itoa(pushed) + " entries pushed")
+	return nil
+}
+
+/*
+Codifica un gruppo di entry dell'anti-entropy e lo comprime con l'algoritmo specificato.
+Ritorna anche la dimensione prima della compressione, per le statistiche dei trasferimenti.
+*/
+func compressEntries(entries []mongo.MongoEntry, compression string) ([]byte, int64, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buffer bytes.Buffer
+	compressor, err := communication.NewCompressor(&buffer, compression)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = compressor.Write(data)
+	if err == nil {
+		err = compressor.Close()
+	}
+	return buffer.Bytes(), int64(len(data)), err
+}
+
+/*
+Decomprime e decodifica un gruppo di entry dell'anti-entropy
+*/
+func decompressEntries(batch []byte, compression string) ([]mongo.MongoEntry, error) {
+	reader, err := communication.NewDecompressor(bytes.NewReader(batch), compression)
+	if err != nil {
+		return nil, err
+	}
+	var entries []mongo.MongoEntry
+	err = json.NewDecoder(reader).Decode(&entries)
+	return entries, err
+}
+
+/*
+Ritorna il minimo tra due interi
+*/
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
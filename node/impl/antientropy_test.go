@@ -0,0 +1,69 @@
+package impl
+
+import (
+	mongo "JDSys/node/mongo/api"
+	"JDSys/node/mongo/communication"
+	"JDSys/utils"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeTreeInvalidatedByLocalChanges(t *testing.T) {
+	n := testNode(t, "primary", "replica")
+	primary := utils.HashString("primary")
+	replica := utils.HashString("replica")
+	n.MongoClient.PutEntry("primary", []byte("v1"), "", nil, mongo.WriteOptions{Expires: time.Now().Add(-time.Second)})
+
+	// Il tree di un intervallo viene invalidato solamente dalle chiavi che contiene
+	root := n.rangeTree(replica, primary).Levels[0][0]
+	other := n.rangeTree(primary, replica)
+	n.expireEntries()
+	if n.rangeTree(replica, primary).Levels[0][0] == root {
+		t.Errorf("tree not rebuilt after the reaper expired a key")
+	}
+	if n.rangeTree(primary, replica) != other {
+		t.Errorf("tree of a range without changed keys was rebuilt")
+	}
+
+	// Le entry ricevute da altri nodi invalidano il tree che le contiene
+	var entry mongo.MongoEntry
+	entry.Key = "replica"
+	entry.Update([]byte("v1"), "", nil, "n2", time.Now())
+	n.applyEntry(entry)
+	if n.rangeTree(primary, replica) == other {
+		t.Errorf("tree not rebuilt after an entry was applied")
+	}
+	other = n.rangeTree(primary, replica)
+	entry.Update([]byte("v2"), "", entry.Context(), "n2", time.Now())
+	n.applyEntries([]mongo.MongoEntry{entry})
+	if n.rangeTree(primary, replica) == other {
+		t.Errorf("tree not rebuilt after a batch was applied")
+	}
+}
+
+func TestCompressEntriesRoundTrip(t *testing.T) {
+	var entries []mongo.MongoEntry
+	for i := 0; i < 20; i++ {
+		entry := mongo.MongoEntry{Key: "key" + strconv.Itoa(i)}
+		entry.Update([]byte(strings.Repeat("value", 50)), "text/plain", nil, "n1", time.Now())
+		entries = append(entries, entry)
+	}
+	for _, compression := range []string{communication.NO_COMPRESSION, communication.GZIP, communication.SNAPPY} {
+		batch, raw, err := compressEntries(entries, compression)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if compression != communication.NO_COMPRESSION && int64(len(batch)) >= raw {
+			t.Errorf("%s: batch of %d bytes not smaller than the %d encoded bytes", compression, len(batch), raw)
+		}
+		decoded, err := decompressEntries(batch, compression)
+		if err != nil || len(decoded) != len(entries) || decoded[7].Key != "key7" || string(decoded[7].Value) != string(entries[7].Value) {
+			t.Fatalf("%s: decoded %d entries, error %v", compression, len(decoded), err)
+		}
+	}
+	if _, err := decompressEntries([]byte("batch"), communication.GZIP); err == nil {
+		t.Errorf("corrupted batch decoded without errors")
+	}
+}
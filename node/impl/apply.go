@@ -0,0 +1,78 @@
+package impl
+
+import (
+	mongo "JDSys/node/mongo/api"
+	"JDSys/utils"
+	"encoding/binary"
+	"sync"
+)
+
+/*
+Applica allo storage locale le entry ricevute da più trasferimenti e stream di replicazione contemporaneamente.
+Le chiavi sono suddivise in gruppi in base al loro hash, ed ogni gruppo viene applicato da un solo trasferimento
+alla volta: trasferimenti con chiavi in gruppi diversi procedono in parallelo, mentre le versioni ricevute
+di una stessa chiave vengono unite una alla volta, nell'ordine in cui ogni trasferimento le ha ricevute.
+*/
+type applier struct {
+	stripes []sync.Mutex
+}
+
+/*
+Crea un applier con il numero di gruppi di chiavi specificato
+*/
+func newApplier(stripes int) *applier {
+	if stripes < 1 {
+		stripes = 1
+	}
+	return &applier{stripes: make([]sync.Mutex, stripes)}
+}
+
+/*
+Ritorna il gruppo di una chiave
+*/
+func (a *applier) stripe(key string) int {
+	hash := utils.HashString(key)
+	return int(binary.BigEndian.Uint32(hash[:4]) % uint32(len(a.stripes)))
+}
+
+/*
+Unisce un'entry ricevuta con quella locale, nel gruppo della sua chiave, ed invalida il Merkle tree che la contiene
+*/
+func (n *Node) applyEntry(entry mongo.MongoEntry) error {
+	stripe := n.applier.stripe(entry.Key)
+	n.applier.stripes[stripe].Lock()
+	defer n.applier.stripes[stripe].Unlock()
+	defer n.invalidateKeys(entry.Key)
+	return n.MongoClient.MergeEntry(entry)
+}
+
+/*
+Unisce le entry ricevute con quelle locali. Si applica un gruppo di chiavi alla volta, mantenendo l'ordine
+di ricezione, e si ritorna il primo errore dello storage dopo aver comunque applicato le altre entry.
+I Merkle tree che contengono le chiavi ricevute vengono invalidati.
+*/
+func (n *Node) applyEntries(entries []mongo.MongoEntry) error {
+	groups := make([][]mongo.MongoEntry, len(n.applier.stripes))
+	for _, entry := range entries {
+		stripe := n.applier.stripe(entry.Key)
+		groups[stripe] = append(groups[stripe], entry)
+	}
+	var first error
+	for stripe, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		n.applier.stripes[stripe].Lock()
+		err := n.MongoClient.MergeBatch(group)
+		n.applier.stripes[stripe].Unlock()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	n.invalidateKeys(keys...)
+	return first
+}
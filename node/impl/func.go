@@ -1,101 +1,130 @@
 package impl
 
 import (
+	chord "JDSys/node/chord/api"
+	mongo "JDSys/node/mongo/api"
 	"JDSys/node/mongo/communication"
 	"JDSys/utils"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var first bool
-var sendMutex *sync.Mutex
-var recvMutex *sync.Mutex
-var migrMutex *sync.Mutex
+var sendSlots chan struct{}
 
 /*
-Invia un messaggio di aggiornamento ad un nodo remoto. Con 'mode' si specifica il tipo di messaggio tra
-Reconciliation/Migration/Replication. Viene quindi esportato il file CSV dello storage locale e lo si invia al nodo remoto.
+Invia un messaggio di aggiornamento ad un nodo remoto. Con 'mode' si specifica il tipo di messaggio, la Migration.
+Vengono esportate in un file CSV temporaneo le sole entry dello storage locale con chiave nell'intervallo (start, end],
+e lo si invia al nodo remoto. Ogni invio utilizza un proprio file, così che fino a TRANSFER_MAX_SENDS invii siano in corso
+contemporaneamente e un nodo lento non blocchi quelli verso gli altri nodi.
+Le singole scritture vengono invece replicate tramite gli stream del log di replicazione.
 */
-func SendUpdateMsg(node *Node, address string, mode string, key string) error {
-	var file string
+func SendUpdateMsg(node *Node, address string, mode string, start [32]byte, end [32]byte) error {
 	var path string
-	var err error
+	var entries []mongo.MongoEntry
 
-	sendMutex.Lock()
+	sendSlots <- struct{}{}
+	defer func() { <-sendSlots }()
 
 	switch mode {
-	case utils.REPLN:
-		utils.PrintHeaderL2("Sending replica to successor " + address + " via TCP")
-		file = utils.REPLICATION_SEND_FILE
-		path = utils.REPLICATION_SEND_PATH
-		err = node.MongoClient.ExportDocument(key, file)
-	case utils.RECON:
-		utils.PrintHeaderL2("Sending reconciliation message to successor " + address + " via TCP")
-		file = utils.RECONCILIATION_SEND_FILE
-		path = utils.RECONCILIATION_SEND_PATH
-		err = node.MongoClient.ExportCollection(file)
 	case utils.MIGRN:
 		utils.PrintHeaderL3("Sending migration entries to: " + address)
-		file = utils.MIGRATION_SEND_FILE
 		path = utils.MIGRATION_SEND_PATH
-		err = node.MongoClient.ExportCollection(file)
+		entries = node.entriesInRange(start, end)
+		utils.PrintTs("Exporting " + strconv.Itoa(len(entries)) + " entries in the transferred key range")
 	}
 
+	os.MkdirAll(path, 0755)
+	file, err := os.CreateTemp(path, "export-*"+utils.CSV)
+	if err == nil {
+		file.Close()
+		defer os.Remove(file.Name())
+		err = mongo.WriteCSV(file.Name(), entries)
+	}
 	if err != nil {
-		utils.ClearDir(path)
 		utils.PrintTs("File not exported. Message not sent.")
-		sendMutex.Unlock()
 		return err
 	}
 
-	err = communication.StartSender(file, address, mode)
-
+	err = communication.StartSender(file.Name(), address, mode)
 	if err != nil {
-		utils.ClearDir(path)
 		utils.PrintTs("Message not sent.")
-		sendMutex.Unlock()
 		return err
 	}
-
-	utils.ClearDir(path)
 	utils.PrintTs("Message sent correctly.")
-	sendMutex.Unlock()
 	return nil
 }
 
 /*
-Permette ad un nodo di inviare la un'entry al suo successore per la replicazione
+Invia le entry nell'intervallo (start, end] ai nodi specificati, contemporaneamente: gli invii in corso sono
+comunque limitati a TRANSFER_MAX_SENDS da SendUpdateMsg. Ritorna i nodi che non hanno confermato di averle applicate.
 */
-func SendReplicaToSuccessor(node *Node, key string) {
-	succ := node.ChordClient.GetSuccessor().GetIpAddr()
-	if succ != "" {
-		err := SendUpdateMsg(node, succ, utils.REPLN, key)
-		if err != nil {
-			return
-		}
-		utils.PrintTs("Replica sent Correctly")
-	} else {
-		utils.PrintTs("Node hasn't a successor yet, data will be replicated later")
+func (n *Node) migrateRange(addresses []string, start [32]byte, end [32]byte) []string {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var failed []string
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			if SendUpdateMsg(n, address, utils.MIGRN, start, end) != nil {
+				mutex.Lock()
+				failed = append(failed, address)
+				mutex.Unlock()
+			}
+		}(address)
 	}
+	wg.Wait()
+	return failed
+}
+
+/*
+Ritorna il replica set attuale del nodo
+*/
+func (n *Node) GetReplicaSet() []string {
+	n.replicaMutex.RLock()
+	defer n.replicaMutex.RUnlock()
+	return append([]string(nil), n.ReplicaSet...)
 }
 
 /*
-Permette di propagare la richiesta di Delete a tutti i nodi, cancellando quindi anche le repliche presenti sull'anello
+Routine che periodicamente ricalcola il replica set, formato dai primi REPLICATION_FACTOR-1 successori attivi.
+Verso ogni successore entrato nel replica set viene aperto lo stream del log di replicazione, mentre
+quello verso un successore uscito viene chiuso.
 */
-func DeleteReplicas(node *Node, args *Args, reply *string) {
-	utils.PrintTs("Forwarding delete request")
-retry:
-	succ := node.ChordClient.GetSuccessor().GetIpAddr()
-	if succ == "" {
-		utils.PrintTs("Node hasn't a successor yet, replicas will be deleted later")
-		time.Sleep(utils.WAIT_SUCC_TIME)
-		goto retry
+func WatchReplicaSet(node *Node) {
+	for {
+		replicas := node.ChordClient.GetSuccessors(utils.REPLICATION_FACTOR - 1)
+		previous := node.GetReplicaSet()
+		if !utils.EqualStringSlices(replicas, previous) {
+			utils.PrintHeaderL2("Replica set changed: [" + strings.Join(replicas, ", ") + "]")
+			node.replicaMutex.Lock()
+			node.ReplicaSet = replicas
+			node.replicaMutex.Unlock()
+
+			for _, replica := range replicas {
+				if !utils.StringInSlice(replica, previous) {
+					stop := make(chan struct{})
+					node.streams[replica] = stop
+					go node.streamReplica(replica, stop)
+				}
+			}
+			for _, replica := range previous {
+				if !utils.StringInSlice(replica, replicas) {
+					close(node.streams[replica])
+					delete(node.streams, replica)
+					node.OpLog.Forget(replica)
+				}
+			}
+		}
+		time.Sleep(utils.REPLICA_CHECK_INTERVAL)
 	}
-	client, _ := utils.HttpConnect(succ, utils.RPC_PORT)
-	utils.PrintTs("Delete request forwarded to replication node: " + succ + utils.RPC_PORT)
-	client.Call("Node.DeleteReplicating", args, &reply)
 }
 
 /*
@@ -104,3 +133,576 @@ Gestisce gli hearthbeat del Load Balancer ed i messaggi di Terminazione dal Serv
 func lb_handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "JDSys Key-Value Storage")
 }
+
+/*
+Routine che periodicamente fa scadere le entry il cui time-to-live è terminato
+*/
+func ExpireEntries(node *Node) {
+	for {
+		time.Sleep(utils.EXPIRY_CHECK_INTERVAL)
+		utils.PrintHeaderL2("Check Expired Entries")
+		node.expireEntries()
+	}
+}
+
+/*
+Fa scadere le entry di cui il nodo è primario ed il cui time-to-live è terminato, ritornandone il numero. Ogni entry
+scaduta viene sostituita da un tombstone, che viene poi registrato nel log di replicazione così da far scadere anche
+le sue repliche: le copie di cui il nodo è replica scadono solamente quando ricevono il tombstone dal primario.
+La lista delle entry seleziona solamente le candidate: il motore di storage verifica di nuovo la scadenza
+sull'entry memorizzata, ignorando quelle riscritte nel frattempo.
+*/
+func (n *Node) expireEntries() int {
+	expired := 0
+	now := mongo.Now()
+	for _, entry := range n.MongoClient.ListEntries() {
+		if entry.IsDeleted() || !entry.IsExpired(now) || n.Ownership.Role(entry.Key) != PRIMARY {
+			continue
+		}
+		if n.MongoClient.ExpireEntry(entry.Key) == nil {
+			n.OpLog.Append(entry.Key)
+			n.invalidateKeys(entry.Key)
+			n.deleteChunks(entry.ChunkKeys())
+			expired++
+		}
+	}
+	return expired
+}
+
+/*
+Seleziona la versione di un'entry richiesta dal client, tramite il suo vector clock oppure l'istante a cui leggerla
+*/
+func selectVersion(entry *mongo.MongoEntry, args Args) string {
+	var version *mongo.Sibling
+	if args.Version != "" {
+		version = entry.VersionOf(mongo.ParseVectorClock(args.Version))
+	} else {
+		version = entry.VersionAt(args.AsOf)
+	}
+	if version == nil {
+		return "Version not found"
+	}
+	return entry.FormatVersion(version)
+}
+
+/*
+Inoltra una scrittura condizionata al nodo che gestisce la chiave. Gli errori di trasporto vengono ritornati
+al chiamante, così da non confonderli con una precondizione non verificata.
+*/
+func (n *Node) forwardConditional(args Args, reply *ConditionalReply) error {
+	if err := checkClientKey(args.Key); err != nil {
+		reply.Status = FAILED
+		reply.Message = err.Error()
+		return nil
+	}
+	me := n.ChordClient.GetIpAddress()
+	addr, err := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
+	if err != nil {
+		return err
+	}
+	client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+	defer client.Close()
+	utils.PrintTs("Checking Key Handling")
+	utils.PrintTs("Request sent to: " + utils.ParseAddrRPC(addr))
+	return client.Call("Node.ConditionalPutImpl", args, reply)
+}
+
+/*
+Ritorna il numero di chiavi per pagina di una scansione, applicando il default ed il massimo configurati
+*/
+func scanLimit(limit int) int {
+	if limit <= 0 {
+		return utils.SCAN_DEFAULT_LIMIT
+	}
+	if limit > utils.SCAN_MAX_LIMIT {
+		return utils.SCAN_MAX_LIMIT
+	}
+	return limit
+}
+
+/*
+Raggruppa le chiavi di un'operazione batch per nodo gestore ed invia in parallelo un sotto-batch ad ogni nodo.
+Se un nodo non risponde, l'errore viene riportato su tutte le chiavi del suo sotto-batch.
+Ritorna i risultati nello stesso ordine delle chiavi richieste.
+*/
+func (n *Node) forwardBatch(method string, args Args) []KeyResult {
+	me := n.ChordClient.GetIpAddress()
+	batches := make(map[string]*Args)
+	results := make(map[string]KeyResult)
+	for i, key := range args.Keys {
+		addr, err := chord.Lookup(utils.HashString(key), me+utils.CHORD_PORT)
+		if err != nil || addr == "" {
+			results[key] = KeyResult{Key: key, Error: "Handling node not found"}
+			continue
+		}
+		owner := utils.RemovePort(addr)
+		if batches[owner] == nil {
+			// Il sotto-batch mantiene le opzioni della richiesta, come consistenza e time-to-live
+			batch := args
+			batch.Keys, batch.Values, batch.ContentTypes = nil, nil, nil
+			batches[owner] = &batch
+		}
+		batches[owner].Keys = append(batches[owner].Keys, key)
+		if len(args.Values) > i {
+			batches[owner].Values = append(batches[owner].Values, args.Values[i])
+		}
+		if len(args.ContentTypes) > i {
+			batches[owner].ContentTypes = append(batches[owner].ContentTypes, args.ContentTypes[i])
+		}
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for owner, batch := range batches {
+		wg.Add(1)
+		go func(owner string, batch *Args) {
+			defer wg.Done()
+			var reply MultiReply
+			client, _ := utils.HttpConnect(owner, utils.RPC_PORT)
+			utils.PrintTs("Sub-batch of " + strconv.Itoa(len(batch.Keys)) + " keys sent to: " + owner + utils.RPC_PORT)
+			err := client.Call(method, batch, &reply)
+			client.Close()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				for _, key := range batch.Keys {
+					results[key] = KeyResult{Key: key, Error: err.Error()}
+				}
+				return
+			}
+			for _, result := range reply.Results {
+				results[result.Key] = result
+			}
+		}(owner, batch)
+	}
+	wg.Wait()
+
+	ordered := make([]KeyResult, 0, len(args.Keys))
+	for _, key := range args.Keys {
+		ordered = append(ordered, results[key])
+	}
+	return ordered
+}
+
+/*
+Suddivide un valore in chunk e li inserisce sull'anello, ciascuno sul nodo che gestisce la chiave del chunk.
+I chunk vengono inviati uno alla volta, così da non mantenere in memoria più copie del valore.
+Ritorna il manifest da memorizzare al posto del valore sotto la chiave dell'utente.
+*/
+func (n *Node) storeChunks(key string, value []byte, contentType string, consistency string) ([]byte, error) {
+	manifest, chunks := mongo.SplitChunks(key, value, contentType, utils.CHUNK_SIZE)
+	utils.PrintTs("Splitting value of " + strconv.Itoa(len(value)) + " bytes in " + strconv.Itoa(len(manifest.Chunks)) + " chunks")
+	me := n.ChordClient.GetIpAddress()
+	for chunkKey, data := range chunks {
+		addr, err := chord.Lookup(utils.HashString(chunkKey), me+utils.CHORD_PORT)
+		if err != nil {
+			return nil, err
+		}
+		var reply string
+		client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+		// Un chunk non cambia mai contenuto, quindi non è necessario mantenerne lo storico
+		args := Args{Key: chunkKey, Data: data, ContentType: mongo.CHUNK_TYPE, MaxVersions: 1, Consistency: consistency}
+		err = client.Call("Node.PutImpl", args, &reply)
+		client.Close()
+		if err != nil {
+			return nil, err
+		}
+		utils.PrintTs("Chunk " + chunkKey + " stored on: " + utils.ParseAddrRPC(addr))
+	}
+	return mongo.EncodeManifest(manifest), nil
+}
+
+/*
+Legge un chunk di un valore dal nodo che lo gestisce, specificandone l'indice all'interno del manifest.
+Il contenuto del chunk viene verificato confrontandone l'hash con la chiave.
+*/
+func (n *Node) loadChunk(key string, manifest *mongo.Manifest, index int) ([]byte, error) {
+	if index < 0 || index >= len(manifest.Chunks) {
+		return nil, errors.New("Chunk index " + strconv.Itoa(index) + " out of range")
+	}
+	chunkKey := manifest.Chunks[index]
+	me := n.ChordClient.GetIpAddress()
+	addr, err := chord.Lookup(utils.HashString(chunkKey), me+utils.CHORD_PORT)
+	if err != nil {
+		return nil, err
+	}
+	var reply ValueReply
+	client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+	err = client.Call("Node.GetValueImpl", Args{Key: chunkKey}, &reply)
+	client.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !reply.Found {
+		return nil, errors.New("Chunk " + chunkKey + " not found")
+	}
+	if mongo.ChunkKey(key, reply.Data) != chunkKey {
+		return nil, errors.New("Chunk " + chunkKey + " is corrupted")
+	}
+	return reply.Data, nil
+}
+
+/*
+Cancella dall'anello i chunk non più referenziati da una chiave, comprese le loro repliche
+*/
+func (n *Node) deleteChunks(chunkKeys []string) {
+	if len(chunkKeys) == 0 {
+		return
+	}
+	me := n.ChordClient.GetIpAddress()
+	for _, chunkKey := range chunkKeys {
+		addr, err := chord.Lookup(utils.HashString(chunkKey), me+utils.CHORD_PORT)
+		if err != nil {
+			utils.PrintTs("Unable to delete chunk " + chunkKey + ": " + err.Error())
+			continue
+		}
+		var reply string
+		args := Args{Key: chunkKey}
+		client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+		client.Call("Node.DeleteHandling", &args, &reply)
+		client.Close()
+	}
+}
+
+/*
+Ritorna i chunk referenziati dall'entry prima di una scrittura che non lo sono più dopo di essa,
+ad esempio perchè la versione che li utilizzava è stata sostituita o è uscita dallo storico
+*/
+func (n *Node) releasedChunks(key string, before []string) []string {
+	var after []string
+	if entry := n.MongoClient.ReadEntry(key); entry != nil {
+		after = entry.ChunkKeys()
+	}
+	var released []string
+	for _, chunkKey := range before {
+		if !utils.StringInSlice(chunkKey, after) {
+			released = append(released, chunkKey)
+		}
+	}
+	return released
+}
+
+/*
+Ritorna i chunk referenziati da una chiave memorizzata sul nodo
+*/
+func (n *Node) chunksOf(key string) []string {
+	entry := n.MongoClient.GetVersions(key)
+	if entry == nil {
+		return nil
+	}
+	return entry.ChunkKeys()
+}
+
+/*
+Prepara il valore di una Put ricevuta dal client. Se il valore supera CHUNK_THRESHOLD viene suddiviso in chunk
+inseriti sull'anello, e la Put scriverà il manifest al posto del valore.
+*/
+func (n *Node) prepareValue(args *Args) error {
+	err := checkClientKey(args.Key)
+	if err != nil {
+		return err
+	}
+	if value := args.value(); len(value) > utils.CHUNK_THRESHOLD {
+		manifest, err := n.storeChunks(args.Key, value, args.ContentType, args.Consistency)
+		if err != nil {
+			return errors.New("Unable to store the value chunks: " + err.Error())
+		}
+		args.Data = manifest
+		args.Value = ""
+		args.ContentType = mongo.MANIFEST_TYPE
+	}
+	return nil
+}
+
+/*
+Scrive nello storage locale l'entry di una Put, applicando numero di versioni e time-to-live richiesti.
+Ritorna il messaggio per il client, i chunk non più referenziati dopo la scrittura e se questa è avvenuta.
+*/
+func (n *Node) putLocal(args Args) (string, []string, bool) {
+	chunks := n.chunksOf(args.Key)
+	err := n.MongoClient.PutEntry(args.Key, args.value(), args.ContentType, mongo.ParseVectorClock(args.Context), args.writeOptions())
+	if err != nil && err.Error() != "Updated" {
+		return err.Error(), nil, false
+	}
+	released := n.releasedChunks(args.Key, chunks)
+	if err != nil {
+		return "Entry already exists. Correctly updated", released, true
+	}
+	return "Entry correctly inserted in the DB", released, true
+}
+
+/*
+Verifica che una chiave possa essere scritta dal client. Le chiavi dei chunk sono riservate al nodo,
+altrimenti un client potrebbe sovrascrivere o cancellare i chunk di un valore di un'altra chiave.
+*/
+func checkClientKey(key string) error {
+	if mongo.IsChunkKey(key) {
+		return errors.New("Keys starting with '" + mongo.CHUNK_PREFIX + "' are reserved")
+	}
+	return nil
+}
+
+/*
+Ritorna il numero di repliche che devono rispondere per il livello di consistenza richiesto
+*/
+func requiredAcks(consistency string) (int, error) {
+	switch consistency {
+	case "", ONE:
+		return 1, nil
+	case QUORUM:
+		return utils.REPLICATION_FACTOR/2 + 1, nil
+	case ALL:
+		return utils.REPLICATION_FACTOR, nil
+	}
+	return 0, errors.New("Unknown consistency level " + consistency)
+}
+
+/*
+Invoca una RPC su una replica, attendendo al massimo REPLICA_TIMEOUT così che una replica non raggiungibile
+non blocchi le operazioni con quorum
+*/
+func callReplica(replica string, method string, args Args, reply interface{}) error {
+	return callTimeout(replica, method, args, reply, utils.REPLICA_TIMEOUT)
+}
+
+/*
+Invoca una RPC su un nodo, attendendo al massimo timeout sia la connessione che la risposta. La connessione
+non mantiene la deadline dell'handshake, quindi la risposta viene attesa insieme ad un timer.
+*/
+func callTimeout(addr string, method string, args Args, reply interface{}, timeout time.Duration) error {
+	client, err := utils.HttpConnectTimeout(addr, utils.RPC_PORT, timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	call := client.Go(method, args, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return errors.New("Timeout")
+	}
+}
+
+/*
+Ritorna il numero di copie che devono confermare una scrittura. Se il client non specifica il livello di consistenza
+si utilizza quello del cluster, WRITE_CONSISTENCY.
+*/
+func writeAcks(consistency string) (int, error) {
+	if consistency == "" {
+		consistency = utils.WRITE_CONSISTENCY
+	}
+	return requiredAcks(consistency)
+}
+
+/*
+Registra le entry appena scritte nel log di replicazione, da cui vengono inviate al replica set tramite gli stream.
+Con consistenza ONE si risponde subito, altrimenti si attende al massimo WRITE_ACK_TIMEOUT che il log venga confermato
+da W copie, compresa quella locale. Ritorna la riga della risposta che indica le repliche che hanno confermato la scrittura,
+oppure una durabilità parziale con le repliche da cui non è arrivata la conferma.
+*/
+func (n *Node) replicateWrite(consistency string, keys ...string) string {
+	me := n.ChordClient.GetIpAddress()
+	required, err := writeAcks(consistency)
+	if err != nil {
+		required = 1
+	}
+	var seq uint64
+	for _, key := range keys {
+		seq = n.OpLog.Append(key)
+	}
+	n.invalidateKeys(keys...)
+	if required <= 1 {
+		return formatReplicas([]string{me}, required, true)
+	}
+	replicas := n.GetReplicaSet()
+	acked := n.OpLog.WaitAcks(seq, replicas, required-1, utils.WRITE_ACK_TIMEOUT)
+	answered := append([]string{me}, acked...)
+	if len(answered) >= required {
+		return formatReplicas(answered, required, true)
+	}
+	return formatPartial(answered, replicas, required)
+}
+
+/*
+Copia di una chiave ritornata da una replica durante una lettura
+*/
+type replicaCopy struct {
+	replica string
+	reply   ReplicaReply
+	err     error
+}
+
+/*
+Legge in parallelo le copie di una chiave mantenute dalle repliche, ritornando il canale su cui arrivano le risposte
+*/
+func readReplicas(key string, replicas []string) chan replicaCopy {
+	copies := make(chan replicaCopy, len(replicas))
+	for _, replica := range replicas {
+		go func(replica string) {
+			var reply ReplicaReply
+			err := callReplica(replica, "Node.ReadReplicaRPC", Args{Key: key}, &reply)
+			if err != nil {
+				utils.PrintTs("Replica " + replica + " did not answer the read: " + err.Error())
+			}
+			copies <- replicaCopy{replica, reply, err}
+		}(replica)
+	}
+	return copies
+}
+
+/*
+Legge una chiave secondo il livello di consistenza richiesto. Con consistenza ONE si ritorna la copia locale,
+altrimenti si leggono in parallelo le copie delle repliche, unendo tramite i vector clock le prime R risposte.
+In entrambi i casi le copie di tutte le repliche vengono poi confrontate in background per la read repair.
+Ritorna l'entry se non è cancellata o scaduta, le repliche che hanno risposto e se il quorum è stato raggiunto.
+*/
+func (n *Node) quorumRead(key string, consistency string) (*mongo.MongoEntry, []string, bool) {
+	me := n.ChordClient.GetIpAddress()
+	required, _ := requiredAcks(consistency)
+	if required <= 1 {
+		if utils.READ_REPAIR {
+			go n.repairReplicas(key, nil, nil, 0)
+		}
+		return n.MongoClient.GetEntry(key), []string{me}, true
+	}
+
+	replicas := n.GetReplicaSet()
+	copies := readReplicas(key, replicas)
+	var received []replicaCopy
+	merged := n.MongoClient.GetVersions(key)
+	answered := []string{me}
+	for i := 0; i < len(replicas) && len(answered) < required; i++ {
+		result := <-copies
+		received = append(received, result)
+		if result.err != nil {
+			continue
+		}
+		answered = append(answered, result.replica)
+		if !result.reply.Found {
+			continue
+		}
+		if merged == nil {
+			entry := result.reply.Entry
+			merged = &entry
+		} else {
+			entry := mongo.MergeVersions(*merged, result.reply.Entry)
+			merged = &entry
+		}
+	}
+	if utils.READ_REPAIR {
+		go n.repairReplicas(key, received, copies, len(replicas)-len(received))
+	}
+	if merged != nil && !merged.IsLive(mongo.Now()) {
+		merged = nil
+	}
+	return merged, answered, len(answered) >= required
+}
+
+/*
+Read repair di una chiave. Si attendono le copie delle repliche che non hanno ancora risposto, o se non è stata
+avviata alcuna lettura si leggono quelle dell'intero replica set, e si uniscono tramite i vector clock.
+La versione più recente viene poi inviata alle repliche rimaste indietro, compreso il nodo stesso.
+*/
+func (n *Node) repairReplicas(key string, received []replicaCopy, copies chan replicaCopy, remaining int) {
+	if copies == nil {
+		replicas := n.GetReplicaSet()
+		copies = readReplicas(key, replicas)
+		remaining = len(replicas)
+	}
+	for i := 0; i < remaining; i++ {
+		received = append(received, <-copies)
+	}
+
+	local := n.MongoClient.ReadEntry(key)
+	var merged *mongo.MongoEntry
+	if local != nil {
+		entry := *local
+		merged = &entry
+	}
+	for _, result := range received {
+		if result.err != nil || !result.reply.Found {
+			continue
+		}
+		if merged == nil {
+			entry := result.reply.Entry
+			merged = &entry
+		} else {
+			entry := mongo.MergeVersions(*merged, result.reply.Entry)
+			merged = &entry
+		}
+	}
+	if merged == nil {
+		return
+	}
+	context := merged.Context()
+
+	if local == nil || !local.Context().Equal(context) {
+		utils.PrintTs("Read repair: local copy of " + key + " is behind, updating it")
+		n.applyEntry(*merged)
+	}
+	for _, result := range received {
+		if result.err != nil || (result.reply.Found && result.reply.Entry.Context().Equal(context)) {
+			continue
+		}
+		utils.PrintTs("Read repair: replica " + result.replica + " is behind on " + key + ", sending newest version")
+		var reply string
+		err := callReplica(result.replica, "Node.StoreReplicaRPC", Args{Key: key, Entry: merged}, &reply)
+		if err != nil {
+			utils.PrintTs("Read repair of " + result.replica + " failed: " + err.Error())
+		}
+	}
+}
+
+/*
+Chiede la read repair di una chiave al nodo che la gestisce, dopo aver risposto ad una Get con la copia locale
+*/
+func (n *Node) requestRepair(key string) {
+	me := n.ChordClient.GetIpAddress()
+	addr, err := chord.Lookup(utils.HashString(key), me+utils.CHORD_PORT)
+	if err != nil {
+		return
+	}
+	handler := utils.RemovePort(addr)
+	if handler == me {
+		n.repairReplicas(key, nil, nil, 0)
+		return
+	}
+	var reply string
+	err = callReplica(handler, "Node.RepairRPC", Args{Key: key, Value: me}, &reply)
+	if err != nil {
+		utils.PrintTs("Read repair request failed: " + err.Error())
+	}
+}
+
+/*
+Formatta le repliche che hanno risposto ad un'operazione, indicando se il livello di consistenza è stato raggiunto
+*/
+func formatReplicas(answered []string, required int, ok bool) string {
+	if !ok {
+		return "Consistency level not reached, answered by " + strconv.Itoa(len(answered)) + " of " + strconv.Itoa(required) +
+			" required replicas: " + strings.Join(answered, ", ")
+	}
+	return "Answered by " + strconv.Itoa(len(answered)) + " replicas: " + strings.Join(answered, ", ")
+}
+
+/*
+Formatta il risultato di una scrittura confermata da meno copie di quelle richieste. La scrittura è stata applicata
+dal nodo che gestisce la chiave e verrà consegnata alle altre repliche dagli stream, ma potrebbe andare persa
+se il nodo terminasse prima della consegna.
+*/
+func formatPartial(answered []string, replicas []string, required int) string {
+	var missing []string
+	for _, replica := range replicas {
+		if !utils.StringInSlice(replica, answered) {
+			missing = append(missing, replica)
+		}
+	}
+	reply := "Partial durability: acknowledged by " + strconv.Itoa(len(answered)) + " of " + strconv.Itoa(required) +
+		" required replicas: " + strings.Join(answered, ", ")
+	if len(missing) > 0 {
+		reply += "; no acknowledgement from " + strings.Join(missing, ", ")
+	}
+	return reply
+}
@@ -0,0 +1,145 @@
+package impl
+
+import (
+	mongo "JDSys/node/mongo/api"
+	"JDSys/utils"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+Ritorna un nodo con storage in memoria, primario di primaryKey e replica di replicaKey
+*/
+func testNode(t *testing.T, primaryKey string, replicaKey string) *Node {
+	n := &Node{
+		MongoClient: mongo.InitMemorySystem("n1"),
+		OpLog:       OpenOpLog(filepath.Join(t.TempDir(), "oplog.log")),
+		Ownership:   &Ownership{},
+		applier:     newApplier(4),
+	}
+	primary := utils.HashString(primaryKey)
+	replica := utils.HashString(replicaKey)
+	n.Ownership.update([]keyRange{{replica, primary}, {primary, replica}}, nil)
+	return n
+}
+
+func TestExpireEntriesOnlyOnPrimary(t *testing.T) {
+	n := testNode(t, "primary", "replica")
+	expired := mongo.WriteOptions{Expires: time.Now().Add(-time.Second)}
+	n.MongoClient.PutEntry("primary", []byte("v"), "", nil, expired)
+	n.MongoClient.PutEntry("replica", []byte("v"), "", nil, expired)
+	n.MongoClient.PutEntry("live", []byte("v"), "", nil, mongo.WriteOptions{Expires: time.Now().Add(time.Hour)})
+
+	if count := n.expireEntries(); count != 1 {
+		t.Fatalf("expireEntries = %d, want 1", count)
+	}
+	if entry := n.MongoClient.ReadEntry("primary"); !entry.IsDeleted() || !entry.Timest.Equal(expired.Expires) {
+		t.Errorf("primary copy not replaced by a tombstone at its expiry: %s", entry.Format())
+	}
+	if entry := n.MongoClient.ReadEntry("replica"); entry.IsDeleted() {
+		t.Errorf("replica copy expired by the replica")
+	}
+	if entry := n.MongoClient.ReadEntry("live"); entry.IsDeleted() {
+		t.Errorf("live entry expired")
+	}
+
+	// Il tombstone viene registrato nel log, così da raggiungere le repliche
+	records, _, _ := n.OpLog.Since(1, 10)
+	if len(records) != 1 || records[0].Key != "primary" {
+		t.Fatalf("log records = %v, want the primary key only", records)
+	}
+	if count := n.expireEntries(); count != 0 {
+		t.Errorf("second expireEntries = %d, want 0", count)
+	}
+}
+
+/*
+Ritorna una porta locale libera, nel formato delle porte della configurazione
+*/
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return ":" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+/*
+Configura porta, cartelle e tentativi dei trasferimenti di migrazione per i test
+*/
+func setupMigration(t *testing.T) {
+	port := freePort(t)
+	migrationPort, sendPath, receivePath := utils.FILETR_MIGRATION_PORT, utils.MIGRATION_SEND_PATH, utils.MIGRATION_RECEIVE_PATH
+	retries, retryTime := utils.TRANSFER_RETRIES, utils.TRANSFER_RETRY_TIME
+	utils.FILETR_MIGRATION_PORT = port
+	utils.MIGRATION_SEND_PATH = t.TempDir() + "/"
+	utils.MIGRATION_RECEIVE_PATH = t.TempDir() + "/"
+	utils.TRANSFER_RETRIES, utils.TRANSFER_RETRY_TIME = 1, 0
+	sendSlots = make(chan struct{}, utils.TRANSFER_MAX_SENDS)
+	t.Cleanup(func() {
+		utils.FILETR_MIGRATION_PORT, utils.MIGRATION_SEND_PATH, utils.MIGRATION_RECEIVE_PATH = migrationPort, sendPath, receivePath
+		utils.TRANSFER_RETRIES, utils.TRANSFER_RETRY_TIME = retries, retryTime
+	})
+}
+
+/*
+Avvia la ricezione delle migrazioni sul nodo, attendendo che la porta accetti connessioni
+*/
+func listenMigrations(t *testing.T, n *Node) {
+	go ListenMigrationMessages(n)
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1"+utils.FILETR_MIGRATION_PORT)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("migration receiver not listening")
+}
+
+func TestMigrateRange(t *testing.T) {
+	setupMigration(t)
+	source := testNode(t, "primary", "replica")
+	target := testNode(t, "primary", "replica")
+	source.MongoClient.PutEntry("primary", []byte("v1"), "", nil, mongo.WriteOptions{})
+	source.MongoClient.PutEntry("replica", []byte("v1"), "", nil, mongo.WriteOptions{})
+	start, end := utils.HashString("replica"), utils.HashString("primary")
+
+	// Un nodo che non conferma la ricezione viene ritornato, così che il chiamante possa ritentare
+	if failed := source.migrateRange([]string{"127.0.0.1"}, start, end); len(failed) != 1 {
+		t.Fatalf("migrateRange to a node not listening = %v, want it failed", failed)
+	}
+
+	listenMigrations(t, target)
+	if failed := source.migrateRange([]string{"127.0.0.1"}, start, end); len(failed) != 0 {
+		t.Fatalf("migrateRange failed for %v", failed)
+	}
+	if target.MongoClient.ReadEntry("primary") == nil {
+		t.Errorf("entry in the transferred range not received")
+	}
+	if target.MongoClient.ReadEntry("replica") != nil {
+		t.Errorf("entry outside the transferred range received")
+	}
+}
+
+func TestMigrateRangeSendsConcurrently(t *testing.T) {
+	setupMigration(t)
+	utils.TRANSFER_RETRIES, utils.TRANSFER_RETRY_TIME = 2, 300*time.Millisecond
+	source := testNode(t, "primary", "replica")
+	start, end := utils.HashString("replica"), utils.HashString("primary")
+
+	// Ogni invio verso un nodo che non risponde attende un nuovo tentativo: in parallelo l'attesa non si somma
+	began := time.Now()
+	failed := source.migrateRange([]string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}, start, end)
+	if len(failed) != 3 {
+		t.Fatalf("migrateRange to nodes not listening = %v, want all failed", failed)
+	}
+	if elapsed := time.Since(began); elapsed >= 600*time.Millisecond {
+		t.Fatalf("migrateRange took %v, the sends did not run concurrently", elapsed)
+	}
+}
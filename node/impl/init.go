@@ -11,7 +11,7 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
-	"sync"
+	"strconv"
 	"time"
 )
 
@@ -20,7 +20,7 @@ Esegue tutte le attività per rendere il nodo UP & Running
 */
 func InitNode(node *Node) {
 	utils.PrintHeaderL1("NODE SETUP")
-	node.MongoClient = mongo.InitLocalSystem()
+	node.MongoClient = mongo.InitLocalSystem(utils.GetOutboundIP())
 	InitHealthyNode(node)
 	InitChordDHT(node)
 	JoinChordDHT(node)
@@ -35,7 +35,11 @@ Permette al nodo di essere rilevato come Healthy Instance dal Load Balancer e co
 func InitHealthyNode(node *Node) {
 	utils.PrintHeaderL2("Initializing EC2 node")
 	// Configura il sistema di storage locale
-	node.MongoClient = mongo.InitLocalSystem()
+	node.MongoClient = mongo.InitLocalSystem(utils.GetOutboundIP())
+
+	// Recupera il log delle scritture da replicare, comprese quelle non confermate prima dell'ultimo riavvio
+	node.OpLog = OpenOpLog(utils.OPLOG_FILE)
+	node.applier = newApplier(utils.APPLY_STRIPES)
 
 	// Inizia a ricevere gli HeartBeat dal LB
 	go StartHeartBeatListener()
@@ -43,6 +47,12 @@ func InitHealthyNode(node *Node) {
 	// Inizia a inviare valori poco acceduti su S3
 	go node.MongoClient.CheckRarelyAccessed()
 
+	// Fa scadere le entry con time-to-live e propaga la scadenza alle repliche
+	go ExpireEntries(node)
+
+	// Rimuove periodicamente i tombstone delle entry cancellate
+	go mongo.CollectTombstones(node.MongoClient)
+
 	// Attende di diventare healthy per il Load Balancer
 	utils.PrintTs("Waiting for ELB Health Checking...")
 	time.Sleep(utils.NODE_HEALTHY_TIME)
@@ -132,23 +142,29 @@ func InitRPCService(node *Node) {
 	rpc.Register(node)
 	rpc.HandleHTTP()
 
+	listener, err := utils.Listen(utils.RPC_PORT)
+	if err != nil {
+		utils.PrintTs("Listening Error: " + err.Error())
+		os.Exit(1)
+	}
 	utils.PrintTs("Start Serving RPC request on port " + utils.RPC_PORT)
 	utils.PrintTs("RPC Service Correctly Started")
-	go srv.ListenAndServe()
+	go srv.Serve(listener)
 }
 
 /*
-Inizializza i servizi per il listening dei messaggi relativi a Replication e Reconciliation
+Inizializza i servizi per il listening dei messaggi relativi alla Replication, ed i servizi che
+mantengono aggiornate le repliche
 */
 func InitListeningServices(node *Node) {
-	recvMutex = new(sync.Mutex)
-	sendMutex = new(sync.Mutex)
-	node.Handler = false
-	node.Round = 0
+	sendSlots = make(chan struct{}, utils.TRANSFER_MAX_SENDS)
+	node.streams = make(map[string]chan struct{})
+	node.Ownership = &Ownership{}
 
 	utils.PrintHeaderL2("Starting Listening Services")
-	go ListenReplicationMessages(node)
-	go ListenReconciliationMessages(node)
+	go ListenReplicationStreams(node)
+	go WatchReplicaSet(node)
+	go TrackOwnership(node)
 	time.Sleep(1 * time.Millisecond)
 }
 
@@ -168,118 +184,52 @@ func GetNodesDHT(registryAddr string) []string {
 }
 
 /*
-Finalizza il Join del Nodo nell'anello chord. Contatta il predecessore per richiedere l'invio delle sue entry.
+Finalizza il Join del Nodo nell'anello chord. Contatta il successore per richiedere l'invio delle entry
+nell'intervallo (predecessore, nuovo nodo], di cui il nodo diventa responsabile. Le repliche delle entry dei predecessori
+vengono invece inviate dai predecessori stessi, quando il nodo entra nel loro replica set.
 */
 func JoinChordDHT(node *Node) {
-	migrMutex = new(sync.Mutex)
 	go ListenMigrationMessages(node)
 
 	var reply string
 	args := Args{}
 	args.Value = node.ChordClient.GetIpAddress()
+	args.RangeStart, args.RangeEnd = node.ChordClient.GetRange()
 
-	utils.PrintHeaderL2("Asking Predecessor for his entries")
+	utils.PrintHeaderL2("Asking Successor for the entries of the node")
 	if first {
-		utils.PrintTs("First node of the ring, no predecessor!")
+		utils.PrintTs("First node of the ring, no successor!")
 		return
 	}
 
-	pred := node.ChordClient.GetPredecessor().GetIpAddr()
-	client, _ := utils.HttpConnect(pred, utils.RPC_PORT)
+	succ := node.ChordClient.GetSuccessor().GetIpAddr()
+	client, _ := utils.HttpConnect(succ, utils.RPC_PORT)
 	err := client.Call("Node.JoinRPC", args, &reply)
 	if err != nil {
 		utils.PrintTs("JoinRPC error: " + err.Error())
 		os.Exit(1)
 	}
-	utils.PrintTs(pred + ": " + reply)
-}
-
-/*
-Resta in ascolto per messaggi di replicazione dagli altri nodi. Ad ogni messaggio viene aggiornato nello storage
-locale l'informazione relativa all'entry ricevuta
-*/
-func ListenReplicationMessages(node *Node) {
-	fileChannel := make(chan string)
-
-	go communication.StartReceiver(fileChannel, recvMutex, utils.REPLN)
-	utils.PrintTs("Started Update Message listening Service")
-	for {
-		received := <-fileChannel
-		if received == "rcvd" {
-			node.MongoClient.MergeCollection(utils.REPLICATION_EXPORT_FILE, utils.REPLICATION_RECEIVE_FILE)
-			utils.ClearDir(utils.REPLICATION_RECEIVE_PATH)
-			recvMutex.Unlock()
-		}
-	}
-}
-
-/*
-Resta in ascolto per la ricezione dei messaggi di riconciliazione. Ogni volta che si riceve un messaggio vengono
-risolti i conflitti aggiornando lo storage locale
-*/
-func ListenReconciliationMessages(node *Node) {
-	fileChannel := make(chan string)
-
-	go communication.StartReceiver(fileChannel, recvMutex, utils.RECON)
-	utils.PrintTs("Started Reconciliation Message listening Service")
-	for {
-		// Si scrive sul canale per attivare la riconciliazione una volta ricevuto correttamente l'update dal predecessore
-		received := <-fileChannel
-		if received == "rcvd" {
-			node.MongoClient.ReconciliateCollection(utils.RECONCILIATION_EXPORT_FILE, utils.RECONCILIATION_RECEIVE_FILE)
-			utils.ClearDir(utils.RECONCILIATION_RECEIVE_PATH)
-			recvMutex.Unlock()
-
-			// Nodo non ha successore, aspettiamo la ricostruzione della DHT Chord finchè non viene
-			// completato l'aggiornamento dell'anello
-		retry:
-			if node.ChordClient.GetSuccessor().String() == "" {
-				utils.PrintTs("Node hasn't a successor, wait for the reconstruction...")
-				time.Sleep(utils.WAIT_SUCC_TIME)
-				goto retry
-			}
-
-			// Il nodo effettua export del DB e lo invia al successore
-			addr := node.ChordClient.GetSuccessor().GetIpAddr()
-			utils.PrintTs("DB forwarded to successor: " + addr)
-
-			// Solamente per il nodo che ha iniziato l'aggiornamento incrementiamo il contatore che ci permette
-			// di interrompere la riconciliazione dopo 2 giri non effettuando la SendUpdateMsg
-			if node.Handler {
-				node.Round++
-				if node.Round == 2 {
-					utils.PrintTs("Request returned to the node invoked by the registry two times, ring updated correctly")
-					// Ripristiniamo le variabili per le future riconciliazioni
-					node.Handler = false
-					node.Round = 0
-				} else {
-					SendUpdateMsg(node, addr, utils.RECON, "")
-				}
-				// Se il nodo è uno di quelli intermedi, si limita a propagare il messaggio di riconciliazione
-			} else {
-				SendUpdateMsg(node, addr, utils.RECON, "")
-			}
-		}
-	}
+	utils.PrintTs(succ + ": " + reply)
 }
 
 /*
 Resta in ascolto per i messaggi di leave e join dagli altri nodi. Ad ogni messaggio si effettua il merge
-delle entry ricevute con quelle presenti nello storage locale.
+delle entry ricevute con quelle presenti nello storage locale, e solo dopo il merge si conferma la ricezione al mittente.
+I messaggi di nodi diversi vengono ricevuti ed applicati contemporaneamente.
 */
 func ListenMigrationMessages(node *Node) {
-	fileChannel := make(chan string)
-
-	go communication.StartReceiver(fileChannel, migrMutex, utils.MIGRN)
 	utils.PrintTs("Started Migration listening Service")
-	for {
-		received := <-fileChannel
-		if received == "rcvd" {
-			node.MongoClient.MergeCollection(utils.MIGRATION_EXPORT_FILE, utils.MIGRATION_RECEIVE_FILE)
-			utils.ClearDir(utils.MIGRATION_RECEIVE_PATH)
-			migrMutex.Unlock()
+	communication.StartReceiver(utils.MIGRN, func(filename string) error {
+		entries, err := mongo.ParseCSV(filename)
+		if err != nil {
+			return err
 		}
-	}
+		err = node.applyEntries(entries)
+		if err == nil {
+			utils.PrintTs(strconv.Itoa(len(entries)) + " migrated entries merged")
+		}
+		return err
+	})
 }
 
 /*
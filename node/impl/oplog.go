@@ -0,0 +1,293 @@
+package impl
+
+import (
+	"JDSys/utils"
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Operazione registrata nel log di replicazione del nodo. Si registra solamente la chiave scritta,
+perchè alle repliche viene inviata la versione attuale dell'entry, che comprende le scritture precedenti.
+*/
+type LogRecord struct {
+	Seq     uint64
+	Key     string
+	Created time.Time
+}
+
+/*
+Log append-only delle scritture avvenute sul nodo, con numeri di sequenza crescenti. Ogni replica conferma
+l'offset dell'ultima operazione applicata, e le operazioni confermate da tutte le repliche vengono rimosse.
+Il log viene salvato su file così che le replicazioni dovute non vadano perse se il nodo viene riavviato.
+Id identifica il log, così che una replica non confonda gli offset di un log ricreato con quelli del precedente.
+*/
+type OpLog struct {
+	Id      string
+	mutex   sync.Mutex
+	file    string
+	writer  *os.File
+	records []LogRecord
+	last    uint64
+	acked   map[string]uint64
+	changed chan struct{}
+}
+
+/*
+Apre il log salvato nel file specificato, creandone uno nuovo se il file non esiste
+*/
+func OpenOpLog(file string) *OpLog {
+	log := &OpLog{file: file, acked: make(map[string]uint64), changed: make(chan struct{})}
+	os.MkdirAll(filepath.Dir(file), 0755)
+
+	id, err := os.ReadFile(file + ".id")
+	if err != nil || len(id) == 0 {
+		random := make([]byte, 8)
+		rand.Read(random)
+		id = []byte(hex.EncodeToString(random))
+		os.WriteFile(file+".id", id, 0644)
+		os.Remove(file)
+	}
+	log.Id = string(id)
+
+	data, err := os.Open(file)
+	if err == nil {
+		scanner := bufio.NewScanner(data)
+		for scanner.Scan() {
+			var record LogRecord
+			if json.Unmarshal(scanner.Bytes(), &record) != nil {
+				// Record troncato da un crash durante la scrittura
+				break
+			}
+			log.records = append(log.records, record)
+			log.last = record.Seq
+		}
+		data.Close()
+	}
+	log.writer, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		utils.PrintTs("OpenOpLog Error: " + err.Error())
+	}
+	utils.PrintTs("Replication log " + log.Id + " opened at offset " + strconv.FormatUint(log.last, 10))
+	return log
+}
+
+/*
+Registra la scrittura di una chiave nel log, ritornandone il numero di sequenza.
+Il record viene sincronizzato su disco prima di ritornare, così che una scrittura confermata
+al client non perda la sua replicazione se il nodo si arresta.
+*/
+func (log *OpLog) Append(key string) uint64 {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.last++
+	record := LogRecord{Seq: log.last, Key: key, Created: time.Now()}
+	log.records = append(log.records, record)
+	if log.writer != nil {
+		line, _ := json.Marshal(record)
+		_, err := log.writer.Write(append(line, '\n'))
+		if err == nil {
+			err = log.writer.Sync()
+		}
+		if err != nil {
+			utils.PrintTs("OpLog Append Error: " + err.Error())
+		}
+	}
+	if len(log.records) > utils.OPLOG_MAX_RECORDS {
+		log.compact()
+	}
+	log.notify()
+	return record.Seq
+}
+
+/*
+Ritorna al massimo max operazioni a partire dal numero di sequenza from, il primo numero di sequenza
+ancora presente nel log, ed il canale che viene chiuso alla successiva modifica del log
+*/
+func (log *OpLog) Since(from uint64, max int) ([]LogRecord, uint64, <-chan struct{}) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	first := log.first()
+	if from < first {
+		from = first
+	}
+	if from > log.last {
+		return nil, first, log.changed
+	}
+	start := len(log.records) - int(log.last-from+1)
+	if start >= len(log.records) {
+		return nil, first, log.changed
+	}
+	end := start + max
+	if end > len(log.records) {
+		end = len(log.records)
+	}
+	return append([]LogRecord(nil), log.records[start:end]...), first, log.changed
+}
+
+/*
+Ritorna il numero di sequenza dell'ultima operazione registrata
+*/
+func (log *OpLog) Last() uint64 {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return log.last
+}
+
+/*
+Registra l'offset confermato da una replica
+*/
+func (log *OpLog) Ack(replica string, seq uint64) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.acked[replica] = seq
+	log.notify()
+}
+
+/*
+Ritorna gli offset confermati dalle repliche
+*/
+func (log *OpLog) Acked() map[string]uint64 {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	acked := make(map[string]uint64)
+	for replica, seq := range log.acked {
+		acked[replica] = seq
+	}
+	return acked
+}
+
+/*
+Ritorna il numero di operazioni non ancora confermate dalla replica e l'istante della più vecchia,
+zero se la replica ha confermato tutte le operazioni o se la più vecchia è già stata rimossa dal log
+*/
+func (log *OpLog) Pending(replica string) (uint64, time.Time) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	acked := log.acked[replica]
+	if acked >= log.last {
+		return 0, time.Time{}
+	}
+	for _, record := range log.records {
+		if record.Seq > acked {
+			return log.last - acked, record.Created
+		}
+	}
+	return log.last - acked, time.Time{}
+}
+
+/*
+Smette di considerare una replica uscita dal replica set, così che le operazioni non ancora confermate
+da essa possano essere rimosse dal log
+*/
+func (log *OpLog) Forget(replica string) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	delete(log.acked, replica)
+}
+
+/*
+Attende che almeno required repliche confermino l'operazione con numero di sequenza seq, al massimo per timeout.
+Ritorna le repliche che l'hanno confermata.
+*/
+func (log *OpLog) WaitAcks(seq uint64, replicas []string, required int, timeout time.Duration) []string {
+	deadline := time.After(timeout)
+	for {
+		log.mutex.Lock()
+		var acked []string
+		for _, replica := range replicas {
+			if log.acked[replica] >= seq {
+				acked = append(acked, replica)
+			}
+		}
+		changed := log.changed
+		log.mutex.Unlock()
+		if len(acked) >= required {
+			return acked
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return acked
+		}
+	}
+}
+
+/*
+Ritorna il primo numero di sequenza presente nel log
+*/
+func (log *OpLog) first() uint64 {
+	if len(log.records) == 0 {
+		return log.last + 1
+	}
+	return log.records[0].Seq
+}
+
+/*
+Risveglia chi attende una modifica del log
+*/
+func (log *OpLog) notify() {
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+/*
+Rimuove dal log le operazioni confermate da tutte le repliche. Se il log supera ancora la metà di OPLOG_MAX_RECORDS
+si rimuovono le operazioni più vecchie: le repliche rimaste indietro riceveranno tutte le entry del nodo.
+L'ultima operazione viene sempre mantenuta, così che il numero di sequenza riprenda da essa dopo un riavvio.
+*/
+func (log *OpLog) compact() {
+	minAcked := log.last
+	for _, seq := range log.acked {
+		if seq < minAcked {
+			minAcked = seq
+		}
+	}
+	drop := 0
+	for drop < len(log.records)-1 && log.records[drop].Seq <= minAcked {
+		drop++
+	}
+	if len(log.records)-drop > utils.OPLOG_MAX_RECORDS/2 {
+		drop = len(log.records) - utils.OPLOG_MAX_RECORDS/2
+	}
+	log.records = append([]LogRecord(nil), log.records[drop:]...)
+
+	// Il file viene riscritto in una copia temporanea e poi rinominato
+	tmp := log.file + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		utils.PrintTs("OpLog Compact Error: " + err.Error())
+		return
+	}
+	writer := bufio.NewWriter(out)
+	for _, record := range log.records {
+		line, _ := json.Marshal(record)
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		utils.PrintTs("OpLog Compact Error: " + err.Error())
+		os.Remove(tmp)
+		return
+	}
+	if log.writer != nil {
+		log.writer.Close()
+	}
+	err = os.Rename(tmp, log.file)
+	if err != nil {
+		utils.PrintTs("OpLog Compact Error: " + err.Error())
+	}
+	log.writer, _ = os.OpenFile(log.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	utils.PrintTs("Replication log compacted, " + strconv.Itoa(drop) + " operations removed")
+}
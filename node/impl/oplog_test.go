@@ -0,0 +1,139 @@
+package impl
+
+import (
+	"JDSys/utils"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOpLogSince(t *testing.T) {
+	log := OpenOpLog(filepath.Join(t.TempDir(), "oplog.log"))
+	for i := 1; i <= 5; i++ {
+		if seq := log.Append("key" + strconv.Itoa(i)); seq != uint64(i) {
+			t.Fatalf("Append returned %d, want %d", seq, i)
+		}
+	}
+	tests := []struct {
+		from  uint64
+		max   int
+		first uint64
+		last  uint64
+		count int
+	}{
+		{0, 10, 1, 5, 5},
+		{1, 2, 1, 2, 2},
+		{4, 10, 4, 5, 2},
+		{5, 10, 5, 5, 1},
+		{6, 10, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		records, first, _ := log.Since(tt.from, tt.max)
+		if first != 1 {
+			t.Errorf("Since(%d): first = %d, want 1", tt.from, first)
+		}
+		if len(records) != tt.count {
+			t.Errorf("Since(%d, %d) returned %d records, want %d", tt.from, tt.max, len(records), tt.count)
+			continue
+		}
+		if tt.count > 0 && (records[0].Seq != tt.first || records[len(records)-1].Seq != tt.last) {
+			t.Errorf("Since(%d, %d) returned %d..%d, want %d..%d", tt.from, tt.max,
+				records[0].Seq, records[len(records)-1].Seq, tt.first, tt.last)
+		}
+	}
+}
+
+func TestOpLogAcks(t *testing.T) {
+	log := OpenOpLog(filepath.Join(t.TempDir(), "oplog.log"))
+	before := time.Now()
+	for i := 0; i < 4; i++ {
+		log.Append("key" + strconv.Itoa(i))
+	}
+	log.Ack("r1", 4)
+	log.Ack("r2", 1)
+
+	if pending, oldest := log.Pending("r1"); pending != 0 || !oldest.IsZero() {
+		t.Errorf("Pending(r1) = %d, %v, want 0 and no write", pending, oldest)
+	}
+	if pending, oldest := log.Pending("r2"); pending != 3 || oldest.Before(before) {
+		t.Errorf("Pending(r2) = %d, %v, want 3 writes since %v", pending, oldest, before)
+	}
+	if pending, _ := log.Pending("r3"); pending != 4 {
+		t.Errorf("Pending(r3) = %d, want 4", pending)
+	}
+
+	acked := log.WaitAcks(4, []string{"r1", "r2"}, 1, time.Second)
+	if len(acked) != 1 || acked[0] != "r1" {
+		t.Errorf("WaitAcks returned %v, want [r1]", acked)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		log.Ack("r2", 4)
+	}()
+	if acked := log.WaitAcks(4, []string{"r1", "r2"}, 2, time.Second); len(acked) != 2 {
+		t.Errorf("WaitAcks after the ack of r2 returned %v", acked)
+	}
+	if acked := log.WaitAcks(5, []string{"r1", "r2"}, 1, 10*time.Millisecond); len(acked) != 0 {
+		t.Errorf("WaitAcks of a future write returned %v", acked)
+	}
+}
+
+func TestOpLogReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "oplog.log")
+	log := OpenOpLog(file)
+	for i := 0; i < 3; i++ {
+		log.Append("key" + strconv.Itoa(i))
+	}
+	log.writer.Close()
+
+	reopened := OpenOpLog(file)
+	if reopened.Id != log.Id {
+		t.Fatalf("reopened log has id %s, want %s", reopened.Id, log.Id)
+	}
+	if seq := reopened.Append("key3"); seq != 4 {
+		t.Fatalf("Append after reopening returned %d, want 4", seq)
+	}
+	records, _, _ := reopened.Since(0, 10)
+	if len(records) != 4 || records[2].Key != "key2" {
+		t.Fatalf("reopened log has records %v", records)
+	}
+}
+
+func TestOpLogCompact(t *testing.T) {
+	max := utils.OPLOG_MAX_RECORDS
+	utils.OPLOG_MAX_RECORDS = 10
+	defer func() { utils.OPLOG_MAX_RECORDS = max }()
+
+	file := filepath.Join(t.TempDir(), "oplog.log")
+	log := OpenOpLog(file)
+	log.Ack("r1", 0)
+	for i := 1; i <= 8; i++ {
+		log.Append("key" + strconv.Itoa(i))
+	}
+	log.Ack("r1", 8)
+	for i := 9; i <= 11; i++ {
+		log.Append("key" + strconv.Itoa(i))
+	}
+
+	// Le operazioni confermate dalla replica vengono rimosse, mantenendo il numero di sequenza
+	_, first, _ := log.Since(0, 100)
+	if first != 9 || log.Last() != 11 {
+		t.Fatalf("compacted log spans %d..%d, want 9..11", first, log.Last())
+	}
+
+	// Senza conferme si mantengono solamente le ultime OPLOG_MAX_RECORDS/2 operazioni
+	for i := 12; i <= 20; i++ {
+		log.Append("key" + strconv.Itoa(i))
+	}
+	if _, first, _ = log.Since(0, 100); first != 15 {
+		t.Fatalf("log without acks starts at %d, want 15", first)
+	}
+	log.writer.Close()
+
+	reopened := OpenOpLog(file)
+	records, first, _ := reopened.Since(0, 100)
+	if first != 15 || reopened.Last() != 20 || len(records) != 6 {
+		t.Fatalf("reopened compacted log spans %d..%d with %d records", first, reopened.Last(), len(records))
+	}
+}
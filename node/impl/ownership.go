@@ -0,0 +1,306 @@
+package impl
+
+import (
+	chord "JDSys/node/chord/api"
+	mongo "JDSys/node/mongo/api"
+	"JDSys/utils"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var PRIMARY string = "Primary" // Il nodo è il responsabile della chiave
+var REPLICA string = "Replica" // Il nodo fa parte del replica set del responsabile della chiave
+var STALE string = "Stale"     // Il nodo non è più responsabile della chiave, l'entry verrà rimossa dopo il passaggio al responsabile
+
+/*
+Intervallo di chiavi (start, end] dell'anello
+*/
+type keyRange struct {
+	start [32]byte
+	end   [32]byte
+}
+
+/*
+Intervallo di chiavi di cui un nodo è responsabile primario, ed il suo predecessore
+*/
+type RangeReply struct {
+	Start       [32]byte
+	End         [32]byte
+	Predecessor string
+}
+
+/*
+Esito del passaggio di un gruppo di entry al nodo che ne è responsabile. Il passaggio è confermato solamente
+se il responsabile ha memorizzato le entry e tutto il suo replica set ne ha confermato la replicazione.
+*/
+type HandoffReply struct {
+	Confirmed bool
+	Replicas  []string
+}
+
+/*
+Ruolo del nodo per ogni entry memorizzata. Gli intervalli di chiavi di cui il nodo è responsabile, come primario
+o come replica dei suoi predecessori, vengono ricalcolati quando cambiano predecessore o successore.
+Per le entry di cui il nodo non è più responsabile si registra da quando lo sono, così da rimuoverle
+solamente dopo REPLICA_GC_GRACE e dopo che il responsabile ne ha confermato la ricezione.
+*/
+type Ownership struct {
+	mutex      sync.Mutex
+	ranges     []keyRange
+	pred       string
+	succ       string
+	roles      map[string]string
+	staleSince map[string]time.Time
+}
+
+/*
+Ritorna il ruolo del nodo per una chiave, vuoto se gli intervalli non sono ancora stati calcolati
+*/
+func (own *Ownership) Role(key string) string {
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	if role, ok := own.roles[key]; ok {
+		return role
+	}
+	return own.roleOf(key)
+}
+
+/*
+Ritorna il numero di entry memorizzate per ogni ruolo
+*/
+func (own *Ownership) Counts() map[string]int {
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	counts := make(map[string]int)
+	for _, role := range own.roles {
+		counts[role]++
+	}
+	return counts
+}
+
+/*
+Calcola il ruolo del nodo per una chiave dagli intervalli di cui è responsabile
+*/
+func (own *Ownership) roleOf(key string) string {
+	if len(own.ranges) == 0 {
+		return ""
+	}
+	hash := utils.HashString(key)
+	for i, r := range own.ranges {
+		if chord.InKeyRange(hash, r.start, r.end) {
+			if i == 0 {
+				return PRIMARY
+			}
+			return REPLICA
+		}
+	}
+	return STALE
+}
+
+/*
+Aggiorna gli intervalli di cui il nodo è responsabile e ricalcola il ruolo di tutte le entry memorizzate
+*/
+func (own *Ownership) update(ranges []keyRange, keys []string) {
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	own.ranges = ranges
+	roles := make(map[string]string)
+	staleSince := make(map[string]time.Time)
+	for _, key := range keys {
+		role := own.roleOf(key)
+		roles[key] = role
+		if role != STALE {
+			continue
+		}
+		if since, ok := own.staleSince[key]; ok {
+			staleSince[key] = since
+		} else {
+			staleSince[key] = time.Now()
+		}
+	}
+	own.roles = roles
+	own.staleSince = staleSince
+}
+
+/*
+Ritorna le chiavi di cui il nodo non è più responsabile da almeno grace
+*/
+func (own *Ownership) staleKeys(grace time.Duration) []string {
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	var keys []string
+	for key, since := range own.staleSince {
+		if time.Since(since) >= grace {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+/*
+Rimuove una chiave rimossa dallo storage locale
+*/
+func (own *Ownership) forget(key string) {
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	delete(own.roles, key)
+	delete(own.staleSince, key)
+}
+
+/*
+Ritorna l'indirizzo IP del predecessore, vuoto se non è ancora noto
+*/
+func (n *Node) predecessorAddr() string {
+	pred := n.ChordClient.GetPredecessor()
+	if pred == nil {
+		return ""
+	}
+	return pred.GetIpAddr()
+}
+
+/*
+Calcola gli intervalli di chiavi di cui il nodo è responsabile: il primo è quello di cui è primario, i successivi
+quelli dei REPLICATION_FACTOR-1 predecessori, di cui il nodo mantiene le repliche. In un anello con al massimo
+REPLICATION_FACTOR nodi il nodo è responsabile dell'intero anello.
+*/
+func (n *Node) responsibleRanges() ([]keyRange, error) {
+	start, end := n.ChordClient.GetRange()
+	ranges := []keyRange{{start, end}}
+	if start == end {
+		return ranges, nil
+	}
+	pred := n.predecessorAddr()
+	for i := 1; i < utils.REPLICATION_FACTOR; i++ {
+		if pred == "" {
+			return nil, errors.New("Predecessor unknown")
+		}
+		var reply RangeReply
+		err := callReplica(pred, "Node.RangeRPC", Args{}, &reply)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Start == reply.End || reply.Start == end {
+			// L'anello ha fatto il giro: le repliche coprono tutte le chiavi
+			return []keyRange{{end, end}}, nil
+		}
+		ranges = append(ranges, keyRange{reply.Start, reply.End})
+		pred = reply.Predecessor
+	}
+	return ranges, nil
+}
+
+/*
+Ricalcola gli intervalli di cui il nodo è responsabile ed il ruolo di tutte le entry memorizzate
+*/
+func (n *Node) refreshOwnership() error {
+	ranges, err := n.responsibleRanges()
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, entry := range n.MongoClient.ListEntries() {
+		keys = append(keys, entry.Key)
+	}
+	n.Ownership.update(ranges, keys)
+	return nil
+}
+
+/*
+Routine che mantiene aggiornato il ruolo del nodo per le entry memorizzate. I ruoli vengono ricalcolati quando
+cambiano predecessore o successore, e comunque prima di ogni raccolta delle entry di cui il nodo non è più
+responsabile, che vengono passate al responsabile e poi rimosse.
+*/
+func TrackOwnership(node *Node) {
+	lastCollection := time.Now()
+	for {
+		time.Sleep(utils.OWNERSHIP_CHECK_INTERVAL)
+		pred := node.predecessorAddr()
+		succ := node.ChordClient.GetSuccessor().GetIpAddr()
+		changed := pred != node.Ownership.pred || succ != node.Ownership.succ
+		collect := time.Since(lastCollection) >= utils.REPLICA_GC_INTERVAL
+		if !changed && !collect {
+			continue
+		}
+		if changed {
+			utils.PrintHeaderL2("Neighbours changed, recomputing entry roles")
+		}
+		err := node.refreshOwnership()
+		if err != nil {
+			utils.PrintTs("Unable to compute the node key ranges: " + err.Error())
+			continue
+		}
+		node.Ownership.pred = pred
+		node.Ownership.succ = succ
+		counts := node.Ownership.Counts()
+		utils.PrintTs("Entry roles: " + strconv.Itoa(counts[PRIMARY]) + " primary, " + strconv.Itoa(counts[REPLICA]) +
+			" replica, " + strconv.Itoa(counts[STALE]) + " stale")
+		if collect {
+			node.collectStale()
+			lastCollection = time.Now()
+		}
+	}
+}
+
+/*
+Rimuove le entry di cui il nodo non è più responsabile da almeno REPLICA_GC_GRACE. Le entry vengono prima
+inviate al nodo che ne è responsabile, e rimosse solamente se questo conferma di averle replicate sul suo replica set.
+Un'entry modificata dopo l'invio non viene rimossa, e verrà inviata di nuovo alla raccolta successiva.
+*/
+func (n *Node) collectStale() {
+	keys := n.Ownership.staleKeys(utils.REPLICA_GC_GRACE)
+	if len(keys) == 0 {
+		return
+	}
+	utils.PrintHeaderL2("Collecting " + strconv.Itoa(len(keys)) + " stale entries")
+	me := n.ChordClient.GetIpAddress()
+	owners := make(map[string][]mongo.MongoEntry)
+	for _, key := range keys {
+		entry := n.MongoClient.ReadEntry(key)
+		if entry == nil {
+			n.Ownership.forget(key)
+			continue
+		}
+		addr, err := chord.Lookup(utils.HashString(key), me+utils.CHORD_PORT)
+		if err != nil || utils.RemovePort(addr) == me {
+			// L'anello non è ancora stabile, si riprova alla prossima raccolta
+			continue
+		}
+		owner := utils.RemovePort(addr)
+		owners[owner] = append(owners[owner], *entry)
+	}
+
+	removed := 0
+	for owner, entries := range owners {
+		removed += n.handoff(owner, entries)
+	}
+	utils.PrintTs(strconv.Itoa(removed) + " stale entries removed")
+}
+
+/*
+Passa le entry al nodo che ne è responsabile, un gruppo alla volta, e rimuove quelle di cui conferma la replicazione.
+Ci si ferma al primo gruppo non confermato, ritornando il numero di entry rimosse.
+*/
+func (n *Node) handoff(owner string, entries []mongo.MongoEntry) int {
+	removed := 0
+	for i := 0; i < len(entries); i += utils.ANTI_ENTROPY_BATCH {
+		batch := entries[i:minInt(i+utils.ANTI_ENTROPY_BATCH, len(entries))]
+		// Il responsabile attende al massimo WRITE_ACK_TIMEOUT la conferma delle sue repliche prima di rispondere
+		var reply HandoffReply
+		err := callTimeout(owner, "Node.HandoffRPC", Args{Entries: batch}, &reply, utils.REPLICA_TIMEOUT+utils.WRITE_ACK_TIMEOUT)
+		if err != nil || !reply.Confirmed {
+			utils.PrintTs("Handoff to " + owner + " not confirmed, entries kept")
+			break
+		}
+		for _, entry := range batch {
+			if n.MongoClient.PurgeVersion(entry.Key, entry.Context()) != nil {
+				continue
+			}
+			n.Ownership.forget(entry.Key)
+			n.invalidateKeys(entry.Key)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,142 @@
+package impl
+
+import (
+	mongo "JDSys/node/mongo/api"
+	"JDSys/utils"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+Avvia un server RPC locale che risponde come "Node" con il receiver specificato, sulla porta RPC dei test
+*/
+func serveRPC(t *testing.T, receiver interface{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Node", receiver)
+	go http.Serve(listener, server)
+	port := utils.RPC_PORT
+	utils.RPC_PORT = ":" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	t.Cleanup(func() {
+		listener.Close()
+		utils.RPC_PORT = port
+	})
+}
+
+/*
+Responsabile delle entry passate da un nodo, che conferma o meno la loro replicazione
+*/
+type handoffOwner struct {
+	confirm  bool
+	block    chan struct{}
+	received []mongo.MongoEntry
+}
+
+func (o *handoffOwner) HandoffRPC(args Args, reply *HandoffReply) error {
+	if o.block != nil {
+		<-o.block
+	}
+	o.received = append(o.received, args.Entries...)
+	reply.Confirmed = o.confirm
+	return nil
+}
+
+func TestOwnershipRoles(t *testing.T) {
+	own := &Ownership{}
+	if role := own.Role("primary"); role != "" {
+		t.Fatalf("Role before the ranges are computed = %q, want empty", role)
+	}
+	primary := utils.HashString("primary")
+	replica := utils.HashString("replica")
+	// Intervalli che coprono solamente le due chiavi, così che ogni altra chiave sia stale
+	own.update([]keyRange{{minusOne(primary), primary}, {minusOne(replica), replica}}, []string{"primary", "replica", "other"})
+	for key, want := range map[string]string{"primary": PRIMARY, "replica": REPLICA, "other": STALE} {
+		if role := own.Role(key); role != want {
+			t.Errorf("Role(%s) = %s, want %s", key, role, want)
+		}
+	}
+	if keys := own.staleKeys(time.Hour); len(keys) != 0 {
+		t.Errorf("staleKeys before the grace period = %v", keys)
+	}
+	if keys := own.staleKeys(0); len(keys) != 1 || keys[0] != "other" {
+		t.Errorf("staleKeys = %v, want [other]", keys)
+	}
+	own.forget("other")
+	if keys := own.staleKeys(0); len(keys) != 0 {
+		t.Errorf("staleKeys after forget = %v", keys)
+	}
+}
+
+func TestHandoffRemovesConfirmedEntries(t *testing.T) {
+	owner := &handoffOwner{confirm: true}
+	serveRPC(t, owner)
+	n := testNode(t, "primary", "replica")
+	n.MongoClient.PutEntry("stale", []byte("v1"), "", nil, mongo.WriteOptions{})
+	n.MongoClient.PutEntry("changed", []byte("v1"), "", nil, mongo.WriteOptions{})
+	entries := []mongo.MongoEntry{*n.MongoClient.ReadEntry("stale"), *n.MongoClient.ReadEntry("changed")}
+
+	// Un'entry modificata dopo la lettura non viene rimossa, e sarà inviata di nuovo alla raccolta successiva
+	n.MongoClient.PutEntry("changed", []byte("v2"), "", nil, mongo.WriteOptions{})
+	if removed := n.handoff("127.0.0.1", entries); removed != 1 {
+		t.Fatalf("handoff removed %d entries, want 1", removed)
+	}
+	if len(owner.received) != 2 {
+		t.Errorf("owner received %d entries, want 2", len(owner.received))
+	}
+	if n.MongoClient.ReadEntry("stale") != nil || n.MongoClient.ReadEntry("changed") == nil {
+		t.Errorf("only the unchanged entry should have been removed")
+	}
+}
+
+func TestHandoffKeepsUnconfirmedEntries(t *testing.T) {
+	owner := &handoffOwner{confirm: false}
+	serveRPC(t, owner)
+	n := testNode(t, "primary", "replica")
+	n.MongoClient.PutEntry("stale", []byte("v1"), "", nil, mongo.WriteOptions{})
+	entries := []mongo.MongoEntry{*n.MongoClient.ReadEntry("stale")}
+	if removed := n.handoff("127.0.0.1", entries); removed != 0 || n.MongoClient.ReadEntry("stale") == nil {
+		t.Fatalf("unconfirmed handoff removed the entry")
+	}
+}
+
+func TestHandoffTimesOut(t *testing.T) {
+	owner := &handoffOwner{confirm: true, block: make(chan struct{})}
+	defer close(owner.block)
+	serveRPC(t, owner)
+	timeout, ackTimeout := utils.REPLICA_TIMEOUT, utils.WRITE_ACK_TIMEOUT
+	utils.REPLICA_TIMEOUT, utils.WRITE_ACK_TIMEOUT = 100*time.Millisecond, 100*time.Millisecond
+	defer func() { utils.REPLICA_TIMEOUT, utils.WRITE_ACK_TIMEOUT = timeout, ackTimeout }()
+
+	n := testNode(t, "primary", "replica")
+	n.MongoClient.PutEntry("stale", []byte("v1"), "", nil, mongo.WriteOptions{})
+	done := make(chan int)
+	go func() { done <- n.handoff("127.0.0.1", []mongo.MongoEntry{*n.MongoClient.ReadEntry("stale")}) }()
+	select {
+	case removed := <-done:
+		if removed != 0 || n.MongoClient.ReadEntry("stale") == nil {
+			t.Fatalf("handoff without reply removed the entry")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handoff blocked on an owner that does not reply")
+	}
+}
+
+/*
+Ritorna l'hash precedente, così che l'intervallo (minusOne(h), h] contenga solamente h
+*/
+func minusOne(hash [32]byte) [32]byte {
+	for i := len(hash) - 1; i >= 0; i-- {
+		hash[i]--
+		if hash[i] != 0xff {
+			break
+		}
+	}
+	return hash
+}
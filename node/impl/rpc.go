@@ -3,8 +3,14 @@ package impl
 import (
 	chord "JDSys/node/chord/api"
 	mongo "JDSys/node/mongo/api"
+	"JDSys/node/mongo/communication"
 	"JDSys/utils"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,12 +18,26 @@ import (
 Struttura che mantiene tutte le informazioni di un nodo
 */
 type Node struct {
-	MongoClient mongo.MongoInstance
+	MongoClient mongo.StorageEngine
 	ChordClient *chord.ChordNode
 
-	// Variabili per la realizzazione della consistenza finale
-	Handler bool
-	Round   int
+	// Successori che mantengono le repliche delle entry scritte sul nodo
+	ReplicaSet   []string
+	replicaMutex sync.RWMutex
+
+	// Log delle scritture da replicare, e stream aperti verso le repliche
+	OpLog   *OpLog
+	streams map[string]chan struct{}
+
+	// Ruolo del nodo per le entry memorizzate
+	Ownership *Ownership
+
+	// Applicazione concorrente delle entry ricevute da trasferimenti e stream di replicazione
+	applier *applier
+
+	// Merkle tree degli intervalli di chiavi confrontati durante l'anti-entropy
+	merkleCache map[string]*cachedTree
+	merkleMutex sync.Mutex
 }
 
 /*
@@ -27,21 +47,212 @@ type Args struct {
 	Key     string
 	Value   string
 	Handler string
-	Deleted bool
+	Context string // Vector clock della versione letta dal client, vuoto per una scrittura cieca
+
+	// Gestione dello storico delle versioni
+	MaxVersions int       // Numero di versioni da mantenere per la chiave, 0 per il valore di default del nodo
+	Version     string    // Vector clock della versione da leggere, vuoto per non selezionare per versione
+	AsOf        time.Time // Istante a cui leggere la chiave, zero per non selezionare per tempo
+
+	TTL time.Duration // Time-to-live della chiave scritta con Put, Append o scrittura condizionata, zero per nessuna scadenza
+
+	// Scritture condizionate
+	Condition string    // Precondizione da verificare, impostata dalla RPC invocata dal client
+	Expected  string    // Valore atteso dalla Compare-And-Swap
+	Since     time.Time // Istante dopo il quale la chiave non deve essere stata modificata, se Context è vuoto
+
+	// Scansione delle chiavi
+	Prefix string // Prefisso delle chiavi da ritornare, vuoto per tutte le chiavi
+	Cursor string // Ultima chiave ritornata dalla pagina precedente, vuoto per la prima pagina
+	Limit  int    // Numero massimo di chiavi per pagina
+
+	// Operazioni batch
+	Keys         []string
+	Values       []string // Valori da inserire con MultiPut, nello stesso ordine delle chiavi
+	ContentTypes []string // Content-type dei valori della MultiPut suddivisi in chunk, vuoto per utilizzare ContentType
+
+	// Valori binari
+	Data        []byte // Valore binario da scrivere, se presente sostituisce Value
+	ContentType string // Content-type opzionale del valore scritto
+	Chunk       int    // Indice del chunk da leggere di un valore suddiviso in chunk
+
+	// Consistenza configurabile
+	Consistency string            // Livello di consistenza di Get, Put e Append (ONE, QUORUM, ALL), vuoto per ONE
+	Entry       *mongo.MongoEntry // Entry da unire nello storage di una replica
+
+	// Anti-entropy e trasferimento delle entry al join
+	RangeStart [32]byte           // Inizio dell'intervallo (RangeStart, RangeEnd] delle chiavi confrontate o trasferite
+	RangeEnd   [32]byte           // Fine dell'intervallo, compresa
+	Level      int                // Livello del Merkle tree dei nodi richiesti, 0 per la radice
+	Indexes    []int              // Indici dei nodi richiesti all'interno del livello
+	Entries    []mongo.MongoEntry // Entry da unire nello storage di una replica
+
+	// Compressione dei gruppi di entry dell'anti-entropy
+	Source       string   // Indirizzo del nodo che avvia l'anti-entropy, per le statistiche dei trasferimenti
+	Compressions []string // Compressioni proposte dal nodo che avvia l'anti-entropy
+	Compression  string   // Compressione negoziata per i gruppi di entry
+	Batch        []byte   // Gruppo di entry compresso inviato ad una replica
+}
+
+/*
+Ritorna il valore da scrivere inviato dal client: il valore binario se presente, altrimenti quello testuale
+*/
+func (args *Args) value() []byte {
+	if args.Data != nil {
+		return args.Data
+	}
+	return []byte(args.Value)
+}
+
+/*
+Ritorna numero di versioni e scadenza richiesti dal client, applicati dal motore di storage insieme alla scrittura
+*/
+func (args *Args) writeOptions() mongo.WriteOptions {
+	opts := mongo.WriteOptions{MaxVersions: args.MaxVersions}
+	if args.TTL > 0 {
+		opts.Expires = mongo.ExpiryAfter(args.TTL)
+	}
+	return opts
+}
+
+/*
+Valore binario di una chiave, ritornato senza alcuna formattazione così da poter essere salvato su file dal client.
+In presenza di versioni concorrenti viene ritornata quella principale, e Versions ne indica il numero.
+*/
+type ValueReply struct {
+	Found       bool
+	Data        []byte
+	ContentType string
+	Versions    int
+	Context     string
+	Chunks      int // Numero di chunk del valore, 0 se Data contiene l'intero valore
+	Size        int // Dimensione dell'intero valore suddiviso in chunk
+}
+
+/*
+Risultato di un'operazione batch su una singola chiave. Error è vuoto se l'operazione è avvenuta con successo.
+*/
+type KeyResult struct {
+	Key   string
+	Value string
+	Error string
+}
+
+/*
+Risultati di un'operazione batch, nello stesso ordine delle chiavi richieste
+*/
+type MultiReply struct {
+	Results []KeyResult
+}
+
+/*
+Chiave ritornata dalla scansione, con il valore della sua versione principale
+*/
+type ScanItem struct {
+	Key     string
+	Value   string
+	OnCloud bool // la chiave è migrata sul cloud ed il valore non viene ritornato
+}
+
+/*
+Pagina di una scansione. NextCursor va inviato nella richiesta successiva, ed è vuoto sull'ultima pagina.
+Successor viene utilizzato dal nodo che coordina la scansione per proseguire lungo l'anello.
+*/
+type ScanReply struct {
+	Items      []ScanItem
+	NextCursor string
+	More       bool
+	Successor  string
+}
+
+var ONE string = "ONE"       // Risponde il solo nodo che gestisce la chiave, le repliche vengono aggiornate in background
+var QUORUM string = "QUORUM" // Rispondono la maggioranza delle REPLICATION_FACTOR copie della chiave
+var ALL string = "ALL"       // Rispondono tutte le REPLICATION_FACTOR copie della chiave
+
+/*
+Copia di un'entry letta da un nodo del replica set, compresi storico e tombstone
+*/
+type ReplicaReply struct {
+	Found bool
+	Entry mongo.MongoEntry
+}
+
+/*
+Statistiche di un nodo, visualizzabili dal client
+*/
+type NodeStats struct {
+	Address    string
+	Entries    int
+	ReplicaSet []string
+	LogOffset  uint64                        // numero di sequenza dell'ultima scrittura registrata nel log di replicazione
+	Acked      map[string]uint64             // offset del log confermato da ogni replica
+	Hints      map[string]uint64             // scritture del log non ancora confermate da ogni replica
+	OldestHint map[string]time.Time          // istante della scrittura più vecchia non confermata da ogni replica
+	Roles      map[string]int                // numero di entry memorizzate per ogni ruolo del nodo
+	Transfers  []communication.TransferStats // compressione e durata dei trasferimenti inviati ad ogni nodo
+}
+
+/*
+Hash dei nodi richiesti di un Merkle tree, nello stesso ordine degli indici
+*/
+type MerkleReply struct {
+	Hashes      [][32]byte
+	Compression string // Compressione dei gruppi di entry, scelta dalla replica tra quelle proposte
+}
+
+/*
+Digest delle entry contenute nelle foglie richieste di un Merkle tree
+*/
+type DigestReply struct {
+	Digests map[string][32]byte
+}
+
+/*
+Entry lette da una replica, compresi storico e tombstone, compresse con l'algoritmo richiesto
+*/
+type EntriesReply struct {
+	Batch []byte
+}
+
+var APPLIED string = "Applied"
+var PRECONDITION_FAILED string = "PreconditionFailed"
+var FAILED string = "Failed"
+
+/*
+Risultato di una scrittura condizionata. Lo stato permette al client di distinguere una precondizione non
+verificata dagli altri errori, mentre un errore di trasporto viene ritornato direttamente dalla RPC.
+*/
+type ConditionalReply struct {
+	Status  string // APPLIED, PRECONDITION_FAILED oppure FAILED
+	Message string
+	Current string // Entry memorizzata al momento della verifica, per permettere al client di ritentare
 }
 
 /*
 Effettua la RPC per la Get di una Key.
- 1) Si verifica se il nodo ha una copia della risorsa
+ 1) Con consistenza ONE si verifica se il nodo ha una copia della risorsa, chiedendo la read repair al nodo che la gestisce
  2) Lookup per trovare il nodo che hosta la risorsa
- 3) RPC effettiva di GET verso quel nodo chord
+ 3) RPC effettiva di GET verso quel nodo chord, che attende la risposta di R repliche ed avvia la read repair
 */
 func (n *Node) GetRPC(args *Args, reply *string) error {
 	utils.PrintHeaderL2("Received Get RPC for key " + args.Key)
-	utils.PrintTs("Checking value on local storage")
-	entry := n.MongoClient.GetEntry(args.Key)
+	if _, err := requiredAcks(args.Consistency); err != nil {
+		*reply = err.Error()
+		return nil
+	}
+
+	// La copia locale è sufficiente solo se la richiesta non richiede il quorum delle repliche,
+	// e se il nodo è ancora responsabile della chiave come primario o come replica
+	var entry *mongo.MongoEntry
+	if (args.Consistency == "" || args.Consistency == ONE) && n.Ownership.Role(args.Key) != STALE {
+		utils.PrintTs("Checking value on local storage")
+		entry = n.MongoClient.GetEntry(args.Key)
+	}
 	if entry != nil {
-		*reply = entry.FormatClient()
+		*reply = entry.FormatClient() + "\n" + formatReplicas([]string{n.ChordClient.GetIpAddress()}, 1, true)
+		if utils.READ_REPAIR {
+			go n.requestRepair(args.Key)
+		}
 		utils.PrintTs("Generating RPC Reply:")
 		fmt.Println(*reply)
 		utils.PrintTs("Finished. Replying to caller")
@@ -67,13 +278,197 @@ func (n *Node) GetRPC(args *Args, reply *string) error {
 	return nil
 }
 
+/*
+Effettua la RPC per leggere il valore binario di una Key.
+ 1) Lookup per trovare il nodo che hosta la risorsa
+ 2) RPC effettiva di GET VALUE verso quel nodo chord
+ 3) Se il valore è suddiviso in chunk, si ritorna solamente il chunk di indice Chunk, insieme al numero di chunk.
+    Il client legge un chunk alla volta, così che né il nodo né il client mantengano in memoria l'intero valore.
+    Se il client specifica il contesto della prima lettura, un valore modificato nel frattempo viene rifiutato.
+*/
+func (n *Node) GetValueRPC(args Args, reply *ValueReply) error {
+	utils.PrintHeaderL2("Received Get Value RPC for key " + args.Key)
+
+	me := n.ChordClient.GetIpAddress()
+	addr, err := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
+	if err != nil {
+		return err
+	}
+	client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+	defer client.Close()
+	utils.PrintTs("Checking Key Handling")
+	utils.PrintTs("Request sent to: " + utils.ParseAddrRPC(addr))
+	err = client.Call("Node.GetValueImpl", args, reply)
+	if err != nil {
+		return err
+	}
+
+	// Il valore è suddiviso in chunk, leggiamo quello richiesto dal nodo che lo gestisce
+	if manifest := mongo.DecodeManifest(reply.Data, reply.ContentType); manifest != nil {
+		if args.Context != "" && args.Context != reply.Context {
+			return errors.New("Value changed during the download")
+		}
+		utils.PrintTs("Reading chunk " + strconv.Itoa(args.Chunk+1) + " of " + strconv.Itoa(len(manifest.Chunks)))
+		chunk, err := n.loadChunk(args.Key, manifest, args.Chunk)
+		if err != nil {
+			utils.PrintTs("Chunk Error: " + err.Error())
+			return err
+		}
+		reply.Data = chunk
+		reply.ContentType = manifest.ContentType
+		reply.Chunks = len(manifest.Chunks)
+		reply.Size = manifest.Size
+	}
+	return nil
+}
+
+/*
+Effettua la RPC per leggere lo storico delle versioni di una Key.
+ 1) Lookup per trovare il nodo che hosta la risorsa
+ 2) RPC effettiva di GET VERSIONS verso quel nodo chord
+*/
+func (n *Node) GetVersionsRPC(args Args, reply *string) error {
+	utils.PrintHeaderL2("Received Get Versions RPC for key " + args.Key)
+
+	me := n.ChordClient.GetIpAddress()
+	addr, _ := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
+	client, _ := utils.HttpConnect(utils.RemovePort(addr), utils.RPC_PORT)
+	utils.PrintTs("Checking Key Handling")
+	utils.PrintTs("Request sent to: " + utils.ParseAddrRPC(addr))
+
+	client.Call("Node.GetVersionsImpl", args, &reply)
+	return nil
+}
+
+/*
+Effettua la RPC per la scansione delle chiavi che iniziano con un prefisso.
+ 1) Si percorre l'anello a partire dal nodo corrente seguendo i successori
+ 2) Ogni nodo ritorna le chiavi di cui è responsabile primario, ordinate e successive al cursore
+ 3) Si uniscono i risultati, ritornando la pagina con le prime Limit chiavi ed il cursore per la successiva
+*/
+func (n *Node) ScanRPC(args Args, reply *ScanReply) error {
+	utils.PrintHeaderL2("Received Scan RPC for prefix '" + args.Prefix + "'")
+	args.Limit = scanLimit(args.Limit)
+
+	me := n.ChordClient.GetIpAddress()
+	visited := make(map[string]bool)
+	found := make(map[string]bool)
+	var items []ScanItem
+	more := false
+	addr := me
+	for addr != "" && !visited[addr] {
+		visited[addr] = true
+		var part ScanReply
+		if addr == me {
+			n.ScanImpl(args, &part)
+		} else {
+			client, _ := utils.HttpConnect(addr, utils.RPC_PORT)
+			utils.PrintTs("Scan request sent to: " + addr + utils.RPC_PORT)
+			err := client.Call("Node.ScanImpl", args, &part)
+			client.Close()
+			if err != nil {
+				utils.PrintTs("Scan Error: " + err.Error())
+				return err
+			}
+		}
+		// Durante l'aggiornamento dell'anello una chiave può risultare su due nodi
+		for _, item := range part.Items {
+			if !found[item.Key] {
+				found[item.Key] = true
+				items = append(items, item)
+			}
+		}
+		more = more || part.More
+		addr = part.Successor
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	if len(items) > args.Limit {
+		items = items[:args.Limit]
+		more = true
+	}
+	reply.Items = items
+	if more && len(items) > 0 {
+		reply.NextCursor = items[len(items)-1].Key
+	}
+	utils.PrintTs("Scan completed, " + strconv.Itoa(len(items)) + " keys found on " + strconv.Itoa(len(visited)) + " nodes")
+	return nil
+}
+
+/*
+Effettua la RPC per la Get di un insieme di chiavi.
+ 1) Lookup per trovare il nodo che gestisce ogni chiave, raggruppando le chiavi per nodo
+ 2) RPC di MULTIGET in parallelo verso ogni nodo, con le sole chiavi che gestisce
+ 3) Si uniscono i risultati di ogni chiave in un'unica risposta
+*/
+func (n *Node) MultiGetRPC(args Args, reply *MultiReply) error {
+	utils.PrintHeaderL2("Received MultiGet RPC for " + strconv.Itoa(len(args.Keys)) + " keys")
+	reply.Results = n.forwardBatch("Node.MultiGetImpl", args)
+	return nil
+}
+
+/*
+Effettua la RPC per inserire un insieme di entry nello storage.
+ 1) Ogni valore viene preparato come nella Put, suddividendo in chunk quelli che superano CHUNK_THRESHOLD
+ 2) Lookup per trovare il nodo che deve gestire ogni chiave, raggruppando le entry per nodo
+ 3) RPC di MULTIPUT in parallelo verso ogni nodo, con le sole entry che deve gestire
+ 4) Si uniscono i risultati di ogni chiave in un'unica risposta
+*/
+func (n *Node) MultiPutRPC(args Args, reply *MultiReply) error {
+	utils.PrintHeaderL2("Received MultiPut RPC for " + strconv.Itoa(len(args.Keys)) + " keys")
+	if len(args.Values) != len(args.Keys) {
+		return errors.New("MultiPut requires a value for each key")
+	}
+	if _, err := requiredAcks(args.Consistency); err != nil {
+		return err
+	}
+
+	failed := make(map[string]KeyResult)
+	batch := args
+	batch.Keys, batch.Values, batch.ContentTypes = nil, nil, nil
+	for i, key := range args.Keys {
+		item := args
+		item.Key = key
+		item.Value = args.Values[i]
+		err := n.prepareValue(&item)
+		if err != nil {
+			failed[key] = KeyResult{Key: key, Error: err.Error()}
+			continue
+		}
+		batch.Keys = append(batch.Keys, key)
+		batch.Values = append(batch.Values, string(item.value()))
+		batch.ContentTypes = append(batch.ContentTypes, item.ContentType)
+	}
+
+	results := n.forwardBatch("Node.MultiPutImpl", batch)
+	for _, key := range args.Keys {
+		if result, ok := failed[key]; ok {
+			reply.Results = append(reply.Results, result)
+			continue
+		}
+		reply.Results = append(reply.Results, results[0])
+		results = results[1:]
+	}
+	return nil
+}
+
 /*
 Effettua la RPC per inserire un'entry nello storage.
- 1) Lookup per trovare il nodo che deve gestire la risorsa
- 2) RPC effettiva di PUT verso quel nodo chord
+ 1) Se il valore supera CHUNK_THRESHOLD, viene suddiviso in chunk inseriti sull'anello in base al loro hash
+ 2) Lookup per trovare il nodo che deve gestire la risorsa
+ 3) RPC effettiva di PUT verso quel nodo chord, con il valore oppure il manifest dei chunk, che attende la conferma di W repliche
 */
 func (n *Node) PutRPC(args Args, reply *string) error {
 	utils.PrintHeaderL2("Received Put RPC for key " + args.Key)
+	if _, err := requiredAcks(args.Consistency); err != nil {
+		*reply = err.Error()
+		return nil
+	}
+	if err := n.prepareValue(&args); err != nil {
+		*reply = err.Error()
+		utils.PrintTs(*reply)
+		return nil
+	}
 
 	me := n.ChordClient.GetIpAddress()
 	addr, _ := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
@@ -85,13 +480,55 @@ func (n *Node) PutRPC(args Args, reply *string) error {
 	return nil
 }
 
+/*
+Effettua la RPC per la Compare-And-Swap, che scrive il nuovo valore solo se quello attuale è il valore atteso.
+ 1) Lookup per trovare il nodo che hosta la risorsa
+ 2) RPC effettiva di PUT condizionata verso quel nodo chord
+*/
+func (n *Node) CompareAndSwapRPC(args Args, reply *ConditionalReply) error {
+	utils.PrintHeaderL2("Received Compare-And-Swap RPC for key " + args.Key)
+	args.Condition = mongo.COND_VALUE
+	return n.forwardConditional(args, reply)
+}
+
+/*
+Effettua la RPC per inserire un'entry solamente se la chiave non esiste.
+ 1) Lookup per trovare il nodo che deve gestire la risorsa
+ 2) RPC effettiva di PUT condizionata verso quel nodo chord
+*/
+func (n *Node) PutIfAbsentRPC(args Args, reply *ConditionalReply) error {
+	utils.PrintHeaderL2("Received Put-If-Absent RPC for key " + args.Key)
+	args.Condition = mongo.COND_ABSENT
+	return n.forwardConditional(args, reply)
+}
+
+/*
+Effettua la RPC per inserire un'entry solamente se la chiave non è stata modificata dopo la versione letta dal client,
+identificata dal contesto ottenuto con la Get oppure dall'istante della lettura.
+ 1) Lookup per trovare il nodo che hosta la risorsa
+ 2) RPC effettiva di PUT condizionata verso quel nodo chord
+*/
+func (n *Node) PutIfVersionRPC(args Args, reply *ConditionalReply) error {
+	utils.PrintHeaderL2("Received Put-If-Version RPC for key " + args.Key)
+	args.Condition = mongo.COND_VERSION
+	return n.forwardConditional(args, reply)
+}
+
 /*
 Effettua la RPC per aggiornare un'entry nello storage.
  1) Lookup per trovare il nodo che hosta la risorsa
- 2) RPC effettiva di APPEND verso quel nodo chord
+ 2) RPC effettiva di APPEND verso quel nodo chord, che attende la conferma di W repliche
 */
 func (n *Node) AppendRPC(args Args, reply *string) error {
 	utils.PrintHeaderL2("Received Append RPC for key " + args.Key)
+	if _, err := requiredAcks(args.Consistency); err != nil {
+		*reply = err.Error()
+		return nil
+	}
+	if err := checkClientKey(args.Key); err != nil {
+		*reply = err.Error()
+		return nil
+	}
 
 	me := n.ChordClient.GetIpAddress()
 	addr, _ := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
@@ -111,11 +548,13 @@ Effettua la RPC per eliminare un'entry nello storage.
 */
 func (n *Node) DeleteRPC(args Args, reply *string) error {
 	utils.PrintHeaderL2("Received Delete RPC for key " + args.Key)
+	if err := checkClientKey(args.Key); err != nil {
+		*reply = err.Error()
+		return nil
+	}
 
 	me := n.ChordClient.GetIpAddress()
 	handlerNode, _ := chord.Lookup(utils.HashString(args.Key), me+utils.CHORD_PORT)
-	args.Handler = utils.RemovePort(handlerNode)
-	args.Deleted = false
 
 	client, _ := utils.HttpConnect(utils.RemovePort(handlerNode), utils.RPC_PORT)
 	utils.PrintTs("Checking Key Handling")
@@ -126,17 +565,20 @@ func (n *Node) DeleteRPC(args Args, reply *string) error {
 
 /*
 Effettua il get. Scrive in reply la stringa contenente l'entry richiesta. Se l'entry
-non è stata trovata restituisce un messaggio di errore.
+non è stata trovata restituisce un messaggio di errore. Con consistenza QUORUM o ALL
+si uniscono le copie della chiave ritornate da R repliche, indicando quali hanno risposto.
 */
 func (n *Node) GetImpl(args Args, reply *string) error {
 	utils.PrintHeaderL2("Received Get RPC for key " + args.Key)
 	utils.PrintTs("I'm the handling node")
-	entry := n.MongoClient.GetEntry(args.Key)
+	required, _ := requiredAcks(args.Consistency)
+	entry, answered, ok := n.quorumRead(args.Key, args.Consistency)
 	if entry == nil {
 		*reply = "Entry not found"
 	} else {
 		*reply = entry.FormatClient()
 	}
+	*reply += "\n" + formatReplicas(answered, required, ok)
 	utils.PrintTs("Generating RPC Reply:")
 	fmt.Println(*reply)
 	utils.PrintTs("Finished. Replying to caller")
@@ -144,135 +586,328 @@ func (n *Node) GetImpl(args Args, reply *string) error {
 }
 
 /*
-Effettua il PUT. Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico
+Effettua il get del valore binario, senza formattarlo per la visualizzazione
 */
-func (n *Node) PutImpl(args Args, reply *string) error {
-	utils.PrintHeaderL2("Received Put RPC for key " + args.Key)
+func (n *Node) GetValueImpl(args Args, reply *ValueReply) error {
+	utils.PrintHeaderL2("Received Get Value RPC for key " + args.Key)
 	utils.PrintTs("I'm the handling node")
-	arg1 := args.Key
-	arg2 := args.Value
-	err := n.MongoClient.PutEntry(arg1, arg2)
-	ok := true
-	if err == nil {
-		*reply = "Entry correctly inserted in the DB"
-	} else if err.Error() == "Updated" {
-		*reply = "Entry already exists. Correctly updated"
+	entry := n.MongoClient.GetEntry(args.Key)
+	if entry != nil {
+		reply.Found = true
+		reply.Data = entry.Value
+		reply.ContentType = entry.ContentType
+		reply.Versions = len(entry.Versions())
+		reply.Context = entry.Context().String()
+	}
+	utils.PrintTs("Finished. Replying to caller")
+	return nil
+}
+
+/*
+Effettua il get delle versioni. Se la richiesta specifica una versione o un istante, scrive in reply
+solamente la versione selezionata, altrimenti tutte le versioni mantenute per la chiave.
+*/
+func (n *Node) GetVersionsImpl(args Args, reply *string) error {
+	utils.PrintHeaderL2("Received Get Versions RPC for key " + args.Key)
+	utils.PrintTs("I'm the handling node")
+	entry := n.MongoClient.GetVersions(args.Key)
+	if entry == nil {
+		*reply = "Entry not found"
+	} else if args.Version != "" || !args.AsOf.IsZero() {
+		*reply = selectVersion(entry, args)
 	} else {
-		*reply = err.Error()
-		ok = false
+		*reply = entry.FormatHistory()
 	}
 	utils.PrintTs("Generating RPC Reply:")
 	fmt.Println(*reply)
 	utils.PrintTs("Finished. Replying to caller")
+	return nil
+}
+
+/*
+Effettua la scansione sul nodo corrente. Ritorna le chiavi di cui il nodo è responsabile primario
+insieme al suo successore, così che il nodo che coordina la scansione possa proseguire lungo l'anello.
+*/
+func (n *Node) ScanImpl(args Args, reply *ScanReply) error {
+	utils.PrintTs("Scanning local storage for prefix '" + args.Prefix + "'")
+	owns := func(key string) bool { return !mongo.IsChunkKey(key) && n.ChordClient.IsResponsible(utils.HashString(key)) }
+	entries, more := mongo.ScanEntries(n.MongoClient, args.Prefix, args.Cursor, scanLimit(args.Limit), owns)
+	for _, entry := range entries {
+		reply.Items = append(reply.Items, ScanItem{Key: entry.Key, Value: mongo.FormatBytes(entry.Value, entry.ContentType), OnCloud: entry.OnCloud})
+	}
+	reply.More = more
+	reply.Successor = n.ChordClient.GetSuccessor().GetIpAddr()
+	return nil
+}
+
+/*
+Effettua il get di tutte le chiavi ricevute, gestite dal nodo corrente. Ogni chiave viene letta come nella Get,
+rispettando il livello di consistenza richiesto.
+*/
+func (n *Node) MultiGetImpl(args Args, reply *MultiReply) error {
+	utils.PrintHeaderL2("Received MultiGet RPC for " + strconv.Itoa(len(args.Keys)) + " keys")
+	utils.PrintTs("I'm the handling node")
+	required, _ := requiredAcks(args.Consistency)
+	for _, key := range args.Keys {
+		result := KeyResult{Key: key}
+		entry, answered, ok := n.quorumRead(key, args.Consistency)
+		if entry == nil {
+			result.Error = "Entry not found"
+		} else {
+			result.Value = entry.FormatClient() + "\n" + formatReplicas(answered, required, ok)
+		}
+		reply.Results = append(reply.Results, result)
+	}
+	utils.PrintTs("Finished. Replying to caller")
+	return nil
+}
+
+/*
+Effettua il put di tutte le entry ricevute, gestite dal nodo corrente. Ogni entry viene scritta come nella Put,
+con il proprio content-type, e le entry inserite correttamente vengono poi replicate insieme sul replica set.
+*/
+func (n *Node) MultiPutImpl(args Args, reply *MultiReply) error {
+	utils.PrintHeaderL2("Received MultiPut RPC for " + strconv.Itoa(len(args.Keys)) + " keys")
+	utils.PrintTs("I'm the handling node")
+	var written []string
+	var released []string
+	for i, key := range args.Keys {
+		item := args
+		item.Key = key
+		item.Value = args.Values[i]
+		item.Context = ""
+		if len(args.ContentTypes) > i {
+			item.ContentType = args.ContentTypes[i]
+		}
+		result := KeyResult{Key: key}
+		message, chunks, ok := n.putLocal(item)
+		if ok {
+			result.Value = message
+			written = append(written, key)
+			released = append(released, chunks...)
+		} else {
+			result.Error = message
+		}
+		reply.Results = append(reply.Results, result)
+	}
+	utils.PrintTs("Finished. Replying to caller")
+
+	// Inserimenti avvenuti correttamente, procediamo con la registrazione nel log di replicazione
+	if len(written) > 0 {
+		durability := n.replicateWrite(args.Consistency, written...)
+		for i := range reply.Results {
+			if reply.Results[i].Error == "" {
+				reply.Results[i].Value += "\n" + durability
+			}
+		}
+	}
+	go n.deleteChunks(released)
+	return nil
+}
 
-	// Inserimento avvenuto correttamente, procediamo con l'invio della replica al successore
+/*
+Effettua il PUT. Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico.
+La nuova versione discende dal contesto inviato dal client, sostituendo solamente le versioni che questo ha letto.
+La risposta indica le repliche che hanno confermato la scrittura secondo il livello di consistenza richiesto.
+*/
+func (n *Node) PutImpl(args Args, reply *string) error {
+	utils.PrintHeaderL2("Received Put RPC for key " + args.Key)
+	utils.PrintTs("I'm the handling node")
+	message, released, ok := n.putLocal(args)
+	*reply = message
+
+	// Inserimento avvenuto correttamente, procediamo con l'invio della replica al replica set
+	// e con la cancellazione dei chunk delle versioni sostituite
 	if ok {
-		go SendReplicaToSuccessor(n, args.Key)
+		*reply += "\n" + n.replicateWrite(args.Consistency, args.Key)
+		go n.deleteChunks(released)
+	}
+	utils.PrintTs("Generating RPC Reply:")
+	fmt.Println(*reply)
+	utils.PrintTs("Finished. Replying to caller")
+	return nil
+}
+
+/*
+Effettua la PUT condizionata sul nodo che gestisce la chiave. La precondizione viene verificata dal motore di storage
+in modo atomico rispetto alle altre scritture, ed in caso di successo la nuova versione viene replicata sul successore.
+*/
+func (n *Node) ConditionalPutImpl(args Args, reply *ConditionalReply) error {
+	utils.PrintHeaderL2("Received Conditional Put RPC for key " + args.Key)
+	utils.PrintTs("I'm the handling node")
+	cond := mongo.Condition{Mode: args.Condition, Expected: args.Expected,
+		Version: mongo.ParseVectorClock(args.Context), Timest: args.Since}
+	chunks := n.chunksOf(args.Key)
+	err := n.MongoClient.ConditionalPut(args.Key, args.value(), args.ContentType, cond, args.writeOptions())
+	if err == nil {
+		reply.Status = APPLIED
+		reply.Message = "Entry correctly written in the DB"
+	} else if err.Error() == "PreconditionFailed" {
+		reply.Status = PRECONDITION_FAILED
+		reply.Message = "Precondition failed, entry not written"
+		if current := n.MongoClient.ReadEntry(args.Key); current != nil && !current.IsDeleted() {
+			reply.Current = current.FormatClient()
+		}
+	} else {
+		reply.Status = FAILED
+		reply.Message = err.Error()
+	}
+
+	// Scrittura avvenuta correttamente, procediamo con la registrazione nel log di replicazione
+	if reply.Status == APPLIED {
+		reply.Message += "\n" + n.replicateWrite(args.Consistency, args.Key)
+		go n.deleteChunks(n.releasedChunks(args.Key, chunks))
 	}
+	utils.PrintTs("Generating RPC Reply:")
+	fmt.Println(reply.Status + ": " + reply.Message)
+	utils.PrintTs("Finished. Replying to caller")
 	return nil
 }
 
 /*
-Effettua l'APPEND. Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico
+Effettua l'APPEND. Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico.
+La risposta indica le repliche che hanno confermato la scrittura secondo il livello di consistenza richiesto.
 */
 func (n *Node) AppendImpl(args *Args, reply *string) error {
 	utils.PrintHeaderL2("Received Append RPC for key " + args.Key)
 	utils.PrintTs("I'm the handling node")
 	arg1 := args.Key
-	arg2 := args.Value
-	err := n.MongoClient.AppendValue(arg1, arg2)
-	ok := true
+	arg2 := args.value()
+	err := n.MongoClient.AppendValue(arg1, arg2, args.writeOptions())
+	ok := err == nil
 	if err == nil {
 		*reply = "Value correctly appended"
-	} else {
+	} else if err.Error() == "NoKeyFound" {
 		*reply = "Entry not found"
-		ok = false
+	} else if err.Error() == "ChunkedValue" {
+		*reply = "Append is not supported on chunked values"
+	} else {
+		*reply = "Append failed: " + err.Error()
 	}
-	utils.PrintTs("Generating RPC Reply:")
-	fmt.Println(*reply)
-	utils.PrintTs("Finished. Replying to caller")
 
-	// Inserimento avvenuto correttamente, procediamo con l'invio della replica al successore
+	// Inserimento avvenuto correttamente, procediamo con l'invio della replica al replica set
 	if ok {
-		go SendReplicaToSuccessor(n, args.Key)
+		*reply += "\n" + n.replicateWrite(args.Consistency, arg1)
 	}
+	utils.PrintTs("Generating RPC Reply:")
+	fmt.Println(*reply)
+	utils.PrintTs("Finished. Replying to caller")
 	return nil
 }
 
 /*
 Effettua il delete della risorsa sul nodo che deve gestirla.
-Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico
+Il tombstone viene registrato nel log di replicazione ed inviato in parallelo al solo replica set della chiave,
+attendendo al massimo WRITE_ACK_TIMEOUT la conferma di tutte le repliche. La risposta indica le repliche che
+hanno confermato la cancellazione.
 */
 func (n *Node) DeleteHandling(args *Args, reply *string) error {
 	utils.PrintHeaderL2("Received Delete RPC for key " + args.Key)
 	utils.PrintTs("I'm the handling node")
 	utils.PrintTs("Deleting value on local storage")
+	chunks := n.chunksOf(args.Key)
 	err := n.MongoClient.DeleteEntry(args.Key)
 	if err == nil {
-		args.Deleted = true
 		*reply = "Entry successfully deleted"
+		// I chunk del valore vengono cancellati dai nodi che li gestiscono, insieme alle loro repliche
+		go n.deleteChunks(chunks)
 	} else {
 		// Entry non è presente nel DB del nodo gestore, quindi non esiste
 		if err.Error() == "EntryNotFound" {
 			*reply = "The key searched for deletion does not exist"
-			utils.PrintTs(*reply)
-			return nil
+		} else {
+			*reply = err.Error()
 		}
+		utils.PrintTs(*reply)
+		return nil
 	}
-	utils.PrintTs(*reply)
 
-	// Se l'entry esiste ed è stata cancellata, procediamo inoltrando la richiesta al nodo successore
-	// così da eliminare tutte le repliche nell'anello
-	go DeleteReplicas(n, args, reply)
+	// Se l'entry esiste ed è stata cancellata, procediamo inviando il tombstone al replica set
+	*reply += "\n" + n.replicateWrite(ALL, args.Key)
+	utils.PrintTs(*reply)
 	return nil
 }
 
 /*
-Effettua il delete della risorsa replicata.
-Ritorna 0 se l'operazione è avvenuta con successo, altrimenti l'errore specifico
+Unisce nello storage locale la copia di un'entry inviata dal nodo che la gestisce.
+Utilizzata dalla read repair per aggiornare le repliche rimaste indietro.
 */
-func (n *Node) DeleteReplicating(args *Args, reply *string) error {
+func (n *Node) StoreReplicaRPC(args Args, reply *string) error {
+	if args.Entry == nil {
+		return errors.New("Missing entry")
+	}
+	utils.PrintTs("Storing replica of key " + args.Entry.Key)
+	err := n.applyEntry(*args.Entry)
+	if err != nil {
+		return err
+	}
+	*reply = "Replica stored"
+	return nil
+}
 
-	// La richiesta ha completato il giro dell'anello se è tornata al nodo che gestisce quella chiave
-	if n.ChordClient.GetIpAddress() == args.Handler {
-		utils.PrintTs("Delete Request returned to the handling node")
-		if args.Deleted {
-			*reply = "Entry succesfully deleted"
-		} else {
-			*reply = "Entry to delete not found"
-		}
-		utils.PrintTs(*reply)
-		return nil
+/*
+Ritorna la copia locale di un'entry, compresi storico e tombstone, così che il nodo che gestisce la chiave
+possa unirla alle copie delle altre repliche durante una lettura con quorum
+*/
+func (n *Node) ReadReplicaRPC(args Args, reply *ReplicaReply) error {
+	utils.PrintTs("Reading replica of key " + args.Key)
+	entry := n.MongoClient.GetVersions(args.Key)
+	if entry != nil {
+		reply.Found = true
+		reply.Entry = *entry
 	}
-	utils.PrintHeaderL2("Received Delete RPC for key " + args.Key)
+	return nil
+}
 
-	// Cancella l'entry richiesta sul db locale
-	utils.PrintTs("Deleting replicated value on local storage")
-	n.MongoClient.DeleteEntry(args.Key)
+/*
+Avvia la read repair di una chiave sul nodo che la gestisce. Invocata quando una Get viene servita dalla copia
+locale di un altro nodo, indicato in Value, così che anche la sua copia venga confrontata con quelle delle repliche.
+*/
+func (n *Node) RepairRPC(args Args, reply *string) error {
+	utils.PrintTs("Read repair requested for key " + args.Key)
+	replicas := n.GetReplicaSet()
+	if args.Value != "" && args.Value != n.ChordClient.GetIpAddress() && !utils.StringInSlice(args.Value, replicas) {
+		replicas = append(replicas, args.Value)
+	}
+	go n.repairReplicas(args.Key, nil, readReplicas(args.Key, replicas), len(replicas))
+	*reply = "Repair started"
+	return nil
+}
 
-	// Propaga la Delete al nodo successivo, la cancellazione sul nodo che gestisce la chiave
-	// è già stata effettuata, per questo se i nodi successivi non hanno successore aspettiamo
-	// la ricostruzione della DHT Chord finchè non viene completata la Delete!
-	utils.PrintTs("Forwarding delete request")
-retry:
-	succ := n.ChordClient.GetSuccessor().GetIpAddr()
-	if succ == "" {
-		utils.PrintTs("Node hasn't a successor, wait for the reconstruction...")
-		time.Sleep(utils.WAIT_SUCC_TIME)
-		goto retry
+/*
+Ritorna le statistiche del nodo, compreso l'offset del log di replicazione confermato da ogni replica
+e le scritture che ogni replica deve ancora ricevere
+*/
+func (n *Node) StatsRPC(args Args, reply *NodeStats) error {
+	utils.PrintHeaderL2("Received Stats RPC")
+	reply.Address = n.ChordClient.GetIpAddress()
+	reply.Entries = len(n.MongoClient.ListEntries())
+	reply.ReplicaSet = n.GetReplicaSet()
+	reply.LogOffset = n.OpLog.Last()
+	reply.Acked = n.OpLog.Acked()
+	reply.Hints = make(map[string]uint64)
+	reply.OldestHint = make(map[string]time.Time)
+	for _, replica := range reply.ReplicaSet {
+		reply.Hints[replica], reply.OldestHint[replica] = n.OpLog.Pending(replica)
 	}
-	client, _ := utils.HttpConnect(succ, utils.RPC_PORT)
-	utils.PrintTs("Delete request forwarded to replication node: " + succ + utils.RPC_PORT)
-	client.Call("Node.DeleteReplicating", args, &reply)
+	reply.Roles = n.Ownership.Counts()
+	reply.Transfers = communication.Stats()
 	return nil
 }
 
 /*
-Metodo invocato dal Service Registry quando le istanze EC2 devono procedere con lo scambio degli aggiornamenti
-Effettua il trasferimento del proprio DB al nodo successore nella rete per realizzare la consistenza finale.
+Metodo invocato dal Service Registry quando le istanze EC2 devono procedere con lo scambio degli aggiornamenti,
+e poi da ogni nodo verso il suo successore fino a completare il giro dell'anello.
+Ogni nodo confronta tramite Merkle tree le chiavi di cui è responsabile con le copie del suo replica set,
+trasferendo solamente le entry che differiscono. Handler indica il nodo che ha avviato il giro.
 */
 func (n *Node) StartReconciliationRPC(args *Args, reply *string) error {
-	utils.PrintHeaderL2("Reconciliation requested by service registry")
+	if args.Handler == "" {
+		utils.PrintHeaderL2("Reconciliation requested by service registry")
+		args.Handler = n.ChordClient.GetIpAddress()
+	} else {
+		utils.PrintHeaderL2("Reconciliation requested by predecessor")
+	}
 
 	succ := n.ChordClient.GetSuccessor().GetIpAddr()
 	if succ == "" {
@@ -281,58 +916,187 @@ func (n *Node) StartReconciliationRPC(args *Args, reply *string) error {
 		return nil
 	}
 
-	// Imposto il nodo corrente come gestore dell'aggiornamento dell'anello, così da incrementare solo
-	// per lui il contatore che permette l'interruzione dopo 2 giri
-	n.Handler = true
+	go n.antiEntropyRound(args.Handler, succ)
+	*reply = "Anti-entropy started"
+	return nil
+}
+
+/*
+Ritorna gli hash dei nodi richiesti del Merkle tree di un intervallo di chiavi
+*/
+func (n *Node) MerkleRPC(args Args, reply *MerkleReply) error {
+	tree := n.rangeTree(args.RangeStart, args.RangeEnd)
+	if args.Level < 0 || args.Level > tree.Depth {
+		return errors.New("Invalid Merkle tree level")
+	}
+	for _, index := range args.Indexes {
+		if index < 0 || index >= len(tree.Levels[args.Level]) {
+			return errors.New("Invalid Merkle tree index")
+		}
+		reply.Hashes = append(reply.Hashes, tree.Levels[args.Level][index])
+	}
+	reply.Compression = communication.ChooseCompression(args.Compressions)
+	return nil
+}
 
-	// Effettuo l' export del DB e lo invio al successore
-	SendUpdateMsg(n, succ, utils.RECON, "")
+/*
+Ritorna i digest delle entry contenute nelle foglie richieste del Merkle tree di un intervallo di chiavi
+*/
+func (n *Node) MerkleLeavesRPC(args Args, reply *DigestReply) error {
+	tree := n.rangeTree(args.RangeStart, args.RangeEnd)
+	reply.Digests = make(map[string][32]byte)
+	for _, index := range args.Indexes {
+		if index < 0 || index >= len(tree.Leaves) {
+			return errors.New("Invalid Merkle tree leaf")
+		}
+		for key, digest := range tree.Leaves[index] {
+			reply.Digests[key] = digest
+		}
+	}
+	return nil
+}
+
+/*
+Ritorna le copie locali delle chiavi richieste, compresi storico e tombstone, compresse con l'algoritmo negoziato
+*/
+func (n *Node) ReadReplicasRPC(args Args, reply *EntriesReply) error {
+	var entries []mongo.MongoEntry
+	for _, key := range args.Keys {
+		if entry := n.MongoClient.GetVersions(key); entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	started := time.Now()
+	batch, raw, err := compressEntries(entries, args.Compression)
+	if err != nil {
+		return err
+	}
+	communication.RecordTransfer(utils.ANTIE, args.Source, args.Compression, raw, int64(len(batch)), time.Since(started))
+	reply.Batch = batch
+	return nil
+}
+
+/*
+Unisce nello storage locale le entry ricevute da un'altra replica durante l'anti-entropy
+*/
+func (n *Node) StoreReplicasRPC(args Args, reply *string) error {
+	entries, err := decompressEntries(args.Batch, args.Compression)
+	if err != nil {
+		return err
+	}
+	utils.PrintTs("Storing " + strconv.Itoa(len(entries)) + " entries received by anti-entropy")
+	err = n.applyEntries(entries)
+	if err != nil {
+		return err
+	}
+	*reply = "Entries stored"
+	return nil
+}
+
+/*
+Ritorna l'intervallo di chiavi di cui il nodo è responsabile primario ed il suo predecessore,
+così che i successori possano calcolare gli intervalli di cui mantengono le repliche
+*/
+func (n *Node) RangeRPC(args Args, reply *RangeReply) error {
+	reply.Start, reply.End = n.ChordClient.GetRange()
+	reply.Predecessor = n.predecessorAddr()
+	return nil
+}
+
+/*
+Riceve le entry di cui il nodo è responsabile da un nodo che non lo è più. Le entry vengono unite nello storage locale
+e registrate nel log di replicazione, ed il passaggio viene confermato solo se tutto il replica set ne conferma la ricezione.
+*/
+func (n *Node) HandoffRPC(args Args, reply *HandoffReply) error {
+	utils.PrintHeaderL2("Receiving handoff of " + strconv.Itoa(len(args.Entries)) + " entries")
+	var seq uint64
+	for _, entry := range args.Entries {
+		if !n.ChordClient.IsResponsible(utils.HashString(entry.Key)) {
+			utils.PrintTs("Not responsible for key " + entry.Key + ", handoff refused")
+			return nil
+		}
+	}
+	for _, entry := range args.Entries {
+		err := n.applyEntry(entry)
+		if err != nil {
+			return err
+		}
+		seq = n.OpLog.Append(entry.Key)
+	}
+	replicas := n.GetReplicaSet()
+	if len(replicas) < utils.REPLICATION_FACTOR-1 {
+		utils.PrintTs("Replica set incomplete, handoff not confirmed")
+		return nil
+	}
+	reply.Replicas = n.OpLog.WaitAcks(seq, replicas, len(replicas), utils.WRITE_ACK_TIMEOUT)
+	reply.Confirmed = len(reply.Replicas) == len(replicas)
 	return nil
 }
 
 /*
 Metodo invocato dal Service Registry quando l'istanza EC2 viene schedulata per la terminazione
-Effettua il trasferimento del proprio DB al nodo successore nella rete per garantire persistenza dei dati.
-Inviamo tutto il DB e non solo le entry gestite dal preciso nodo così abbiamo la possibilità di
-aggiornare altri dati obsoleti mantenuti dal successore.
+Effettua il trasferimento delle entry nell'intervallo (predecessore, nodo], che passa al successore, ai primi
+REPLICATION_FACTOR successori attivi: il successore ed il suo replica set. Le repliche delle entry dei predecessori
+che il nodo mantiene vengono invece reinviate dai predecessori stessi, quando il nodo esce dal loro replica set.
+Il nodo risponde solamente dopo che tutti i successori hanno confermato di aver applicato le entry: i successori
+che non confermano vengono ricalcolati e contattati di nuovo dopo WAIT_SUCC_TIME.
 */
 func (n *Node) LeaveRPC(args *Args, reply *string) error {
 	utils.PrintHeaderL2("Node Leaving")
 	utils.PrintTs("Instance Scheduled to Terminating")
-	utils.PrintTs("Sending entries to successor")
+	utils.PrintTs("Sending entries to successors")
+	start, end := n.ChordClient.GetRange()
+	acked := make(map[string]bool)
 retry:
-	succ := n.ChordClient.GetSuccessor().GetIpAddr()
-	if succ == "" {
+	succs := n.ChordClient.GetSuccessors(utils.REPLICATION_FACTOR)
+	if len(succs) == 0 {
 		utils.PrintTs("Node hasn't a successor, wait for the reconstruction of the DHT")
 		time.Sleep(utils.WAIT_SUCC_TIME)
 		goto retry
 	}
 
-	SendUpdateMsg(n, succ, utils.MIGRN, "")
+	var pending []string
+	for _, succ := range succs {
+		if !acked[succ] {
+			pending = append(pending, succ)
+		}
+	}
+	failed := n.migrateRange(pending, start, end)
+	for _, succ := range pending {
+		acked[succ] = true
+	}
+	for _, succ := range failed {
+		acked[succ] = false
+	}
+	if len(failed) > 0 {
+		utils.PrintTs("Entries not confirmed by " + strings.Join(failed, ", ") + ", retrying")
+		time.Sleep(utils.WAIT_SUCC_TIME)
+		goto retry
+	}
 	*reply = "Instance can now safely leave the chord ring"
 	utils.PrintTs(*reply)
 	return nil
 }
 
 /*
-Metodo invocato dal nodo successore quando si inserisce nell'anello chord.
-Effettua il trasferimento del proprio DB al chiamante in modo da permettere da subito le complete funzionalità del nuovo nodo.
-Inviamo tutto il DB e non solo le entry gestite dal preciso nodo in modo da inviare anche eventuali repliche che questo dovrà gestire.
+Metodo invocato dal nuovo predecessore quando si inserisce nell'anello chord.
+Effettua il trasferimento al chiamante delle sole entry nell'intervallo (RangeStart, RangeEnd], cioè
+(vecchio predecessore, nuovo nodo], di cui il nuovo nodo diventa responsabile.
 */
 func (n *Node) JoinRPC(args *Args, reply *string) error {
-	succ := args.Value
+	pred := args.Value
 	utils.PrintHeaderL2("Node Joining")
 	utils.PrintTs("Instance is joining chord DHT")
-	utils.PrintTs("Sending entries to new successor node")
+	utils.PrintTs("Sending entries to new predecessor node")
 
-retry:
-	if succ == "" {
-		utils.PrintTs("Node hasn't a successor, wait for the reconstruction of the DHT")
-		time.Sleep(utils.WAIT_SUCC_TIME)
-		goto retry
+	if pred == "" {
+		return errors.New("Missing joining node address")
 	}
 
-	SendUpdateMsg(n, succ, utils.MIGRN, "")
+	err := SendUpdateMsg(n, pred, utils.MIGRN, args.RangeStart, args.RangeEnd)
+	if err != nil {
+		return errors.New("Entries not transferred to the joining node: " + err.Error())
+	}
 	*reply = "Instance succesfully inserted in chord ring"
 	utils.PrintTs(*reply)
 	return nil
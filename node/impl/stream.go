@@ -0,0 +1,305 @@
+package impl
+
+import (
+	mongo "JDSys/node/mongo/api"
+	"JDSys/node/mongo/communication"
+	"JDSys/utils"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Messaggio con cui un nodo apre lo stream del suo log di replicazione verso una replica, proponendo le compressioni supportate
+*/
+type StreamHello struct {
+	Source       string
+	LogId        string
+	Compressions []string
+}
+
+/*
+Operazione del log inviata ad una replica, con la versione attuale dell'entry.
+Entry è nil se la chiave non è più presente sul nodo, e la replica si limita a confermarne l'offset.
+*/
+type StreamRecord struct {
+	Seq   uint64
+	Key   string
+	Entry *mongo.MongoEntry
+}
+
+/*
+Gruppo di operazioni del log, applicato e confermato dalla replica con un unico ack
+*/
+type StreamBatch struct {
+	Records []StreamRecord
+}
+
+/*
+Conferma di una replica: l'offset dell'ultima operazione applicata, e se la replica conosce il log.
+Una replica che non conosce il log, o che è rimasta indietro oltre le operazioni mantenute, riceve tutte le entry
+di cui il nodo è responsabile. Nella prima conferma la replica sceglie la compressione dei gruppi di operazioni.
+*/
+type StreamAck struct {
+	Seq         uint64
+	Known       bool
+	Compression string
+}
+
+/*
+Offset confermato da una replica per il log di un altro nodo
+*/
+type streamOffset struct {
+	LogId string
+	Seq   uint64
+}
+
+/*
+Offset applicati dalla replica per ogni nodo di cui riceve il log, salvati su file così che dopo un riavvio
+lo stream riprenda dall'ultimo offset confermato
+*/
+type streamOffsets struct {
+	mutex   sync.Mutex
+	file    string
+	offsets map[string]streamOffset
+}
+
+/*
+Carica gli offset dal file specificato, vuoti se il file non esiste
+*/
+func loadOffsets(file string) *streamOffsets {
+	offsets := &streamOffsets{file: file, offsets: make(map[string]streamOffset)}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		json.Unmarshal(data, &offsets.offsets)
+	}
+	return offsets
+}
+
+/*
+Ritorna l'offset applicato per il log di un nodo, e se il log è conosciuto
+*/
+func (offsets *streamOffsets) get(source string, logId string) (uint64, bool) {
+	offsets.mutex.Lock()
+	defer offsets.mutex.Unlock()
+	offset, ok := offsets.offsets[source]
+	if !ok || offset.LogId != logId {
+		return 0, false
+	}
+	return offset.Seq, true
+}
+
+/*
+Registra l'offset applicato per il log di un nodo e salva gli offset su file
+*/
+func (offsets *streamOffsets) set(source string, logId string, seq uint64) {
+	offsets.mutex.Lock()
+	defer offsets.mutex.Unlock()
+	if offset, ok := offsets.offsets[source]; ok && offset.LogId == logId && offset.Seq >= seq {
+		return
+	}
+	offsets.offsets[source] = streamOffset{LogId: logId, Seq: seq}
+	data, _ := json.Marshal(offsets.offsets)
+	os.MkdirAll(filepath.Dir(offsets.file), 0755)
+	tmp := offsets.file + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, offsets.file)
+	}
+	if err != nil {
+		utils.PrintTs("SaveOffsets Error: " + err.Error())
+	}
+}
+
+/*
+Routine che mantiene aperto lo stream del log di replicazione verso una replica, finchè questa fa parte del replica set.
+Dopo una disconnessione lo stream viene riaperto, e la replica riprende dall'ultimo offset che ha confermato.
+*/
+func (n *Node) streamReplica(replica string, stop chan struct{}) {
+	for {
+		err := n.sendStream(replica, stop)
+		select {
+		case <-stop:
+			utils.PrintTs("Replication stream to " + replica + " closed")
+			return
+		default:
+		}
+		if err != nil {
+			utils.PrintTs("Replication stream to " + replica + " interrupted: " + err.Error())
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(utils.OPLOG_RETRY_TIME):
+		}
+	}
+}
+
+/*
+Apre una connessione verso la replica ed invia le operazioni del log a partire dall'offset che questa ha confermato,
+attendendo l'ack di ogni gruppo prima di inviare il successivo. I gruppi vengono compressi con l'algoritmo scelto dalla replica,
+mentre gli ack viaggiano non compressi.
+*/
+func (n *Node) sendStream(replica string, stop chan struct{}) error {
+	conn, err := utils.DialTimeout(replica+utils.OPLOG_PORT, utils.REPLICA_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	var ack StreamAck
+	err = encoder.Encode(StreamHello{Source: n.ChordClient.GetIpAddress(), LogId: n.OpLog.Id, Compressions: communication.OfferedCompressions()})
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(utils.REPLICA_TIMEOUT))
+		err = decoder.Decode(&ack)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, first, _ := n.OpLog.Since(0, 0)
+	next := ack.Seq + 1
+	if !ack.Known || next < first || ack.Seq > n.OpLog.Last() {
+		// La replica non può recuperare dal log, le si inviano tutte le entry di cui il nodo è responsabile
+		utils.PrintTs("Replica " + replica + " can't catch up from the log, sending entries")
+		start, end := n.ChordClient.GetRange()
+		err = SendUpdateMsg(n, replica, utils.MIGRN, start, end)
+		if err != nil {
+			return err
+		}
+		next = first
+	}
+	n.OpLog.Ack(replica, next-1)
+	wire := &communication.CountingWriter{W: conn}
+	compressor, err := communication.NewCompressor(wire, ack.Compression)
+	if err != nil {
+		return err
+	}
+	utils.PrintTs("Replication stream to " + replica + " opened at offset " + strconv.FormatUint(next-1, 10) + " with compression " + ack.Compression)
+
+	for {
+		records, first, changed := n.OpLog.Since(next, utils.OPLOG_BATCH)
+		if next < first {
+			return errors.New("Operations not yet acknowledged were removed from the log")
+		}
+		if len(records) == 0 {
+			select {
+			case <-changed:
+				continue
+			case <-stop:
+				return nil
+			}
+		}
+
+		batch := StreamBatch{}
+		for _, record := range records {
+			batch.Records = append(batch.Records, StreamRecord{Seq: record.Seq, Key: record.Key, Entry: n.MongoClient.ReadEntry(record.Key)})
+		}
+		last := records[len(records)-1].Seq
+		started := time.Now()
+		sent := wire.Bytes
+		data, _ := json.Marshal(batch)
+		_, err := compressor.Write(data)
+		if err == nil {
+			err = compressor.Flush()
+		}
+		if err == nil {
+			conn.SetReadDeadline(time.Now().Add(utils.REPLICA_TIMEOUT))
+			err = decoder.Decode(&ack)
+		}
+		if err != nil {
+			return err
+		}
+		communication.RecordTransfer(utils.REPLN, replica, ack.Compression, int64(len(data)), wire.Bytes-sent, time.Since(started))
+		if ack.Seq != last {
+			return errors.New("Unexpected ack " + strconv.FormatUint(ack.Seq, 10))
+		}
+		n.OpLog.Ack(replica, ack.Seq)
+		next = ack.Seq + 1
+	}
+}
+
+/*
+Resta in ascolto per gli stream dei log di replicazione degli altri nodi
+*/
+func ListenReplicationStreams(node *Node) {
+	offsets := loadOffsets(utils.OFFSETS_FILE)
+	listener, err := utils.Listen(utils.OPLOG_PORT)
+	if err != nil {
+		utils.PrintTs("Listening Error: " + err.Error())
+		return
+	}
+	utils.PrintTs("Started Replication Stream listening Service")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			utils.PrintTs("Accept Error: " + err.Error())
+			continue
+		}
+		go node.receiveStream(conn, offsets)
+	}
+}
+
+/*
+Riceve lo stream del log di un nodo: comunica l'offset applicato, poi unisce nello storage locale le entry
+di ogni gruppo ricevuto, contemporaneamente agli altri stream e trasferimenti, e ne conferma l'offset dopo averlo salvato
+*/
+func (n *Node) receiveStream(conn net.Conn, offsets *streamOffsets) {
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	var hello StreamHello
+	if decoder.Decode(&hello) != nil {
+		return
+	}
+	seq, known := offsets.get(hello.Source, hello.LogId)
+	compression := communication.ChooseCompression(hello.Compressions)
+	if encoder.Encode(StreamAck{Seq: seq, Known: known, Compression: compression}) != nil {
+		return
+	}
+	utils.PrintHeaderL2("Receiving replication stream of " + hello.Source + " from offset " + strconv.FormatUint(seq, 10))
+
+	// Da qui in poi il mittente invia solamente gruppi compressi
+	reader, err := communication.NewDecompressor(conn, compression)
+	if err != nil {
+		utils.PrintTs("Replication stream of " + hello.Source + " closed: " + err.Error())
+		return
+	}
+	decoder = json.NewDecoder(reader)
+	for {
+		var batch StreamBatch
+		err := decoder.Decode(&batch)
+		if err != nil {
+			utils.PrintTs("Replication stream of " + hello.Source + " closed")
+			return
+		}
+		if len(batch.Records) == 0 {
+			continue
+		}
+		var entries []mongo.MongoEntry
+		for _, record := range batch.Records {
+			if record.Entry != nil {
+				entries = append(entries, *record.Entry)
+			}
+		}
+		err = n.applyEntries(entries)
+		if err != nil {
+			// Il gruppo non viene confermato, e verrà inviato di nuovo alla riapertura dello stream
+			utils.PrintTs("Replication stream of " + hello.Source + " closed: " + err.Error())
+			return
+		}
+		last := batch.Records[len(batch.Records)-1].Seq
+		offsets.set(hello.Source, hello.LogId, last)
+		if encoder.Encode(StreamAck{Seq: last, Known: true}) != nil {
+			return
+		}
+	}
+}
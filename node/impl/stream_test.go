@@ -0,0 +1,74 @@
+package impl
+
+import (
+	chord "JDSys/node/chord/api"
+	mongo "JDSys/node/mongo/api"
+	"JDSys/utils"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+Configura porta, file degli offset e tentativi degli stream di replicazione per i test
+*/
+func setupStreams(t *testing.T) {
+	port, offsetsFile, retryTime, maxRecords := utils.OPLOG_PORT, utils.OFFSETS_FILE, utils.OPLOG_RETRY_TIME, utils.OPLOG_MAX_RECORDS
+	utils.OPLOG_PORT = freePort(t)
+	utils.OFFSETS_FILE = filepath.Join(t.TempDir(), "acked.json")
+	utils.OPLOG_RETRY_TIME = 50 * time.Millisecond
+	t.Cleanup(func() {
+		utils.OPLOG_PORT, utils.OFFSETS_FILE, utils.OPLOG_RETRY_TIME, utils.OPLOG_MAX_RECORDS = port, offsetsFile, retryTime, maxRecords
+	})
+}
+
+/*
+Attende che l'entry arrivi sul nodo con il valore specificato
+*/
+func waitEntry(t *testing.T, n *Node, key string, value string) {
+	for i := 0; i < 200; i++ {
+		if entry := n.MongoClient.ReadEntry(key); entry != nil && string(entry.Value) == value {
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatalf("entry %s with value %s not received by the replica", key, value)
+}
+
+func TestWriteWithoutReplicasReachesFirstReplica(t *testing.T) {
+	setupMigration(t)
+	setupStreams(t)
+	source := testNode(t, "primary", "replica")
+	source.ChordClient = chord.Create("127.0.0.1" + freePort(t))
+	target := testNode(t, "primary", "replica")
+
+	// La replica precedente esce dal replica set senza aver confermato la scrittura, che resta nel log
+	source.MongoClient.PutEntry("owed", []byte("v1"), "", nil, mongo.WriteOptions{})
+	source.replicateWrite(ONE, "owed")
+	source.OpLog.Forget("127.0.0.9")
+
+	// Con il replica set vuoto nessuna replica trattiene le operazioni, che possono essere rimosse dal log
+	utils.OPLOG_MAX_RECORDS = 2
+	for _, key := range []string{"alone1", "alone2", "alone3"} {
+		source.MongoClient.PutEntry(key, []byte("v1"), "", nil, mongo.WriteOptions{})
+		source.replicateWrite(ONE, key)
+	}
+	if _, first, _ := source.OpLog.Since(0, 0); first == 1 {
+		t.Fatalf("log not compacted, the test would not cover writes removed from it")
+	}
+
+	// La prima replica che entra non conosce il log, e riceve tutte le entry di cui il nodo è responsabile
+	listenMigrations(t, target)
+	go ListenReplicationStreams(target)
+	stop := make(chan struct{})
+	defer close(stop)
+	go source.streamReplica("127.0.0.1", stop)
+	for _, key := range []string{"owed", "alone1", "alone2", "alone3"} {
+		waitEntry(t, target, key, "v1")
+	}
+
+	// Le scritture successive arrivano tramite lo stream
+	source.MongoClient.PutEntry("owed", []byte("v2"), "", source.MongoClient.ReadEntry("owed").Context(), mongo.WriteOptions{})
+	source.replicateWrite(ONE, "owed")
+	waitEntry(t, target, "owed", "v2")
+}
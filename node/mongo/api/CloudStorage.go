@@ -0,0 +1,220 @@
+package mongo
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"JDSys/node/mongo/communication"
+	"JDSys/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+/*
+Mantiene le chiavi che un motore di storage ha migrato sul bucket S3 perchè raramente accedute.
+La migrazione è indipendente dal motore utilizzato, che viene acceduto solamente tramite l'interfaccia StorageEngine.
+*/
+type CloudTier struct {
+	Keys []string
+}
+
+/*
+Indica se la chiave specificata è stata migrata sul cloud storage
+*/
+func (cloud *CloudTier) Contains(key string) bool {
+	return utils.StringInSlice(key, cloud.Keys)
+}
+
+/*
+Se la chiave è presente sul cloud, viene scaricata da S3 ed unita alla sola entry locale con la stessa chiave,
+per poi rimuoverla dal bucket. La chiave viene tolta dal cloud prima del merge, che altrimenti la ripristinerebbe di nuovo.
+*/
+func (cloud *CloudTier) Restore(engine StorageEngine, key string) {
+	if !cloud.Contains(key) {
+		return
+	}
+	utils.PrintTs("Entry on Cloud System. Downloading...\n")
+	downloadEntryFromS3(key)
+	defer utils.ClearDir(utils.CLOUD_RECEIVE_PATH)
+	restored, err := ParseCSV(utils.CLOUD_RECEIVE_PATH + key + utils.CSV)
+	if err != nil {
+		utils.PrintTs("Restore Error: " + err.Error())
+		return
+	}
+	cloud.Keys = utils.RemoveElement(cloud.Keys, key)
+	for _, entry := range restored {
+		err = engine.MergeEntry(entry)
+		if err != nil {
+			utils.PrintTs("Restore Error: " + err.Error())
+			cloud.Keys = append(cloud.Keys, key)
+			return
+		}
+	}
+	deleteEntryFromS3(key)
+}
+
+/*
+Routine che periodicamente controlla tutte le entry del motore di storage per vedere se è possibile
+effettuare una migrazione delle risorse verso il cloud S3
+*/
+func (cloud *CloudTier) CheckRarelyAccessed(engine StorageEngine) {
+	for {
+		time.Sleep(utils.RARELY_ACCESSED_CHECK_INTERVAL)
+		utils.PrintHeaderL2("Check Rarely Acccessed Entries")
+		for _, entry := range engine.ListEntries() {
+			// I tombstone restano in locale fino alla loro rimozione definitiva
+			if entry.IsDeleted() {
+				continue
+			}
+			timeNow := getTimestamp()
+			diff := timeNow.Sub(entry.LastAcc)
+			utils.PrintTs("Key " + entry.Key + " non-accessed since " + diff.String())
+			if diff >= utils.RARELY_ACCESSED_TIME {
+				utils.PrintTs("Entry not accessed for a long time. Migrating on Cloud")
+				cloud.upload(engine, entry.Key)
+			}
+		}
+	}
+}
+
+/*
+Carica una chiave sul bucket s3, rimuovendola dal motore di storage locale
+*/
+func (cloud *CloudTier) upload(engine StorageEngine, key string) {
+	utils.PrintHeaderL3("Uploading Entry to S3")
+	filename := key + ".csv"
+
+	keys := getEntryListFromS3()
+
+	if utils.StringInSlice(key, keys) {
+		utils.PrintTs("Entry on Cloud System. Checking most recent...\n")
+		getLatestEntryCSV(engine, key)
+	}
+
+	utils.PrintTs("Exporting csv " + filename)
+	engine.ExportDocument(key, utils.CLOUD_EXPORT_PATH+filename)
+	sess := communication.CreateSession()
+	uploader := s3manager.NewUploader(sess)
+
+	f, err := os.Open(utils.CLOUD_EXPORT_PATH + filename)
+	if err != nil {
+		utils.PrintTs("Open Error: " + err.Error())
+		return
+	}
+
+	// Carica il file su S3
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(utils.BUCKET_NAME),
+		Key:    aws.String(filename),
+		Body:   f,
+	})
+	if err != nil {
+		utils.PrintTs(err.Error())
+		return
+	}
+	utils.PrintTs("Entry succesfully uploaded to cloud storage")
+
+	// Caricato il file su s3 lo rimuovo in locale, e salvo il fatto che è presente sul cloud
+	utils.PrintTs("Removing entry from local storage")
+	engine.PurgeEntry(key)
+	cloud.Keys = append(cloud.Keys, key)
+	utils.PrintTs("Migration to S3 completed")
+}
+
+/*
+Ottiene la chiave specificata dal bucket S3, salvandola in un file locale
+*/
+func downloadEntryFromS3(key string) {
+	utils.PrintHeaderL3("Downlaoding Entry from S3")
+	sess := communication.CreateSession()
+	filename := key + utils.CSV
+	downloader := s3manager.NewDownloader(sess)
+
+	// Crea il file in cui verrà scritto l'oggetto scaricato da S3
+	f, err := os.Create(utils.CLOUD_RECEIVE_PATH + filename)
+	if err != nil {
+		utils.PrintTs(fmt.Sprintf("failed to create file %q, %v", filename, err))
+		return
+	}
+
+	// Scrive il contenuto dell'oggetto S3 sul file
+	_, err = downloader.Download(f, &s3.GetObjectInput{
+		Bucket: aws.String(utils.BUCKET_NAME),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		utils.PrintTs(fmt.Sprintf("failed to download file, %v", err))
+		return
+	}
+	utils.PrintTs("Entry succesfully retrieved form cloud storage")
+}
+
+/*
+Scarica l'entry richiesta da S3, la confronta con quella locale e mantiene l'export della chiave più recente tra le due
+In questo modo l'upload sul cloud avrà sempre l'entry più aggiornata e riconciliata.
+*/
+func getLatestEntryCSV(engine StorageEngine, key string) {
+	downloadEntryFromS3(key)
+	remote, _ := ParseCSV(utils.CLOUD_RECEIVE_PATH + key + utils.CSV)
+
+	engine.ExportDocument(key, utils.CLOUD_EXPORT_PATH+key+utils.CSV)
+	local, _ := ParseCSV(utils.CLOUD_EXPORT_PATH + key + utils.CSV)
+
+	merged := MergeEntries(local, remote)
+
+	engine.PutMongoEntry(merged[0])
+	engine.ExportDocument(key, utils.CLOUD_EXPORT_PATH+key+utils.CSV)
+
+	utils.PrintTs("Latest CSV created succesfully for key " + key)
+}
+
+/*
+Elimina l'entry specificata dal Bucket S3.
+*/
+func deleteEntryFromS3(key string) error {
+	utils.PrintHeaderL3("Deleting Entry from S3")
+	sess := communication.CreateSession()
+	svc := s3.New(sess)
+	filename := key + utils.CSV
+
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(utils.BUCKET_NAME),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = svc.WaitUntilObjectNotExists(&s3.HeadObjectInput{
+		Bucket: aws.String(utils.BUCKET_NAME),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/*
+Permette di ottenere una lista di tutte le entry presenti sul cloud storage
+*/
+func getEntryListFromS3() []string {
+	var keys []string
+
+	sess := communication.CreateSession()
+	svc := s3.New(sess)
+
+	params := &s3.ListObjectsInput{
+		Bucket: aws.String(utils.BUCKET_NAME),
+		Prefix: aws.String(""),
+	}
+	resp, _ := svc.ListObjects(params)
+	for _, k := range resp.Contents {
+		keys = append(keys, k.String())
+	}
+	return keys
+}
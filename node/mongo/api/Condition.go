@@ -0,0 +1,45 @@
+package mongo
+
+import (
+	"bytes"
+	"time"
+)
+
+var COND_VALUE string = "value"     // La scrittura avviene solo se il valore attuale è quello atteso
+var COND_ABSENT string = "absent"   // La scrittura avviene solo se la chiave non esiste
+var COND_VERSION string = "version" // La scrittura avviene solo se la chiave non è stata modificata dopo la versione attesa
+
+/*
+Precondizione di una scrittura condizionata. Viene verificata dal motore di storage in modo atomico
+rispetto alle altre scritture sulla stessa chiave.
+*/
+type Condition struct {
+	Mode     string
+	Expected string      // valore atteso per COND_VALUE
+	Version  VectorClock // contesto atteso per COND_VERSION
+	Timest   time.Time   // istante dopo il quale la chiave non deve essere stata modificata per COND_VERSION
+}
+
+/*
+Verifica la precondizione sull'entry memorizzata, nil se la chiave non è presente.
+Un'entry cancellata o scaduta viene considerata assente, mentre un'entry con versioni concorrenti
+non soddisfa mai la condizione sul valore, perchè questo non è univoco.
+*/
+func (cond *Condition) Check(entry *MongoEntry, now time.Time) bool {
+	live := entry != nil && entry.IsLive(now)
+	switch cond.Mode {
+	case COND_ABSENT:
+		return !live
+	case COND_VALUE:
+		return live && len(entry.Siblings) == 0 && bytes.Equal(entry.Value, []byte(cond.Expected))
+	case COND_VERSION:
+		if !live {
+			return false
+		}
+		if cond.Version != nil {
+			return entry.Context().Equal(cond.Version)
+		}
+		return !entry.Timest.After(cond.Timest)
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConditionCheck(t *testing.T) {
+	now := time.Now()
+	live := &MongoEntry{Key: "k"}
+	live.Update([]byte("v1"), "", nil, "n1", now.Add(-time.Minute))
+
+	deleted := &MongoEntry{Key: "k"}
+	*deleted = *live
+	deleted.Tombstone(live.Context(), "n1", now.Add(-time.Second))
+
+	expired := &MongoEntry{Key: "k"}
+	*expired = *live
+	expired.Expires = now.Add(-time.Second)
+
+	concurrent := &MongoEntry{Key: "k"}
+	*concurrent = MergeVersions(*live, func() MongoEntry {
+		other := MongoEntry{Key: "k"}
+		other.Update([]byte("v1"), "", nil, "n2", now)
+		return other
+	}())
+
+	tests := []struct {
+		name  string
+		cond  Condition
+		entry *MongoEntry
+		want  bool
+	}{
+		{"absent on missing key", Condition{Mode: COND_ABSENT}, nil, true},
+		{"absent on live key", Condition{Mode: COND_ABSENT}, live, false},
+		{"absent on tombstone", Condition{Mode: COND_ABSENT}, deleted, true},
+		{"absent on expired key", Condition{Mode: COND_ABSENT}, expired, true},
+		{"value matches", Condition{Mode: COND_VALUE, Expected: "v1"}, live, true},
+		{"value differs", Condition{Mode: COND_VALUE, Expected: "v2"}, live, false},
+		{"value on missing key", Condition{Mode: COND_VALUE, Expected: "v1"}, nil, false},
+		{"value on concurrent versions", Condition{Mode: COND_VALUE, Expected: "v1"}, concurrent, false},
+		{"version matches", Condition{Mode: COND_VERSION, Version: live.Context()}, live, true},
+		{"version is older", Condition{Mode: COND_VERSION, Version: VectorClock{"n1": 0}}, live, false},
+		{"version on tombstone", Condition{Mode: COND_VERSION, Version: live.Context()}, deleted, false},
+		{"not modified since", Condition{Mode: COND_VERSION, Timest: now}, live, true},
+		{"modified since", Condition{Mode: COND_VERSION, Timest: now.Add(-time.Hour)}, live, false},
+		{"unknown mode", Condition{Mode: "other"}, live, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Check(tt.entry, now); got != tt.want {
+			t.Errorf("%s: Check = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
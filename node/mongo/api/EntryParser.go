@@ -2,8 +2,11 @@ package mongo
 
 import (
 	"JDSys/utils"
+	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -45,7 +48,26 @@ func ParseCSV(file string) ([]MongoEntry, error) {
 		tVal, _ := time.Parse(time.RFC3339, timeString)
 		accessString := line[3]
 		aVal, _ := time.Parse(time.RFC3339, accessString)
-		entry := MongoEntry{Key: line[0], Value: line[1], Timest: tVal, LastAcc: aVal, Conflict: false}
+		entry := MongoEntry{Key: line[0], Value: DecodeValue(line[1]), Timest: tVal, LastAcc: aVal, Conflict: false}
+
+		// Le entry esportate prima dell'introduzione dei vector clock non hanno le colonne di versione
+		if len(line) >= 6 {
+			entry.Version = ParseVectorClock(line[4])
+			entry.Siblings = DecodeSiblings(line[5])
+		}
+		if len(line) >= 7 {
+			entry.Deleted = line[6] == "true"
+		}
+		if len(line) >= 9 {
+			entry.History = DecodeSiblings(line[7])
+			entry.MaxVersions, _ = strconv.Atoi(line[8])
+		}
+		if len(line) >= 10 {
+			entry.Expires = ParseExpiry(line[9])
+		}
+		if len(line) >= 11 {
+			entry.ContentType = line[10]
+		}
 		entryList = append(entryList, entry)
 	}
 	defer csvFile.Close()
@@ -54,7 +76,107 @@ func ParseCSV(file string) ([]MongoEntry, error) {
 }
 
 /*
-Unisce le Entry tenendo in caso di conflitti sempre quella piu recente
+Scrive una lista di Entry su un file CSV, utilizzando lo stesso formato prodotto da mongoexport.
+Permette ai motori di storage diversi da MongoDB di esportare le proprie entry.
+*/
+func WriteCSV(file string, entries []MongoEntry) error {
+	csvFile, err := os.Create(file)
+	if err != nil {
+		utils.PrintTs("WriteCSV Error: " + err.Error())
+		return err
+	}
+	defer csvFile.Close()
+
+	csvw := csv.NewWriter(csvFile)
+	csvw.Write([]string{"_id", "value", "timest", "lastAcc", "version", "siblings", "deleted", "history", "maxVersions", "expires", "contentType"})
+	for _, entry := range entries {
+		timest := entry.Timest.UTC().Format(time.RFC3339Nano)
+		lastAcc := entry.LastAcc.UTC().Format(time.RFC3339Nano)
+		deleted := strconv.FormatBool(entry.Deleted)
+		maxVersions := strconv.Itoa(entry.MaxVersions)
+		csvw.Write([]string{entry.Key, EncodeValue(entry.Value), timest, lastAcc, entry.Version.String(), EncodeSiblings(entry.Siblings),
+			deleted, EncodeSiblings(entry.History), maxVersions, FormatExpiry(entry.Expires), entry.ContentType})
+	}
+	csvw.Flush()
+	return csvw.Error()
+}
+
+/*
+Codifica in JSON le versioni concorrenti di un'entry, per salvarle in un unico campo del documento o del CSV
+*/
+func EncodeSiblings(siblings []Sibling) string {
+	if len(siblings) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(siblings)
+	if err != nil {
+		utils.PrintTs("EncodeSiblings Error: " + err.Error())
+		return ""
+	}
+	return string(data)
+}
+
+/*
+Ottiene le versioni concorrenti di un'entry dalla loro codifica JSON
+*/
+func DecodeSiblings(str string) []Sibling {
+	if str == "" {
+		return nil
+	}
+	var siblings []Sibling
+	err := json.Unmarshal([]byte(str), &siblings)
+	if err != nil {
+		utils.PrintTs("DecodeSiblings Error: " + err.Error())
+		return nil
+	}
+	return siblings
+}
+
+/*
+Codifica il valore di un'entry in base64, così che valori binari con virgole, virgolette o ritorni a capo
+attraversino senza modifiche i file CSV utilizzati da replicazione, migrazione e cloud storage
+*/
+func EncodeValue(value []byte) string {
+	return base64.StdEncoding.EncodeToString(value)
+}
+
+/*
+Decodifica il valore base64 di un'entry
+*/
+func DecodeValue(str string) []byte {
+	value, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		utils.PrintTs("DecodeValue Error: " + err.Error())
+		return nil
+	}
+	return value
+}
+
+/*
+Converte la scadenza di un'entry in stringa, vuota per un'entry senza scadenza
+*/
+func FormatExpiry(expires time.Time) string {
+	if expires.IsZero() {
+		return ""
+	}
+	return expires.UTC().Format(time.RFC3339Nano)
+}
+
+/*
+Ottiene la scadenza di un'entry dalla sua stringa, vuota per un'entry senza scadenza
+*/
+func ParseExpiry(str string) time.Time {
+	expires, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return time.Time{}
+	}
+	return expires
+}
+
+/*
+Unisce le Entry confrontandone i vector clock. In caso di scritture concorrenti vengono mantenute
+tutte le versioni come siblings, altrimenti si tiene quella che discende dalle altre.
+Una chiave cancellata resta tale, perchè il suo tombstone discende dalle versioni cancellate.
 */
 func MergeEntries(local []MongoEntry, update []MongoEntry) []MongoEntry {
 	utils.PrintTs("Merging Database Entries")
@@ -67,11 +189,7 @@ func MergeEntries(local []MongoEntry, update []MongoEntry) []MongoEntry {
 			if local[i].Key == update[j].Key {
 				local[i].Conflict = true
 				update[j].Conflict = true
-				if local[i].Timest.After(update[j].Timest) {
-					latestEntry = local[i]
-				} else {
-					latestEntry = update[j]
-				}
+				latestEntry = MergeVersions(local[i], update[j])
 
 				// Appendo l'entry con conflict a false.
 				temp := latestEntry
@@ -90,38 +208,3 @@ func MergeEntries(local []MongoEntry, update []MongoEntry) []MongoEntry {
 	}
 	return mergedEntries
 }
-
-/*
-Risolve i conflitti tra le due liste di entry secondo Last Write Wins
-*/
-func ReconciliateEntries(local []MongoEntry, update []MongoEntry) []MongoEntry {
-	utils.PrintTs("Reconciliating database entries")
-
-	var reconEntries []MongoEntry
-
-	for i := 0; i < len(local); i++ {
-		for j := 0; j < len(update); j++ {
-			var latestEntry MongoEntry
-			if local[i].Key == update[j].Key {
-				local[i].Conflict = true
-				update[j].Conflict = true
-				if local[i].Timest.After(update[j].Timest) {
-					latestEntry = local[i]
-				} else {
-					latestEntry = update[j]
-				}
-
-				// Appendo l'entry con conflict a false.
-				temp := latestEntry
-				temp.Conflict = false
-				reconEntries = append(reconEntries, temp)
-			}
-		}
-	}
-	for _, u := range local {
-		if !u.Conflict {
-			reconEntries = append(reconEntries, u)
-		}
-	}
-	return reconEntries
-}
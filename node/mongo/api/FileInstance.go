@@ -0,0 +1,374 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"JDSys/utils"
+)
+
+/*
+Motore di storage embedded che mantiene le entry in memoria e le rende persistenti su un file CSV locale.
+Ogni modifica aggiunge al journal lo stato attuale delle entry modificate, e dopo STORAGE_JOURNAL_MAX modifiche
+il file CSV viene riscritto con tutte le entry ed il journal svuotato. Lo stato dello storage è il file CSV
+a cui si applicano in ordine le entry del journal.
+Le letture aggiornano solamente l'ultimo accesso, che viene salvato su file ogni STORAGE_FLUSH_INTERVAL.
+*/
+type FileInstance struct {
+	MemoryInstance
+	File       string
+	journal    *os.File
+	journaled  int  // numero di modifiche registrate nel journal dall'ultima riscrittura del file
+	accessed   bool // indica se ci sono ultimi accessi non ancora salvati su file
+	flushMutex *sync.Mutex
+}
+
+/*
+Modifica registrata nel journal: lo stato dell'entry dopo la modifica, nil se l'entry è stata rimossa
+*/
+type journalRecord struct {
+	Key   string
+	Entry *MongoEntry
+}
+
+/*
+Inizializza il motore di storage su file, rimuovendo eventuali entry residue del nodo.
+*/
+func InitFileSystem(nodeID string, file string) *FileInstance {
+	utils.PrintTs("Starting File Local System")
+	cli := new(FileInstance)
+	cli.NodeID = nodeID
+	cli.Entries = make(map[string]MongoEntry)
+	cli.mutex = new(sync.RWMutex)
+	cli.flushMutex = new(sync.Mutex)
+	cli.File = file
+
+	// Inizializza uno storage vuoto, per eliminare eventuali entry residue del nodo.
+	cli.DropDatabase()
+	go cli.flushAccesses()
+
+	utils.PrintTs("File Storage is Up & Running on " + file)
+	return cli
+}
+
+/*
+Ritorna una entry specificando la sua chiave. Lo storage viene salvato su file solo se l'entry è stata
+migrata dal cloud, altrimenti il nuovo ultimo accesso viene salvato dalla routine flushAccesses.
+*/
+func (cli *FileInstance) GetEntry(key string) *MongoEntry {
+	restored := cli.Cloud.Contains(key)
+	entry := cli.MemoryInstance.GetEntry(key)
+	if restored {
+		cli.record(key)
+	} else if entry != nil {
+		cli.flushMutex.Lock()
+		cli.accessed = true
+		cli.flushMutex.Unlock()
+	}
+	return entry
+}
+
+/*
+Ritorna una entry con tutte le sue versioni, salvando su file un'eventuale migrazione dal cloud
+*/
+func (cli *FileInstance) GetVersions(key string) *MongoEntry {
+	restored := cli.Cloud.Contains(key)
+	entry := cli.MemoryInstance.GetVersions(key)
+	if restored {
+		cli.record(key)
+	}
+	return entry
+}
+
+/*
+Inserisce un'entry, specificando la chiave ed il suo valore, e salva lo storage su file
+*/
+func (cli *FileInstance) PutEntry(key string, value []byte, contentType string, clock VectorClock, opts WriteOptions) error {
+	err := cli.MemoryInstance.PutEntry(key, value, contentType, clock, opts)
+	cli.record(key)
+	return err
+}
+
+/*
+Inserisce un'entry solamente se la precondizione è verificata, e salva lo storage su file
+*/
+func (cli *FileInstance) ConditionalPut(key string, value []byte, contentType string, cond Condition, opts WriteOptions) error {
+	err := cli.MemoryInstance.ConditionalPut(key, value, contentType, cond, opts)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Inserisce un oggetto MongoEntry nello storage e salva lo storage su file
+*/
+func (cli *FileInstance) PutMongoEntry(entry MongoEntry) {
+	cli.MemoryInstance.PutMongoEntry(entry)
+	cli.record(entry.Key)
+}
+
+/*
+Aggiorna un'entry dello storage eseguendo l'append e salva lo storage su file
+*/
+func (cli *FileInstance) AppendValue(key string, arg1 []byte, opts WriteOptions) error {
+	err := cli.MemoryInstance.AppendValue(key, arg1, opts)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Cancella un'entry dallo storage e salva lo storage su file
+*/
+func (cli *FileInstance) DeleteEntry(key string) error {
+	err := cli.MemoryInstance.DeleteEntry(key)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Rimuove fisicamente un'entry dallo storage e salva lo storage su file
+*/
+func (cli *FileInstance) PurgeEntry(key string) error {
+	err := cli.MemoryInstance.PurgeEntry(key)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Rimuove fisicamente un tombstone ancora cancellato e salva lo storage su file
+*/
+func (cli *FileInstance) PurgeTombstone(key string, olderThan time.Time) error {
+	err := cli.MemoryInstance.PurgeTombstone(key, olderThan)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Rimuove fisicamente un'entry non modificata rispetto al contesto specificato e salva lo storage su file
+*/
+func (cli *FileInstance) PurgeVersion(key string, context VectorClock) error {
+	err := cli.MemoryInstance.PurgeVersion(key, context)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Fa scadere un'entry e salva lo storage su file
+*/
+func (cli *FileInstance) ExpireEntry(key string) error {
+	err := cli.MemoryInstance.ExpireEntry(key)
+	if err == nil {
+		cli.record(key)
+	}
+	return err
+}
+
+/*
+Unisce un'entry ricevuta con quella locale e salva lo storage su file
+*/
+func (cli *FileInstance) MergeEntry(entry MongoEntry) error {
+	err := cli.MemoryInstance.MergeEntry(entry)
+	if err == nil {
+		cli.record(entry.Key)
+	}
+	return err
+}
+
+/*
+Unisce un gruppo di entry ricevute con quelle locali e le registra nel journal con un'unica scrittura
+*/
+func (cli *FileInstance) MergeBatch(entries []MongoEntry) error {
+	err := cli.MemoryInstance.MergeBatch(entries)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	return cli.record(keys...)
+}
+
+/*
+Unisce le entry ricevute con quelle locali e riscrive il file dello storage, invece di registrare nel journal
+ogni entry della collezione ricevuta
+*/
+func (cli *FileInstance) MergeCollection(exportFile string, receivedFile string) error {
+	err := cli.MemoryInstance.MergeCollection(exportFile, receivedFile)
+	if err != nil {
+		return err
+	}
+	return cli.snapshot()
+}
+
+/*
+Routine che periodicamente controlla tutte le entry per vedere se è possibile
+effettuare una migrazione delle risorse verso il cloud S3
+*/
+func (cli *FileInstance) CheckRarelyAccessed() {
+	cli.Cloud.CheckRarelyAccessed(cli)
+}
+
+/*
+Salva lo storage su file prima della chiusura e chiude il journal
+*/
+func (cli *FileInstance) CloseConnection() {
+	cli.snapshot()
+	cli.flushMutex.Lock()
+	if cli.journal != nil {
+		cli.journal.Close()
+		cli.journal = nil
+	}
+	cli.flushMutex.Unlock()
+	utils.PrintTs("File storage closed.")
+}
+
+/*
+Cancella tutte le entry dello storage, il relativo file ed il journal
+*/
+func (cli *FileInstance) DropDatabase() {
+	cli.replaceEntries(nil)
+	cli.flushMutex.Lock()
+	defer cli.flushMutex.Unlock()
+	if cli.journal != nil {
+		cli.journal.Close()
+		cli.journal = nil
+	}
+	cli.journaled = 0
+	for _, file := range []string{cli.File, cli.journalFile()} {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			utils.PrintTs(err.Error())
+			return
+		}
+	}
+	utils.PrintTs("Local storage cleaned succesfully")
+}
+
+/*
+Routine che periodicamente salva su file gli ultimi accessi delle letture, se ce ne sono di nuovi
+*/
+func (cli *FileInstance) flushAccesses() {
+	for {
+		time.Sleep(utils.STORAGE_FLUSH_INTERVAL)
+		cli.flushMutex.Lock()
+		accessed := cli.accessed
+		cli.flushMutex.Unlock()
+		if accessed {
+			cli.snapshot()
+		}
+	}
+}
+
+/*
+Ritorna il file del journal dello storage
+*/
+func (cli *FileInstance) journalFile() string {
+	return cli.File + ".journal"
+}
+
+/*
+Aggiunge al journal lo stato attuale delle entry specificate con un'unica scrittura, sincronizzata su disco
+prima di ritornare. Dopo STORAGE_JOURNAL_MAX modifiche il file dello storage viene riscritto.
+*/
+func (cli *FileInstance) record(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cli.flushMutex.Lock()
+	defer cli.flushMutex.Unlock()
+
+	var buffer bytes.Buffer
+	cli.mutex.RLock()
+	for _, key := range keys {
+		record := journalRecord{Key: key}
+		if entry, ok := cli.Entries[key]; ok {
+			record.Entry = &entry
+		}
+		line, _ := json.Marshal(record)
+		buffer.Write(append(line, '\n'))
+	}
+	cli.mutex.RUnlock()
+
+	var err error
+	if cli.journal == nil {
+		cli.journal, err = os.OpenFile(cli.journalFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err == nil {
+		_, err = cli.journal.Write(buffer.Bytes())
+	}
+	if err == nil {
+		err = cli.journal.Sync()
+	}
+	if err != nil {
+		utils.PrintTs("Journal Error: " + err.Error())
+		return err
+	}
+	cli.journaled += len(keys)
+	if cli.journaled >= utils.STORAGE_JOURNAL_MAX {
+		return cli.writeSnapshot()
+	}
+	return nil
+}
+
+/*
+Riscrive il file dello storage con tutte le entry e svuota il journal
+*/
+func (cli *FileInstance) snapshot() error {
+	cli.flushMutex.Lock()
+	defer cli.flushMutex.Unlock()
+	return cli.writeSnapshot()
+}
+
+/*
+Scrive tutte le entry dello storage sul file, passando per un file temporaneo che viene sincronizzato
+su disco prima di essere rinominato, così che un crash lasci sempre la versione precedente o quella nuova.
+Solo dopo la rinomina il journal viene svuotato: le sue entry riapplicate al nuovo file non lo modificano.
+Il chiamante deve possedere flushMutex.
+*/
+func (cli *FileInstance) writeSnapshot() error {
+	tmp := cli.File + ".tmp"
+	err := WriteCSV(tmp, cli.ListEntries())
+	if err == nil {
+		err = syncFile(tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, cli.File)
+	}
+	if err == nil && cli.journal != nil {
+		err = cli.journal.Truncate(0)
+	}
+	if err != nil {
+		utils.PrintTs("Flush Error: " + err.Error())
+		return err
+	}
+	cli.journaled = 0
+	cli.accessed = false
+	return nil
+}
+
+/*
+Sincronizza su disco il contenuto del file specificato
+*/
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
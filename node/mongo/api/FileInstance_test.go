@@ -0,0 +1,118 @@
+package mongo
+
+import (
+	"JDSys/utils"
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+Ricostruisce lo storage salvato dal motore su file: il file CSV, se presente, a cui si applica il journal
+*/
+func replayStorage(t *testing.T, cli *FileInstance) (map[string]MongoEntry, int) {
+	stored := make(map[string]MongoEntry)
+	if _, err := os.Stat(cli.File); err == nil {
+		entries, err := ParseCSV(cli.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			stored[entry.Key] = entry
+		}
+	}
+	file, err := os.Open(cli.journalFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("journal record %d: %v", records, err)
+		}
+		if record.Entry == nil {
+			delete(stored, record.Key)
+		} else {
+			stored[record.Key] = *record.Entry
+		}
+		records++
+	}
+	return stored, records
+}
+
+/*
+Verifica che lo storage salvato contenga le stesse entry di quello in memoria
+*/
+func checkStored(t *testing.T, cli *FileInstance, stored map[string]MongoEntry) {
+	entries := cli.ListEntries()
+	if len(stored) != len(entries) {
+		t.Fatalf("stored %d entries, want %d", len(stored), len(entries))
+	}
+	for _, entry := range entries {
+		if saved, ok := stored[entry.Key]; !ok || string(saved.Value) != string(entry.Value) || saved.IsDeleted() != entry.IsDeleted() {
+			t.Errorf("stored entry %s differs from the one in memory", entry.Key)
+		}
+	}
+}
+
+func TestFileJournalRecordsChanges(t *testing.T) {
+	cli := InitFileSystem("n1", filepath.Join(t.TempDir(), "storage.csv"))
+	cli.PutEntry("a", []byte("v1"), "", nil, WriteOptions{})
+	cli.PutEntry("b", []byte("v1"), "", nil, WriteOptions{})
+	cli.DeleteEntry("a")
+	cli.PurgeEntry("b")
+
+	var batch []MongoEntry
+	for _, key := range []string{"c", "d"} {
+		entry := MongoEntry{Key: key}
+		entry.Update([]byte("v1"), "", nil, "n2", Now())
+		batch = append(batch, entry)
+	}
+	cli.MergeBatch(batch)
+
+	// Le modifiche vengono solamente aggiunte al journal, senza riscrivere il file dello storage
+	if _, err := os.Stat(cli.File); !os.IsNotExist(err) {
+		t.Fatalf("storage file rewritten by single changes")
+	}
+	stored, records := replayStorage(t, cli)
+	if records != 6 {
+		t.Errorf("journal has %d records, want 6", records)
+	}
+	checkStored(t, cli, stored)
+}
+
+func TestFileJournalCompaction(t *testing.T) {
+	journalMax := utils.STORAGE_JOURNAL_MAX
+	utils.STORAGE_JOURNAL_MAX = 3
+	defer func() { utils.STORAGE_JOURNAL_MAX = journalMax }()
+
+	cli := InitFileSystem("n1", filepath.Join(t.TempDir(), "storage.csv"))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cli.PutEntry(key, []byte("v1"), "", nil, WriteOptions{})
+	}
+	cli.PurgeEntry("a")
+
+	// Raggiunte STORAGE_JOURNAL_MAX modifiche il file viene riscritto ed il journal svuotato
+	stored, records := replayStorage(t, cli)
+	if records != 2 {
+		t.Errorf("journal has %d records after the compaction, want 2", records)
+	}
+	checkStored(t, cli, stored)
+
+	cli.CloseConnection()
+	stored, records = replayStorage(t, cli)
+	if records != 0 {
+		t.Errorf("journal has %d records after closing, want 0", records)
+	}
+	checkStored(t, cli, stored)
+
+	cli.DropDatabase()
+	if _, err := os.Stat(cli.journalFile()); !os.IsNotExist(err) {
+		t.Errorf("journal not removed with the storage")
+	}
+}
@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"JDSys/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+var MANIFEST_TYPE string = "application/x-jdsys-manifest" // Content-type del manifest di un valore suddiviso in chunk
+var CHUNK_TYPE string = "application/x-jdsys-chunk"       // Content-type di un chunk
+var CHUNK_PREFIX string = "chunk:"                        // Prefisso delle chiavi dei chunk, non inseribile dal client
+
+/*
+Manifest di un valore suddiviso in chunk. Viene memorizzato come valore della chiave dell'utente, mentre
+i chunk sono entry distinte posizionate sull'anello in base all'hash della loro chiave.
+*/
+type Manifest struct {
+	ContentType string   // content-type del valore originale
+	Size        int      // dimensione in byte del valore originale
+	Chunks      []string // chiavi dei chunk, nell'ordine in cui vanno concatenati
+}
+
+/*
+Ritorna la chiave di un chunk, ottenuta dall'hash del suo contenuto. L'hash include la chiave dell'utente,
+così che ogni chunk appartenga ad un'unica chiave e possa essere cancellato insieme a questa.
+*/
+func ChunkKey(key string, data []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(key))
+	hash.Write([]byte{0})
+	hash.Write(data)
+	return CHUNK_PREFIX + hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+Verifica se una chiave identifica un chunk
+*/
+func IsChunkKey(key string) bool {
+	return strings.HasPrefix(key, CHUNK_PREFIX)
+}
+
+/*
+Suddivide un valore in chunk di dimensione size. Ritorna il manifest del valore ed i chunk indicizzati
+per chiave: chunk con lo stesso contenuto hanno la stessa chiave, e vengono memorizzati una sola volta.
+*/
+func SplitChunks(key string, value []byte, contentType string, size int) (Manifest, map[string][]byte) {
+	manifest := Manifest{ContentType: contentType, Size: len(value)}
+	chunks := make(map[string][]byte)
+	for start := 0; start < len(value); start += size {
+		end := start + size
+		if end > len(value) {
+			end = len(value)
+		}
+		chunkKey := ChunkKey(key, value[start:end])
+		manifest.Chunks = append(manifest.Chunks, chunkKey)
+		chunks[chunkKey] = value[start:end]
+	}
+	return manifest, chunks
+}
+
+/*
+Codifica il manifest in JSON, per memorizzarlo come valore della chiave
+*/
+func EncodeManifest(manifest Manifest) []byte {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		utils.PrintTs("EncodeManifest Error: " + err.Error())
+		return nil
+	}
+	return data
+}
+
+/*
+Ottiene il manifest dal valore di una chiave, nil se il valore non è un manifest valido
+*/
+func DecodeManifest(value []byte, contentType string) *Manifest {
+	if contentType != MANIFEST_TYPE {
+		return nil
+	}
+	var manifest Manifest
+	err := json.Unmarshal(value, &manifest)
+	if err != nil {
+		utils.PrintTs("DecodeManifest Error: " + err.Error())
+		return nil
+	}
+	return &manifest
+}
+
+/*
+Ritorna le chiavi di tutti i chunk referenziati dall'entry, considerando sia le versioni attuali che lo storico
+*/
+func (me *MongoEntry) ChunkKeys() []string {
+	var keys []string
+	for _, version := range append(me.Versions(), me.History...) {
+		manifest := DecodeManifest(version.Value, version.ContentType)
+		if manifest == nil {
+			continue
+		}
+		for _, chunkKey := range manifest.Chunks {
+			if !utils.StringInSlice(chunkKey, keys) {
+				keys = append(keys, chunkKey)
+			}
+		}
+	}
+	return keys
+}
+
+/*
+Formatta il manifest per essere visualizzato dal client, senza ricostruire il valore
+*/
+func (manifest *Manifest) format() string {
+	contentType := manifest.ContentType
+	if contentType == "" {
+		contentType = "binary"
+	}
+	return "<" + strconv.Itoa(manifest.Size) + " bytes, " + contentType + ", " + strconv.Itoa(len(manifest.Chunks)) + " chunks>"
+}
@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  []byte
+		size   int
+		chunks int
+		stored int
+	}{
+		{"empty value", nil, 4, 0, 0},
+		{"smaller than a chunk", []byte("abc"), 4, 1, 1},
+		{"exact multiple", []byte("abcdefgh"), 4, 2, 2},
+		{"last chunk shorter", []byte("abcdefghij"), 4, 3, 3},
+		{"repeated content", []byte("abcdabcdabcd"), 4, 3, 1},
+	}
+	for _, tt := range tests {
+		manifest, chunks := SplitChunks("k", tt.value, "text/plain", tt.size)
+		if manifest.Size != len(tt.value) || manifest.ContentType != "text/plain" {
+			t.Errorf("%s: manifest = %+v", tt.name, manifest)
+		}
+		if len(manifest.Chunks) != tt.chunks || len(chunks) != tt.stored {
+			t.Errorf("%s: %d chunks with %d distinct, want %d with %d", tt.name, len(manifest.Chunks), len(chunks), tt.chunks, tt.stored)
+		}
+		var joined []byte
+		for _, chunkKey := range manifest.Chunks {
+			if !IsChunkKey(chunkKey) {
+				t.Errorf("%s: %q is not a chunk key", tt.name, chunkKey)
+			}
+			joined = append(joined, chunks[chunkKey]...)
+		}
+		if !bytes.Equal(joined, tt.value) {
+			t.Errorf("%s: reassembled %q, want %q", tt.name, joined, tt.value)
+		}
+	}
+
+	// Lo stesso contenuto sotto chiavi diverse produce chunk diversi
+	if ChunkKey("a", []byte("x")) == ChunkKey("b", []byte("x")) {
+		t.Fatalf("chunks of different keys share the same chunk key")
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	manifest, _ := SplitChunks("k", []byte("0123456789"), "image/png", 3)
+	decoded := DecodeManifest(EncodeManifest(manifest), MANIFEST_TYPE)
+	if decoded == nil || decoded.Size != manifest.Size || decoded.ContentType != manifest.ContentType ||
+		len(decoded.Chunks) != len(manifest.Chunks) {
+		t.Fatalf("decoded manifest = %+v, want %+v", decoded, manifest)
+	}
+	if DecodeManifest(EncodeManifest(manifest), "application/json") != nil {
+		t.Fatalf("a value with a different content type was decoded as a manifest")
+	}
+	if DecodeManifest([]byte("not json"), MANIFEST_TYPE) != nil {
+		t.Fatalf("an invalid manifest was decoded")
+	}
+
+	// Le chiavi dei chunk comprendono quelle delle versioni nello storico, senza duplicati
+	now := time.Now()
+	entry := MongoEntry{Key: "k"}
+	entry.SetMaxVersions(3)
+	entry.Update(EncodeManifest(manifest), MANIFEST_TYPE, nil, "n1", now)
+	other, _ := SplitChunks("k", []byte("012345abcd"), "image/png", 3)
+	entry.Update(EncodeManifest(other), MANIFEST_TYPE, entry.Context(), "n1", now.Add(time.Second))
+	if keys := entry.ChunkKeys(); len(keys) != 6 {
+		t.Fatalf("ChunkKeys returned %d keys, want 6", len(keys))
+	}
+}
+
+func TestAppendOnChunkedValue(t *testing.T) {
+	cli := InitMemorySystem("n1")
+	manifest, _ := SplitChunks("k", []byte("0123456789"), "", 3)
+	cli.PutEntry("k", EncodeManifest(manifest), MANIFEST_TYPE, nil, WriteOptions{})
+	if err := cli.AppendValue("k", []byte("x"), WriteOptions{}); err == nil || err.Error() != "ChunkedValue" {
+		t.Fatalf("AppendValue on a manifest = %v, want ChunkedValue", err)
+	}
+	if err := cli.AppendValue("missing", []byte("x"), WriteOptions{}); err == nil || err.Error() != "NoKeyFound" {
+		t.Fatalf("AppendValue on a missing key = %v, want NoKeyFound", err)
+	}
+}
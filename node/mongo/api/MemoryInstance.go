@@ -0,0 +1,425 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"JDSys/utils"
+)
+
+/*
+Motore di storage che mantiene tutte le entry in memoria. Non richiede alcun database esterno, ed è quindi
+utilizzabile per eseguire i nodi ed i test su una qualsiasi macchina Linux.
+*/
+type MemoryInstance struct {
+	Entries map[string]MongoEntry
+	Cloud   CloudTier
+	NodeID  string
+	mutex   *sync.RWMutex
+}
+
+/*
+Inizializza il motore di storage in memoria, partendo da uno storage vuoto
+*/
+func InitMemorySystem(nodeID string) *MemoryInstance {
+	utils.PrintTs("Starting Memory Local System")
+	cli := new(MemoryInstance)
+	cli.NodeID = nodeID
+	cli.Entries = make(map[string]MongoEntry)
+	cli.mutex = new(sync.RWMutex)
+	utils.PrintTs("Memory Storage is Up & Running")
+	return cli
+}
+
+/*
+Ritorna una entry specificando la sua chiave. Se l'entry è presente nel cloud storage, viene migrata in locale prima di ritornarla.
+*/
+func (cli *MemoryInstance) GetEntry(key string) *MongoEntry {
+	utils.PrintHeaderL3("Memory Get, Searching for: " + key)
+	cli.Cloud.Restore(cli, key)
+
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok {
+		utils.PrintTs("Get Error: no entry found with key " + key)
+		return nil
+	}
+	if entry.IsDeleted() {
+		utils.PrintTs("Get Error: entry with key " + key + " has been deleted")
+		return nil
+	}
+	if entry.IsExpired(timestamp) {
+		utils.PrintTs("Get Error: entry with key " + key + " has expired")
+		return nil
+	}
+	entry.LastAcc = timestamp
+	cli.Entries[key] = entry
+	utils.PrintTs("Found: " + entry.Format())
+	return &entry
+}
+
+/*
+Legge una entry senza effettuare un accesso effettivo alla risorsa. Utile per identificare le entry raramente utilizzate
+*/
+func (cli *MemoryInstance) ReadEntry(key string) *MongoEntry {
+	cli.mutex.RLock()
+	defer cli.mutex.RUnlock()
+	entry, ok := cli.Entries[key]
+	if !ok {
+		utils.PrintTs("Read Error: no entry found with key " + key)
+		return nil
+	}
+	return &entry
+}
+
+/*
+Ritorna una entry con tutte le sue versioni, comprese quelle dello storico ed un eventuale tombstone.
+Se l'entry è presente nel cloud storage, viene migrata in locale prima di ritornarla.
+*/
+func (cli *MemoryInstance) GetVersions(key string) *MongoEntry {
+	utils.PrintHeaderL3("Memory Versions, Searching for: " + key)
+	cli.Cloud.Restore(cli, key)
+	return cli.ReadEntry(key)
+}
+
+/*
+Ritorna tutte le entry dello storage ordinate per chiave, senza aggiornarne l'ultimo accesso
+*/
+func (cli *MemoryInstance) ListEntries() []MongoEntry {
+	cli.mutex.RLock()
+	defer cli.mutex.RUnlock()
+	entries := make([]MongoEntry, 0, len(cli.Entries))
+	for _, entry := range cli.Entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+/*
+Inserisce un'entry, specificando la chiave ed il suo valore. Se l'entry è già presente nello storage locale
+questa viene aggiornata inserendo una nuova versione che discende dal contesto clock, ritornando l'errore "Updated" come MongoInstance.
+*/
+func (cli *MemoryInstance) PutEntry(key string, value []byte, contentType string, clock VectorClock, opts WriteOptions) error {
+	entry := fmt.Sprintf("{ %s , %s }", key, FormatBytes(value, contentType))
+	utils.PrintHeaderL3("Memory Put, inserting " + entry)
+	cli.Cloud.Restore(cli, key)
+
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	stored, exists := cli.Entries[key]
+	if !exists {
+		stored = MongoEntry{Key: key}
+	}
+
+	// Una chiave cancellata o scaduta viene reinserita, la nuova versione discende comunque dal suo tombstone
+	live := exists && stored.IsLive(timestamp)
+	stored.Update(value, contentType, clock, cli.NodeID, timestamp)
+	opts.apply(&stored)
+	cli.Entries[key] = stored
+	if live {
+		utils.PrintTs("Update: Entry for key " + key + ", updated into " + entry)
+		return errors.New("Updated")
+	}
+	utils.PrintTs("Entry " + entry + " succesfully inserted into local storage")
+	return nil
+}
+
+/*
+Inserisce un'entry solamente se la precondizione è verificata sull'entry memorizzata. La verifica e la scrittura
+avvengono sotto lo stesso lock, così che nessun'altra scrittura possa interporsi. Se la precondizione non è
+verificata ritorna l'errore "PreconditionFailed".
+*/
+func (cli *MemoryInstance) ConditionalPut(key string, value []byte, contentType string, cond Condition, opts WriteOptions) error {
+	entry := fmt.Sprintf("{ %s , %s }", key, FormatBytes(value, contentType))
+	utils.PrintHeaderL3("Memory Conditional Put (" + cond.Mode + "), inserting " + entry)
+	cli.Cloud.Restore(cli, key)
+
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	stored, exists := cli.Entries[key]
+	var current *MongoEntry
+	if exists {
+		current = &stored
+	} else {
+		stored = MongoEntry{Key: key}
+	}
+	if !cond.Check(current, timestamp) {
+		utils.PrintTs("Precondition failed for key " + key)
+		return errors.New("PreconditionFailed")
+	}
+	stored.Update(value, contentType, nil, cli.NodeID, timestamp)
+	opts.apply(&stored)
+	cli.Entries[key] = stored
+	utils.PrintTs("Entry " + entry + " conditionally written into local storage")
+	return nil
+}
+
+/*
+Inserisce un oggetto MongoEntry nello storage, sovrascrivendo un'eventuale entry con la stessa chiave.
+Utilizzata durante l'aggiornamento delle entry dello storage locale.
+*/
+func (cli *MemoryInstance) PutMongoEntry(entry MongoEntry) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry.Conflict = false
+	cli.Entries[entry.Key] = entry
+}
+
+/*
+Aggiorna un'entry dello storage, specificando la chiave ed il nuovo valore da aggiungere.
+Viene inoltre aggiornato il timestamp di quell'entry, e la nuova versione discende da quella principale.
+Ritorna l'errore "ChunkedValue" se il valore è suddiviso in chunk, su cui l'append non è supportato.
+*/
+func (cli *MemoryInstance) AppendValue(key string, arg1 []byte, opts WriteOptions) error {
+	utils.PrintHeaderL3("Memory Append, adding " + strconv.Itoa(len(arg1)) + " bytes to key " + key)
+	cli.Cloud.Restore(cli, key)
+
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok || !entry.IsLive(timestamp) {
+		utils.PrintTs("Append Error: No entry found with key " + key)
+		return errors.New("NoKeyFound")
+	}
+	if entry.ContentType == MANIFEST_TYPE {
+		utils.PrintTs("Append Error: value of key " + key + " is split into chunks")
+		return errors.New("ChunkedValue")
+	}
+	if opts.Expires.IsZero() {
+		opts.Expires = entry.Expires
+	}
+	entry.Update(AppendBytes(entry.Value, entry.ContentType, arg1), entry.ContentType, entry.Version, cli.NodeID, timestamp)
+	opts.apply(&entry)
+	cli.Entries[key] = entry
+	utils.PrintTs("Append: inserted " + FormatBytes(arg1, entry.ContentType) + " to key " + key)
+	return nil
+}
+
+/*
+Cancella un'entry dallo storage, specificando la sua chiave. L'entry viene sostituita da un tombstone che ne
+impedisce la reintroduzione durante merge e riconciliazione. Se l'entry è presente sul cloud, viene prima
+migrata in locale e rimossa dal bucket S3
+*/
+func (cli *MemoryInstance) DeleteEntry(key string) error {
+	utils.PrintHeaderL3("Memory Delete, removing entry with key " + key)
+	cli.Cloud.Restore(cli, key)
+
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok || !entry.IsLive(timestamp) {
+		utils.PrintTs("Delete Error: No entry found with key " + key)
+		return errors.New("EntryNotFound")
+	}
+	entry.Tombstone(entry.Context(), cli.NodeID, timestamp)
+	cli.Entries[key] = entry
+	utils.PrintTs("Deleted " + key)
+	return nil
+}
+
+/*
+Rimuove fisicamente un'entry dallo storage, senza lasciare alcun tombstone
+*/
+func (cli *MemoryInstance) PurgeEntry(key string) error {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	if _, ok := cli.Entries[key]; !ok {
+		return errors.New("EntryNotFound")
+	}
+	delete(cli.Entries, key)
+	utils.PrintTs("Purged " + key)
+	return nil
+}
+
+/*
+Rimuove fisicamente un tombstone, solamente se tutte le versioni della chiave sono ancora cancellate e la cancellazione
+non è successiva ad olderThan. La verifica avviene sotto il lock dello storage, così che una chiave appena reinserita
+non venga rimossa. Ritorna l'errore "NotExpired" se la chiave non è più un tombstone da rimuovere.
+*/
+func (cli *MemoryInstance) PurgeTombstone(key string, olderThan time.Time) error {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok {
+		return errors.New("EntryNotFound")
+	}
+	if !entry.IsDeleted() || entry.Timest.After(olderThan) {
+		utils.PrintTs("Key " + key + " changed, tombstone not removed")
+		return errors.New("NotExpired")
+	}
+	delete(cli.Entries, key)
+	utils.PrintTs("Purged tombstone of " + key)
+	return nil
+}
+
+/*
+Rimuove fisicamente un'entry, solamente se il suo contesto è ancora quello specificato. La verifica avviene
+sotto il lock dello storage, così che una scrittura arrivata dopo la lettura dell'entry non venga rimossa.
+Ritorna l'errore "EntryChanged" se l'entry è stata modificata.
+*/
+func (cli *MemoryInstance) PurgeVersion(key string, context VectorClock) error {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok {
+		return errors.New("EntryNotFound")
+	}
+	if !entry.Context().Equal(context) {
+		utils.PrintTs("Key " + key + " changed, entry not removed")
+		return errors.New("EntryChanged")
+	}
+	delete(cli.Entries, key)
+	utils.PrintTs("Purged " + key)
+	return nil
+}
+
+/*
+Sostituisce un'entry scaduta con un tombstone datato alla sua scadenza, così che tutte le repliche
+che la fanno scadere producano la stessa cancellazione
+*/
+func (cli *MemoryInstance) ExpireEntry(key string) error {
+	timestamp := getTimestamp()
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	entry, ok := cli.Entries[key]
+	if !ok || entry.IsDeleted() {
+		return errors.New("EntryNotFound")
+	}
+	if !entry.IsExpired(timestamp) {
+		return errors.New("NotExpired")
+	}
+	entry.Tombstone(entry.Context(), cli.NodeID, entry.Expires)
+	cli.Entries[key] = entry
+	utils.PrintTs("Expired " + key)
+	return nil
+}
+
+/*
+Unisce un'entry ricevuta da un'altra replica con quella locale, confrontandone i vector clock.
+Se la chiave è presente sul cloud storage, viene prima migrata in locale.
+*/
+func (cli *MemoryInstance) MergeEntry(entry MongoEntry) error {
+	cli.Cloud.Restore(cli, entry.Key)
+
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	cli.mergeEntry(entry)
+	utils.PrintTs("Merged replica of " + entry.Key)
+	return nil
+}
+
+/*
+Unisce un gruppo di entry ricevute con quelle locali, una alla volta
+*/
+func (cli *MemoryInstance) MergeBatch(entries []MongoEntry) error {
+	for _, entry := range entries {
+		cli.MergeEntry(entry)
+	}
+	return nil
+}
+
+/*
+Esporta tutte le entry dello storage, scrivendole su un file csv
+*/
+func (cli *MemoryInstance) ExportCollection(filename string) error {
+	err := WriteCSV(filename, cli.ListEntries())
+	if err != nil {
+		utils.PrintTs(err.Error())
+		return err
+	}
+	utils.PrintTs("Memory: Collection exported successfully: " + filename)
+	return nil
+}
+
+/*
+Esporta una entry specifica in formato CSV.
+*/
+func (cli *MemoryInstance) ExportDocument(key string, filename string) error {
+	var entries []MongoEntry
+	if entry := cli.ReadEntry(key); entry != nil {
+		entries = append(entries, *entry)
+	}
+	err := WriteCSV(filename, entries)
+	if err != nil {
+		utils.PrintTs(err.Error())
+		return err
+	}
+	utils.PrintTs("Memory: Document exported successfully: " + filename)
+	return nil
+}
+
+/*
+Invocata quando un nodo sta inviando le informazioni nel proprio DB. Si unisce il CSV ricevuto
+con le entry locali sotto un unico lock, così che nessuna scrittura concorrente venga sovrascritta.
+*/
+func (cli *MemoryInstance) MergeCollection(exportFile string, receivedFile string) error {
+	utils.PrintHeaderL3("Merging memory local storage")
+	receivedUpdate, err := ParseCSV(receivedFile)
+	if err != nil {
+		return err
+	}
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	for _, entry := range receivedUpdate {
+		cli.mergeEntry(entry)
+	}
+	utils.PrintTs("Collection merged succesfully")
+	return nil
+}
+
+/*
+Ritorna le chiavi migrate sul cloud storage
+*/
+func (cli *MemoryInstance) ListCloudKeys() []string {
+	return append([]string(nil), cli.Cloud.Keys...)
+}
+
+/*
+Routine che periodicamente controlla tutte le entry per vedere se è possibile
+effettuare una migrazione delle risorse verso il cloud S3
+*/
+func (cli *MemoryInstance) CheckRarelyAccessed() {
+	cli.Cloud.CheckRarelyAccessed(cli)
+}
+
+/*
+Lo storage in memoria non mantiene connessioni aperte
+*/
+func (cli *MemoryInstance) CloseConnection() {
+	utils.PrintTs("Memory storage closed.")
+}
+
+/*
+Unisce un'entry ricevuta con quella memorizzata, il chiamante deve possedere il lock dello storage
+*/
+func (cli *MemoryInstance) mergeEntry(entry MongoEntry) {
+	if stored, ok := cli.Entries[entry.Key]; ok {
+		entry = MergeVersions(stored, entry)
+	}
+	entry.Conflict = false
+	cli.Entries[entry.Key] = entry
+}
+
+/*
+Sostituisce tutte le entry dello storage con quelle specificate
+*/
+func (cli *MemoryInstance) replaceEntries(entries []MongoEntry) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	cli.Entries = make(map[string]MongoEntry)
+	for _, entry := range entries {
+		entry.Conflict = false
+		cli.Entries[entry.Key] = entry
+	}
+}
@@ -0,0 +1,128 @@
+package mongo
+
+import (
+	"JDSys/utils"
+	"crypto/sha256"
+	"sort"
+)
+
+/*
+Merkle tree delle entry di un intervallo di chiavi. Le foglie suddividono lo spazio degli hash delle chiavi
+in 2^Depth intervalli, in base ai primi Depth bit dell'hash utilizzato anche da Chord, e contengono il digest
+di ogni entry. Due repliche con le stesse versioni di tutte le chiavi hanno la stessa radice, mentre in caso
+di divergenza differiscono solamente i nodi sul percorso dalle foglie con chiavi diverse fino alla radice.
+*/
+type MerkleTree struct {
+	Depth  int
+	Levels [][][sha256.Size]byte          // Levels[0] contiene la radice, Levels[Depth] le foglie
+	Leaves []map[string][sha256.Size]byte // digest delle entry di ogni foglia
+}
+
+/*
+Costruisce il Merkle tree delle entry specificate, comprese quelle cancellate, così che anche
+un tombstone mancante su una replica venga rilevato
+*/
+func BuildMerkleTree(entries []MongoEntry, depth int) *MerkleTree {
+	tree := &MerkleTree{Depth: depth}
+	tree.Leaves = make([]map[string][sha256.Size]byte, 1<<depth)
+	for _, entry := range entries {
+		leaf := LeafIndex(entry.Key, depth)
+		if tree.Leaves[leaf] == nil {
+			tree.Leaves[leaf] = make(map[string][sha256.Size]byte)
+		}
+		tree.Leaves[leaf][entry.Key] = EntryDigest(&entry)
+	}
+
+	tree.Levels = make([][][sha256.Size]byte, depth+1)
+	tree.Levels[depth] = make([][sha256.Size]byte, 1<<depth)
+	for i, digests := range tree.Leaves {
+		tree.Levels[depth][i] = leafHash(digests)
+	}
+	for level := depth - 1; level >= 0; level-- {
+		tree.Levels[level] = make([][sha256.Size]byte, 1<<level)
+		for i := range tree.Levels[level] {
+			tree.Levels[level][i] = nodeHash(tree.Levels[level+1][2*i], tree.Levels[level+1][2*i+1])
+		}
+	}
+	return tree
+}
+
+/*
+Ritorna la radice del Merkle tree
+*/
+func (tree *MerkleTree) Root() [sha256.Size]byte {
+	return tree.Levels[0][0]
+}
+
+/*
+Ritorna la foglia in cui si trova una chiave, cioè i primi depth bit del suo hash
+*/
+func LeafIndex(key string, depth int) int {
+	hash := utils.HashString(key)
+	index := 0
+	for bit := 0; bit < depth; bit++ {
+		index <<= 1
+		if hash[bit/8]&(0x80>>(bit%8)) != 0 {
+			index |= 1
+		}
+	}
+	return index
+}
+
+/*
+Calcola il digest di un'entry a partire dai vector clock delle sue versioni. Le repliche che hanno ricevuto
+le stesse scritture ottengono lo stesso digest, indipendentemente dall'ordine in cui le hanno ricevute.
+*/
+func EntryDigest(entry *MongoEntry) [sha256.Size]byte {
+	var versions []string
+	for _, version := range entry.Versions() {
+		deleted := ""
+		if version.Deleted {
+			deleted = "!"
+		}
+		versions = append(versions, version.Version.String()+deleted)
+	}
+	sort.Strings(versions)
+	hash := sha256.New()
+	hash.Write([]byte(entry.Key))
+	for _, version := range versions {
+		hash.Write([]byte{0})
+		hash.Write([]byte(version))
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
+
+/*
+Calcola l'hash di una foglia dai digest delle sue entry, in ordine di chiave. Una foglia vuota ha hash nullo.
+*/
+func leafHash(digests map[string][sha256.Size]byte) [sha256.Size]byte {
+	var hash [sha256.Size]byte
+	if len(digests) == 0 {
+		return hash
+	}
+	keys := make([]string, 0, len(digests))
+	for key := range digests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		digest := digests[key]
+		h.Write(digest[:])
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+/*
+Calcola l'hash di un nodo interno dai suoi figli. Un sotto-albero vuoto ha hash nullo.
+*/
+func nodeHash(left [sha256.Size]byte, right [sha256.Size]byte) [sha256.Size]byte {
+	var empty [sha256.Size]byte
+	if left == empty && right == empty {
+		return empty
+	}
+	return sha256.Sum256(append(left[:], right[:]...))
+}
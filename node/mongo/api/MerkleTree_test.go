@@ -0,0 +1,98 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func merkleEntries(n int, now time.Time) []MongoEntry {
+	var entries []MongoEntry
+	for i := 0; i < n; i++ {
+		entry := MongoEntry{Key: "key" + strconv.Itoa(i)}
+		entry.Update([]byte("v"+strconv.Itoa(i)), "", nil, "n1", now)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestMerkleTreeRoot(t *testing.T) {
+	now := time.Now()
+	entries := merkleEntries(20, now)
+
+	reversed := make([]MongoEntry, len(entries))
+	for i, entry := range entries {
+		reversed[len(entries)-1-i] = entry
+	}
+
+	written := append([]MongoEntry(nil), entries...)
+	written[3].Update([]byte("new"), "", written[3].Context(), "n2", now.Add(time.Second))
+
+	deleted := append([]MongoEntry(nil), entries...)
+	deleted[5].Tombstone(deleted[5].Context(), "n1", now.Add(time.Second))
+
+	var empty [sha256.Size]byte
+	tests := []struct {
+		name  string
+		other []MongoEntry
+		same  bool
+	}{
+		{"same entries", entries, true},
+		{"different order", reversed, true},
+		{"newer version", written, false},
+		{"tombstone", deleted, false},
+		{"missing entry", entries[1:], false},
+	}
+	for _, depth := range []int{0, 1, 4} {
+		tree := BuildMerkleTree(entries, depth)
+		if tree.Root() == empty {
+			t.Fatalf("depth %d: root of a non-empty tree is null", depth)
+		}
+		for _, tt := range tests {
+			other := BuildMerkleTree(tt.other, depth)
+			if got := tree.Root() == other.Root(); got != tt.same {
+				t.Errorf("depth %d, %s: equal roots = %v, want %v", depth, tt.name, got, tt.same)
+			}
+		}
+	}
+	if root := BuildMerkleTree(nil, 4).Root(); root != empty {
+		t.Fatalf("root of an empty tree = %x, want null", root)
+	}
+}
+
+func TestMerkleTreeDivergentLeaf(t *testing.T) {
+	now := time.Now()
+	entries := merkleEntries(50, now)
+	changed := append([]MongoEntry(nil), entries...)
+	changed[7].Update([]byte("new"), "", changed[7].Context(), "n2", now.Add(time.Second))
+
+	depth := 4
+	a := BuildMerkleTree(entries, depth)
+	b := BuildMerkleTree(changed, depth)
+	leaf := LeafIndex(changed[7].Key, depth)
+	for level := depth; level >= 0; level-- {
+		for i := range a.Levels[level] {
+			onPath := i == leaf>>(depth-level)
+			if differs := a.Levels[level][i] != b.Levels[level][i]; differs != onPath {
+				t.Errorf("level %d node %d: differs = %v, want %v", level, i, differs, onPath)
+			}
+		}
+	}
+}
+
+func TestLeafIndex(t *testing.T) {
+	for _, depth := range []int{0, 1, 3, 8, 12} {
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			leaf := LeafIndex(key, depth)
+			if leaf < 0 || leaf >= 1<<depth {
+				t.Fatalf("LeafIndex(%q, %d) = %d, out of range", key, depth, leaf)
+			}
+			// La foglia di un albero meno profondo è il prefisso di quella di un albero più profondo
+			if depth > 0 && LeafIndex(key, depth-1) != leaf>>1 {
+				t.Fatalf("LeafIndex(%q, %d) is not a prefix of depth %d", key, depth-1, depth)
+			}
+		}
+	}
+}
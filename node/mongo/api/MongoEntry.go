@@ -3,33 +3,416 @@ package mongo
 import (
 	"JDSys/utils"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 /*
 Identifica un'entry di tipo {chiave,valore}, includendo il timestamp relativo
-alla sua ultima modifica, ed il timestamp relativo al suo ultimo accesso in lettura/scrittura
+alla sua ultima modifica, ed il timestamp relativo al suo ultimo accesso in lettura/scrittura.
+Il vector clock identifica la versione del valore, mentre i siblings mantengono le versioni
+concorrenti scritte da nodi diversi che non è stato possibile ordinare.
+Una versione cancellata è un tombstone, il cui timestamp indica il momento della cancellazione.
+Lo storico mantiene le versioni sostituite da scritture successive, dalla più recente, fino a MaxVersions versioni totali.
+Un'entry con scadenza non viene più restituita dopo l'istante Expires, ed il reaper la sostituisce con un tombstone.
+Il valore è una sequenza arbitraria di byte, il cui content-type opzionale ne indica il formato.
 */
 type MongoEntry struct {
-	Key      string
-	Value    string
-	Timest   time.Time
-	LastAcc  time.Time
-	Conflict bool // rende piu efficiente il merge delle entry
+	Key         string
+	Value       []byte
+	ContentType string
+	Timest      time.Time
+	LastAcc     time.Time
+	Version     VectorClock
+	Deleted     bool
+	Siblings    []Sibling
+	History     []Sibling
+	MaxVersions int // numero di versioni da mantenere per la chiave, 0 per utilizzare utils.MAX_VERSIONS
+	Expires     time.Time
+	Conflict    bool // rende piu efficiente il merge delle entry
+}
+
+/*
+Versione concorrente del valore di un'entry
+*/
+type Sibling struct {
+	Value       []byte
+	ContentType string
+	Timest      time.Time
+	Version     VectorClock
+	Deleted     bool
 }
 
 /*
 Formatta l'entry includendo il relativo timestamp
 */
 func (me *MongoEntry) Format() string {
-	return fmt.Sprintf("{ %s , %s , %s }", me.Key, me.Value, me.Timest.String())
+	if me.Deleted {
+		return fmt.Sprintf("{ %s , <deleted> , %s , [%s] }", me.Key, me.Timest.String(), me.Version.String())
+	}
+	return fmt.Sprintf("{ %s , %s , %s , [%s] }", me.Key, FormatBytes(me.Value, me.ContentType), me.Timest.String(), me.Version.String())
 }
 
 /*
-Formatta l'entry per essere visualizzata dal client.
+Formatta l'entry per essere visualizzata dal client. In presenza di versioni concorrenti vengono mostrati
+tutti i valori, insieme al contesto da utilizzare per risolvere il conflitto con una nuova Put.
 */
 func (me *MongoEntry) FormatClient() string {
-	key := "Key   | " + me.Key
-	value := "Value | " + utils.RemoveBrackets(me.Value)
-	return utils.StringInBoxL2(key, value)
+	versions := me.Versions()
+	lines := []string{"Key     | " + me.Key}
+	if len(versions) == 1 {
+		lines = append(lines, "Value   | "+versions[0].format())
+	} else {
+		for i, version := range versions {
+			lines = append(lines, "Value "+strconv.Itoa(i+1)+" | "+version.format())
+		}
+	}
+	if !me.Expires.IsZero() {
+		lines = append(lines, "Expires | "+me.Expires.UTC().Format(time.RFC3339))
+	}
+	lines = append(lines, "Context | "+me.Context().String())
+	return utils.StringInBoxLines(lines)
+}
+
+/*
+Formatta tutte le versioni dell'entry per essere visualizzate dal client, a partire da quelle attuali
+e proseguendo con lo storico dalla versione più recente. Ogni versione riporta il proprio vector clock,
+che può essere utilizzato come selettore per leggerla.
+*/
+func (me *MongoEntry) FormatHistory() string {
+	lines := []string{"Key | " + me.Key}
+	for _, version := range me.Versions() {
+		lines = append(lines, "Now | "+version.formatVersion())
+	}
+	for i, version := range me.History {
+		lines = append(lines, "-"+strconv.Itoa(i+1)+"  | "+version.formatVersion())
+	}
+	return utils.StringInBoxLines(lines)
+}
+
+/*
+Formatta una singola versione dell'entry per essere visualizzata dal client
+*/
+func (me *MongoEntry) FormatVersion(version *Sibling) string {
+	lines := []string{"Key     | " + me.Key, "Value   | " + version.format(),
+		"Written | " + version.Timest.UTC().Format(time.RFC3339), "Version | " + version.Version.String()}
+	return utils.StringInBoxLines(lines)
+}
+
+/*
+Ritorna tutte le versioni dell'entry, a partire da quella principale
+*/
+func (me *MongoEntry) Versions() []Sibling {
+	versions := []Sibling{{Value: me.Value, ContentType: me.ContentType, Timest: me.Timest, Version: me.Version, Deleted: me.Deleted}}
+	return append(versions, me.Siblings...)
+}
+
+/*
+Imposta le versioni dell'entry. La versione principale è quella scritta più di recente, le altre diventano siblings.
+*/
+func (me *MongoEntry) SetVersions(versions []Sibling) {
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Timest.After(versions[j].Timest) })
+	me.Value = versions[0].Value
+	me.ContentType = versions[0].ContentType
+	me.Timest = versions[0].Timest
+	me.Version = versions[0].Version
+	me.Deleted = versions[0].Deleted
+	me.Siblings = nil
+	if len(versions) > 1 {
+		me.Siblings = versions[1:]
+	}
+}
+
+/*
+Ritorna il contesto dell'entry, cioè il merge dei vector clock di tutte le sue versioni.
+Una scrittura effettuata con questo contesto sostituisce tutte le versioni concorrenti.
+*/
+func (me *MongoEntry) Context() VectorClock {
+	context := make(VectorClock)
+	for _, version := range me.Versions() {
+		context = context.Merge(version.Version)
+	}
+	return context
+}
+
+/*
+Indica se l'entry è stata cancellata, cioè se tutte le sue versioni sono tombstone
+*/
+func (me *MongoEntry) IsDeleted() bool {
+	for _, version := range me.Versions() {
+		if !version.Deleted {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Ritorna la versione dell'entry identificata dal vector clock specificato, cercandola sia tra le versioni
+attuali che nello storico. Ritorna nil se la versione non è più mantenuta.
+*/
+func (me *MongoEntry) VersionOf(clock VectorClock) *Sibling {
+	for _, version := range append(me.Versions(), me.History...) {
+		if version.Version.Equal(clock) {
+			return &version
+		}
+	}
+	return nil
+}
+
+/*
+Ritorna la versione dell'entry valida all'istante specificato, cioè l'ultima scritta non dopo quell'istante.
+Ritorna nil se all'istante specificato la chiave non era ancora stata scritta, o se la versione non è più mantenuta.
+*/
+func (me *MongoEntry) VersionAt(instant time.Time) *Sibling {
+	var selected *Sibling
+	for _, version := range append(me.Versions(), me.History...) {
+		if version.Timest.After(instant) {
+			continue
+		}
+		if selected == nil || version.Timest.After(selected.Timest) {
+			v := version
+			selected = &v
+		}
+	}
+	return selected
+}
+
+/*
+Opzioni di una scrittura, applicate all'entry nello stesso passo in cui viene scritta la nuova versione,
+così che le repliche ricevano valore, scadenza e numero di versioni insieme
+*/
+type WriteOptions struct {
+	MaxVersions int       // numero di versioni da mantenere, 0 per non modificarlo
+	Expires     time.Time // scadenza della chiave, zero per nessuna scadenza
+}
+
+/*
+Applica le opzioni di scrittura all'entry appena aggiornata
+*/
+func (opts WriteOptions) apply(me *MongoEntry) {
+	if opts.MaxVersions > 0 {
+		me.SetMaxVersions(opts.MaxVersions)
+	}
+	me.Expires = opts.Expires
+}
+
+/*
+Imposta il numero di versioni da mantenere per l'entry, rimuovendo dallo storico le versioni in eccesso
+*/
+func (me *MongoEntry) SetMaxVersions(max int) {
+	me.MaxVersions = max
+	me.addHistory(nil)
+}
+
+/*
+Indica se l'entry è scaduta all'istante specificato. Un'entry senza scadenza non scade mai.
+*/
+func (me *MongoEntry) IsExpired(now time.Time) bool {
+	return !me.Expires.IsZero() && !now.Before(me.Expires)
+}
+
+/*
+Indica se l'entry è visibile ai client all'istante specificato, cioè se non è cancellata né scaduta
+*/
+func (me *MongoEntry) IsLive(now time.Time) bool {
+	return !me.IsDeleted() && !me.IsExpired(now)
+}
+
+/*
+Scrive un nuovo valore nell'entry da parte del nodo nodeID. La nuova versione discende dal contesto specificato,
+e sostituisce tutte le versioni che la precedono. Se il contesto è nil la scrittura sostituisce tutte le versioni.
+*/
+func (me *MongoEntry) Update(value []byte, contentType string, context VectorClock, nodeID string, timestamp time.Time) {
+	me.write(Sibling{Value: value, ContentType: contentType, Timest: timestamp}, context, nodeID)
+}
+
+/*
+Cancella l'entry da parte del nodo nodeID, sostituendo le versioni che precedono il contesto con un tombstone.
+Il tombstone viene propagato come una normale versione, così che la chiave resti cancellata su tutte le repliche.
+*/
+func (me *MongoEntry) Tombstone(context VectorClock, nodeID string, timestamp time.Time) {
+	me.write(Sibling{Timest: timestamp, Deleted: true}, context, nodeID)
+}
+
+/*
+Aggiunge all'entry una nuova versione scritta dal nodo nodeID, che discende dal contesto specificato
+*/
+func (me *MongoEntry) write(version Sibling, context VectorClock, nodeID string) {
+	versions := me.Versions()
+	if context == nil {
+		context = me.Context()
+	}
+
+	// Il contatore del nodo deve superare quello di tutte le versioni, così che la nuova
+	// versione resti concorrente a quelle che non discendono dal contesto
+	counter := context[nodeID]
+	for _, old := range versions {
+		if old.Version[nodeID] > counter {
+			counter = old.Version[nodeID]
+		}
+	}
+	clock := context.Copy()
+	clock[nodeID] = counter + 1
+
+	var kept, replaced []Sibling
+	for _, old := range versions {
+		if !clock.Descends(old.Version) {
+			kept = append(kept, old)
+		} else if old.Version != nil || len(old.Value) > 0 {
+			// La versione vuota di un'entry appena creata non viene inserita nello storico
+			replaced = append(replaced, old)
+		}
+	}
+	version.Version = clock
+	kept = append(kept, version)
+	me.SetVersions(kept)
+	me.addHistory(replaced)
+	me.LastAcc = version.Timest
+}
+
+/*
+Aggiunge allo storico le versioni sostituite, mantenendo le versioni più recenti fino al limite della chiave.
+Le versioni già presenti nello storico o tra le versioni attuali non vengono duplicate.
+*/
+func (me *MongoEntry) addHistory(replaced []Sibling) {
+	limit := me.MaxVersions
+	if limit <= 0 {
+		limit = utils.MAX_VERSIONS
+	}
+	current := me.Versions()
+	var history []Sibling
+	for _, version := range append(me.History, replaced...) {
+		if !containsVersion(current, version) && !containsVersion(history, version) {
+			history = append(history, version)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Timest.After(history[j].Timest) })
+	if limit < 1 {
+		limit = 1
+	}
+	if len(history) > limit-1 {
+		history = history[:limit-1]
+	}
+	me.History = history
+}
+
+/*
+Indica se la versione specificata è presente nella lista, confrontando vector clock e timestamp
+*/
+func containsVersion(versions []Sibling, version Sibling) bool {
+	for _, v := range versions {
+		if v.Version.Equal(version.Version) && v.Timest.Equal(version.Timest) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Rimuove le versioni che sono precedute da un'altra versione. Tra versioni con lo stesso vector clock
+si mantiene quella scritta più di recente, così da ricadere su last-write-wins per le entry senza versione.
+*/
+func ResolveVersions(versions []Sibling) []Sibling {
+	var resolved []Sibling
+	for i, v := range versions {
+		dominated := false
+		for j, w := range versions {
+			if i == j {
+				continue
+			}
+			if w.Version.Descends(v.Version) && !v.Version.Descends(w.Version) {
+				dominated = true
+				break
+			}
+			if w.Version.Equal(v.Version) && (w.Timest.After(v.Timest) || (w.Timest.Equal(v.Timest) && j < i)) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			resolved = append(resolved, v)
+		}
+	}
+	return resolved
+}
+
+/*
+Unisce due copie della stessa entry, mantenendo tutte le versioni concorrenti. Le versioni sostituite
+e gli storici di entrambe le copie confluiscono nello storico dell'entry risultante.
+*/
+func MergeVersions(local MongoEntry, update MongoEntry) MongoEntry {
+	merged := local
+	versions := append(local.Versions(), update.Versions()...)
+	merged.SetVersions(ResolveVersions(versions))
+	if update.MaxVersions > merged.MaxVersions {
+		merged.MaxVersions = update.MaxVersions
+	}
+	// La scadenza è quella impostata dall'ultima scrittura
+	if update.Timest.After(local.Timest) {
+		merged.Expires = update.Expires
+	}
+	merged.addHistory(append(append(update.History, local.Versions()...), update.Versions()...))
+	if update.LastAcc.After(local.LastAcc) {
+		merged.LastAcc = update.LastAcc
+	}
+	merged.Conflict = false
+	return merged
+}
+
+/*
+Formatta una versione per lo storico, includendo il timestamp di scrittura ed il suo vector clock
+*/
+func (s *Sibling) formatVersion() string {
+	return s.format() + " | " + s.Timest.UTC().Format(time.RFC3339) + " | " + s.Version.String()
+}
+
+/*
+Formatta una versione per essere visualizzata dal client
+*/
+func (s *Sibling) format() string {
+	if s.Deleted {
+		return "<deleted>"
+	}
+	return FormatBytes(s.Value, s.ContentType)
+}
+
+/*
+Indica se il content-type specificato identifica un valore testuale. Un valore senza content-type è testuale.
+*/
+func IsText(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "text/")
+}
+
+/*
+Formatta un valore per essere visualizzato. I valori testuali vengono mostrati direttamente,
+mentre per i valori binari si mostra solamente la loro dimensione ed il content-type.
+Di un valore suddiviso in chunk si mostrano i dati del manifest, senza ricostruirlo.
+*/
+func FormatBytes(value []byte, contentType string) string {
+	if manifest := DecodeManifest(value, contentType); manifest != nil {
+		return manifest.format()
+	}
+	if IsText(contentType) && utf8.Valid(value) && !strings.ContainsAny(string(value), "\n\r") {
+		return string(value)
+	}
+	if contentType == "" {
+		contentType = "binary"
+	}
+	return "<" + strconv.Itoa(len(value)) + " bytes, " + contentType + ">"
+}
+
+/*
+Aggiunge un valore in coda a quello attuale. I valori testuali sono liste separate da virgola,
+mentre ai valori binari vengono concatenati direttamente i byte ricevuti.
+*/
+func AppendBytes(value []byte, contentType string, arg []byte) []byte {
+	appended := append([]byte(nil), value...)
+	if IsText(contentType) && len(appended) > 0 {
+		appended = append(appended, ',')
+	}
+	return append(appended, arg...)
 }
@@ -5,18 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
-	"JDSys/node/mongo/communication"
 	"JDSys/utils"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/beevik/ntp"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -29,6 +24,14 @@ var ID string = "_id"
 var VALUE string = "value"
 var TIME string = "timest"
 var LAST_ACC string = "lastAcc"
+var VERSION string = "version"
+var SIBLINGS string = "siblings"
+var DELETED string = "deleted"
+var HISTORY string = "history"
+var MAX_VERSIONS string = "maxVersions"
+var EXPIRES string = "expires"
+var CONTENT_TYPE string = "contentType"
+var FIELDS string = "--fields=_id,value,timest,lastAcc,version,siblings,deleted,history,maxVersions,expires,contentType"
 
 /*
 Struttura che mantiene una connessione verso una specifica collezione MongoDB
@@ -37,16 +40,18 @@ type MongoInstance struct {
 	Client     *mongo.Client
 	Database   *mongo.Database
 	Collection *mongo.Collection
-	CloudKeys  []string
+	Cloud      CloudTier
+	NodeID     string
 }
 
 /*
 Inizializza il sistema di storage locale aprendo la connessione a MongoDB e rimuovendo eventuali
 entry residue nel sistema.
 */
-func InitLocalSystem() MongoInstance {
+func InitMongoSystem(nodeID string) *MongoInstance {
 	utils.PrintTs("Starting Mongo Local System")
-	client := MongoInstance{}
+	client := new(MongoInstance)
+	client.NodeID = nodeID
 	client.OpenConnection()
 
 	// Inizializza un database vuoto, per eliminare eventuale documenti residui del nodo.
@@ -81,357 +86,431 @@ Ritorna una entry specificando la sua chiave. Se l'entry è presente nel cloud s
 */
 func (cli *MongoInstance) GetEntry(key string) *MongoEntry {
 	utils.PrintHeaderL3("Mongo Get, Searching for: " + key)
-	if utils.StringInSlice(key, cli.CloudKeys) {
-		utils.PrintTs("Entry on Cloud System. Downloading...\n")
-		cli.downloadEntryFromS3(key)
-		cli.MergeCollection(utils.CLOUD_EXPORT_FILE, utils.CLOUD_RECEIVE_PATH+key+utils.CSV)
-		cli.CloudKeys = utils.RemoveElement(cli.CloudKeys, key)
-		cli.deleteEntryFromS3(key)
-		utils.ClearDir(utils.CLOUD_EXPORT_PATH)
-		utils.ClearDir(utils.CLOUD_RECEIVE_PATH)
-	}
+	cli.Cloud.Restore(cli, key)
 
 	coll := cli.Collection
 	var result bson.M
 	err := coll.FindOne(context.TODO(), bson.D{primitive.E{Key: ID, Value: key}}).Decode(&result)
-	entry := MongoEntry{}
 
 	if err != nil {
 		utils.PrintTs("Get Error: " + err.Error())
 		return nil
 	}
-	id := result[ID].(string)
-	value := result[VALUE].(string)
-	timest := result[TIME].(primitive.DateTime)
-	lastaccess, _ := ntp.Time("0.beevik-ntp.pool.ntp.org")
-	entry.Key = id
-	entry.Value = value
-	entry.Timest = timest.Time()
+	entry := decodeEntry(result)
+	if entry.IsDeleted() {
+		utils.PrintTs("Get Error: entry with key " + key + " has been deleted")
+		return nil
+	}
+	lastaccess := getTimestamp()
+	if entry.IsExpired(lastaccess) {
+		utils.PrintTs("Get Error: entry with key " + key + " has expired")
+		return nil
+	}
 	entry.LastAcc = lastaccess
 
 	update := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: LAST_ACC, Value: lastaccess}}}}
-	cli.Collection.UpdateOne(context.TODO(), entry, update)
+	cli.Collection.UpdateOne(context.TODO(), bson.D{primitive.E{Key: ID, Value: key}}, update)
 	utils.PrintTs("Found: " + entry.Format())
 	return &entry
 }
 
+/*
+Ritorna una entry con tutte le sue versioni, comprese quelle dello storico ed un eventuale tombstone.
+Se l'entry è presente nel cloud storage, viene migrata in locale prima di ritornarla.
+*/
+func (cli *MongoInstance) GetVersions(key string) *MongoEntry {
+	utils.PrintHeaderL3("Mongo Versions, Searching for: " + key)
+	cli.Cloud.Restore(cli, key)
+	return cli.ReadEntry(key)
+}
+
 /*
 Inserisce un'entry, specificando la chiave ed il suo valore. Se l'entry è già presente nello storage locale
-questa viene aggiornata inserendo il nuovo valore specificato. Se la chiave è presente sullo storage cloud, questa viene
-prima migrata in locale, e poi aggiornata eseguendo l'update.
+questa viene aggiornata inserendo una nuova versione che discende dal contesto clock. Se la chiave è presente
+sullo storage cloud, questa viene prima migrata in locale, e poi aggiornata eseguendo l'update.
 */
-func (cli *MongoInstance) PutEntry(key string, value string) error {
-	entry := fmt.Sprintf("{ %s , %s }", key, value)
+func (cli *MongoInstance) PutEntry(key string, value []byte, contentType string, clock VectorClock, opts WriteOptions) error {
+	entry := fmt.Sprintf("{ %s , %s }", key, FormatBytes(value, contentType))
 	utils.PrintHeaderL3("Mongo Put, inserting " + entry)
 
-	if utils.StringInSlice(key, cli.CloudKeys) {
-		utils.PrintTs("Entry on Cloud System. Downloading...\n")
-		cli.downloadEntryFromS3(key)
-		cli.MergeCollection(utils.CLOUD_EXPORT_FILE, utils.CLOUD_RECEIVE_PATH+key+utils.CSV)
-		cli.CloudKeys = utils.RemoveElement(cli.CloudKeys, key)
-		cli.deleteEntryFromS3(key)
-		utils.ClearDir(utils.CLOUD_EXPORT_PATH)
-		utils.ClearDir(utils.CLOUD_RECEIVE_PATH)
-	}
+	cli.Cloud.Restore(cli, key)
 
-	coll := cli.Collection
-	timestamp, _ := ntp.Time("0.beevik-ntp.pool.ntp.org")
-	strVal := utils.FormatValue(value)
-	doc := bson.D{primitive.E{Key: ID, Value: key}, primitive.E{Key: VALUE, Value: strVal},
-		primitive.E{Key: TIME, Value: timestamp}, primitive.E{Key: LAST_ACC, Value: timestamp}}
-	_, err := coll.InsertOne(context.TODO(), doc)
-	if err != nil {
-		if strings.Contains(err.Error(), "E11000") {
-			utils.PrintTs("An entry for key " + key + " is already present on local storage")
-			utils.PrintTs("Updating Entry Value")
-			old := bson.D{primitive.E{Key: ID, Value: key}}
-			timestamp, _ := ntp.Time("0.beevik-ntp.pool.ntp.org")
-			update := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: VALUE, Value: strVal},
-				primitive.E{Key: TIME, Value: timestamp}, primitive.E{Key: LAST_ACC, Value: timestamp}}}}
-			_, err := cli.Collection.UpdateOne(context.TODO(), old, update)
-			if err != nil {
-				utils.PrintTs(err.Error())
-				return err
-			}
-			utils.PrintTs("Update: Entry for key " + key + ", updated into " + entry)
-			return errors.New("Updated")
-
-		} else {
-			utils.PrintTs("Put Error: " + err.Error())
+	var live bool
+	err := cli.modifyEntry(key, func(stored *MongoEntry) (*MongoEntry, error) {
+		timestamp := getTimestamp()
+		if stored == nil {
+			stored = &MongoEntry{Key: key}
 		}
+		// Una chiave cancellata o scaduta viene reinserita, la nuova versione discende comunque dal suo tombstone
+		live = stored.IsLive(timestamp)
+		stored.Update(value, contentType, clock, cli.NodeID, timestamp)
+		opts.apply(stored)
+		return stored, nil
+	})
+	if err != nil {
+		utils.PrintTs("Put Error: " + err.Error())
 		return err
 	}
+	if live {
+		utils.PrintTs("Update: Entry for key " + key + ", updated into " + entry)
+		return errors.New("Updated")
+	}
 	utils.PrintTs("Entry " + entry + " succesfully inserted into local storage")
 	return nil
 }
 
+/*
+Inserisce un'entry solamente se la precondizione è verificata sull'entry memorizzata. La sostituzione avviene solo
+se il documento non è cambiato dalla lettura, altrimenti si rilegge l'entry e si verifica di nuovo la precondizione.
+Se la precondizione non è verificata ritorna l'errore "PreconditionFailed".
+*/
+func (cli *MongoInstance) ConditionalPut(key string, value []byte, contentType string, cond Condition, opts WriteOptions) error {
+	entry := fmt.Sprintf("{ %s , %s }", key, FormatBytes(value, contentType))
+	utils.PrintHeaderL3("Mongo Conditional Put (" + cond.Mode + "), inserting " + entry)
+
+	cli.Cloud.Restore(cli, key)
+
+	err := cli.modifyEntry(key, func(stored *MongoEntry) (*MongoEntry, error) {
+		timestamp := getTimestamp()
+		if !cond.Check(stored, timestamp) {
+			return nil, errors.New("PreconditionFailed")
+		}
+		if stored == nil {
+			stored = &MongoEntry{Key: key}
+		}
+		stored.Update(value, contentType, nil, cli.NodeID, timestamp)
+		opts.apply(stored)
+		return stored, nil
+	})
+	if err != nil && err.Error() == "PreconditionFailed" {
+		utils.PrintTs("Precondition failed for key " + key)
+		return err
+	}
+	if err != nil {
+		utils.PrintTs("Conditional Put Error: " + err.Error())
+		return err
+	}
+	utils.PrintTs("Entry " + entry + " conditionally written into local storage")
+	return nil
+}
+
 /*
 Aggiorna un'entry del database, specificando la chiave ed il nuovo valore da aggiungere.
-Viene inoltre aggiornato il timestamp di quell'entry. Se l'entry è presente sul cloud, viene migrata nello
-storage locale ed aggiornata eseguendo l'append
+Viene inoltre aggiornato il timestamp di quell'entry, e la nuova versione discende da quella principale.
+Se l'entry è presente sul cloud, viene migrata nello storage locale ed aggiornata eseguendo l'append.
+Ritorna l'errore "ChunkedValue" se il valore è suddiviso in chunk, su cui l'append non è supportato.
 */
-func (cli *MongoInstance) AppendValue(key string, arg1 string) error {
-	utils.PrintHeaderL3("Mongo Append, adding argument " + arg1 + " to key " + key)
-
-	if utils.StringInSlice(key, cli.CloudKeys) {
-		utils.PrintTs("Entry on Cloud System. Downloading...\n")
-		cli.downloadEntryFromS3(key)
-		cli.MergeCollection(utils.CLOUD_EXPORT_FILE, utils.CLOUD_RECEIVE_PATH+key+utils.CSV)
-		cli.CloudKeys = utils.RemoveElement(cli.CloudKeys, key)
-		cli.deleteEntryFromS3(key)
-		utils.ClearDir(utils.CLOUD_EXPORT_PATH)
-		utils.ClearDir(utils.CLOUD_RECEIVE_PATH)
-	}
-
-	old := bson.D{primitive.E{Key: ID, Value: key}}
-	oldEntry := cli.GetEntry(key)
-	if oldEntry == nil {
-		utils.PrintTs("Append Error: No entry found with key " + key)
-		return errors.New("NoKeyFound")
-	}
-	append := utils.AppendValue(oldEntry.Value, arg1)
-	timestamp, _ := ntp.Time("0.beevik-ntp.pool.ntp.org")
-	update := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: VALUE, Value: append},
-		primitive.E{Key: TIME, Value: timestamp}, primitive.E{Key: LAST_ACC, Value: timestamp}}}}
-	_, err := cli.Collection.UpdateOne(context.TODO(), old, update)
+func (cli *MongoInstance) AppendValue(key string, arg1 []byte, opts WriteOptions) error {
+	utils.PrintHeaderL3("Mongo Append, adding " + strconv.Itoa(len(arg1)) + " bytes to key " + key)
+
+	cli.Cloud.Restore(cli, key)
+
+	var contentType string
+	err := cli.modifyEntry(key, func(stored *MongoEntry) (*MongoEntry, error) {
+		timestamp := getTimestamp()
+		if stored == nil || !stored.IsLive(timestamp) {
+			return nil, errors.New("NoKeyFound")
+		}
+		if stored.ContentType == MANIFEST_TYPE {
+			return nil, errors.New("ChunkedValue")
+		}
+		contentType = stored.ContentType
+		expiry := opts
+		if expiry.Expires.IsZero() {
+			expiry.Expires = stored.Expires
+		}
+		stored.Update(AppendBytes(stored.Value, stored.ContentType, arg1), stored.ContentType, stored.Version, cli.NodeID, timestamp)
+		expiry.apply(stored)
+		return stored, nil
+	})
 	if err != nil {
-		utils.PrintTs(err.Error())
+		utils.PrintTs("Append Error: " + err.Error() + " for key " + key)
 		return err
 	}
-	utils.PrintTs("Append: inserted " + arg1 + " to key " + key)
+	utils.PrintTs("Append: inserted " + FormatBytes(arg1, contentType) + " to key " + key)
 	return nil
 }
 
 /*
-Cancella un'entry dal database, specificando la sua chiave. Se l'entry è presente sul cloud, questa viene eliminata
-invece dal bucket S3
+Cancella un'entry dal database, specificando la sua chiave. L'entry viene sostituita da un tombstone che ne
+impedisce la reintroduzione durante merge e riconciliazione. Se l'entry è presente sul cloud, viene prima
+migrata in locale e rimossa dal bucket S3
 */
 func (cli *MongoInstance) DeleteEntry(key string) error {
 	utils.PrintHeaderL3("Mongo Delete, removing entry with key " + key)
 
-	if utils.StringInSlice(key, cli.CloudKeys) {
-		utils.PrintTs("Entry on Cloud System. Deleting from S3...\n")
-		cli.CloudKeys = utils.RemoveElement(cli.CloudKeys, key)
-		cli.deleteEntryFromS3(key)
+	cli.Cloud.Restore(cli, key)
+
+	err := cli.modifyEntry(key, func(stored *MongoEntry) (*MongoEntry, error) {
+		timestamp := getTimestamp()
+		if stored == nil || !stored.IsLive(timestamp) {
+			return nil, errors.New("EntryNotFound")
+		}
+		stored.Tombstone(stored.Context(), cli.NodeID, timestamp)
+		return stored, nil
+	})
+	if err != nil {
+		utils.PrintTs("Delete Error: " + err.Error() + " for key " + key)
+		return err
 	}
+	utils.PrintTs("Deleted " + key)
+	return nil
+}
 
-	coll := cli.Collection
+/*
+Rimuove fisicamente un'entry dal database, senza lasciare alcun tombstone
+*/
+func (cli *MongoInstance) PurgeEntry(key string) error {
 	entry := bson.D{primitive.E{Key: ID, Value: key}}
-	result, err := coll.DeleteOne(context.TODO(), entry)
+	result, err := cli.Collection.DeleteOne(context.TODO(), entry)
 	if err != nil {
-		utils.PrintTs("Delete Error: " + err.Error())
+		utils.PrintTs("Purge Error: " + err.Error())
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return errors.New("EntryNotFound")
+	}
+	utils.PrintTs("Purged " + key)
+	return nil
+}
 
-	if result.DeletedCount == 1 {
-		utils.PrintTs("Deleted " + key)
+/*
+Rimuove fisicamente un tombstone, solamente se tutte le versioni della chiave sono ancora cancellate e la cancellazione
+non è successiva ad olderThan. La rimozione è filtrata sulla versione letta, così che una chiave appena reinserita
+non venga rimossa. Ritorna l'errore "NotExpired" se la chiave non è più un tombstone da rimuovere.
+*/
+func (cli *MongoInstance) PurgeTombstone(key string, olderThan time.Time) error {
+	err := cli.purgeIf(key, func(stored MongoEntry) error {
+		if !stored.IsDeleted() || stored.Timest.After(olderThan) {
+			return errors.New("NotExpired")
+		}
 		return nil
+	})
+	if err != nil {
+		utils.PrintTs("Purge Error: " + err.Error() + " for key " + key)
+		return err
 	}
-	utils.PrintTs("Delete Error: No entry found with key " + key)
-	return errors.New("EntryNotFound")
+	utils.PrintTs("Purged tombstone of " + key)
+	return nil
 }
 
 /*
-Inserisce un oggetto MongoEntry nel db.
-Utilizzata durante l'aggiornamento delle entry del DB locale.
+Rimuove fisicamente un'entry, solamente se il suo contesto è ancora quello specificato. La rimozione è filtrata
+sulla versione letta, così che una scrittura arrivata dopo la lettura dell'entry non venga rimossa.
+Ritorna l'errore "EntryChanged" se l'entry è stata modificata.
 */
-func (cli *MongoInstance) PutMongoEntry(entry MongoEntry) {
-	coll := cli.Collection
-	key := entry.Key
-	value := entry.Value
-	timestamp := entry.Timest
-	lastaccess := entry.LastAcc
-
-	doc := bson.D{primitive.E{Key: ID, Value: key}, primitive.E{Key: VALUE, Value: value},
-		primitive.E{Key: TIME, Value: timestamp}, primitive.E{Key: LAST_ACC, Value: lastaccess}}
-	_, err := coll.InsertOne(context.TODO(), doc)
+func (cli *MongoInstance) PurgeVersion(key string, context VectorClock) error {
+	err := cli.purgeIf(key, func(stored MongoEntry) error {
+		if !stored.Context().Equal(context) {
+			return errors.New("EntryChanged")
+		}
+		return nil
+	})
 	if err != nil {
-		utils.PrintTs("PutMongoEntry Error: " + err.Error())
-		return
+		utils.PrintTs("Purge Error: " + err.Error() + " for key " + key)
+		return err
 	}
+	utils.PrintTs("Purged " + key)
+	return nil
 }
 
 /*
-Esporta una collezione, scrivendola su un file csv
+Sostituisce un'entry scaduta con un tombstone datato alla sua scadenza, così che tutte le repliche
+che la fanno scadere producano la stessa cancellazione. La scadenza viene verificata di nuovo se l'entry
+cambia prima della sostituzione, così che una scrittura che rimuove o estende il time-to-live non vada persa.
 */
-func (cli *MongoInstance) ExportCollection(filename string) error {
-	app := "mongoexport"
-	arg1 := "--collection=" + COLL_NAME
-	arg2 := "--db=" + DB_NAME
-	arg3 := "--type=csv"
-	arg4 := "--fields=_id,value,timest,lastAcc"
-	arg5 := "--out=" + filename
-
-	cmd := exec.Command(app, arg1, arg2, arg3, arg4, arg5)
-	_, err := cmd.Output()
+func (cli *MongoInstance) ExpireEntry(key string) error {
+	err := cli.modifyEntry(key, func(stored *MongoEntry) (*MongoEntry, error) {
+		if stored == nil || stored.IsDeleted() {
+			return nil, errors.New("EntryNotFound")
+		}
+		if !stored.IsExpired(getTimestamp()) {
+			return nil, errors.New("NotExpired")
+		}
+		stored.Tombstone(stored.Context(), cli.NodeID, stored.Expires)
+		return stored, nil
+	})
 	if err != nil {
-		utils.PrintTs(err.Error())
+		if err.Error() != "NotExpired" {
+			utils.PrintTs("Expire Error: " + err.Error())
+		}
 		return err
 	}
-	utils.PrintTs("Mongo: Collection exported successfully: " + filename)
+	utils.PrintTs("Expired " + key)
 	return nil
 }
 
 /*
-Esporta una entry specifica in formato CSV.
+Unisce un'entry ricevuta da un'altra replica con quella locale, confrontandone i vector clock.
+La sostituzione avviene solo se il documento non è cambiato dalla lettura, altrimenti si ripete il merge.
 */
-func (cli *MongoInstance) ExportDocument(key string, filename string) error {
-	app := "mongoexport"
-	arg1 := "--collection=" + COLL_NAME
-	arg2 := "--db=" + DB_NAME
-	arg3 := "--type=csv"
-	arg4 := "--fields=_id,value,timest,lastAcc"
-	arg5 := "--query={_id : '" + key + "'}"
-	arg6 := "--out=" + filename
+func (cli *MongoInstance) MergeEntry(entry MongoEntry) error {
+	cli.Cloud.Restore(cli, entry.Key)
 
-	cmd := exec.Command(app, arg1, arg2, arg3, arg4, arg5, arg6)
-	_, err := cmd.Output()
+	err := cli.modifyEntry(entry.Key, func(stored *MongoEntry) (*MongoEntry, error) {
+		merged := entry
+		if stored != nil {
+			merged = MergeVersions(*stored, entry)
+		}
+		merged.Conflict = false
+		return &merged, nil
+	})
 	if err != nil {
-		utils.PrintTs(err.Error())
+		utils.PrintTs("Merge Error: " + err.Error())
 		return err
 	}
-	utils.PrintTs("Mongo: Document exported successfully: " + filename)
+	utils.PrintTs("Merged replica of " + entry.Key)
 	return nil
 }
 
 /*
-Carica una chiave sul bucket s3, rimuovendola dal database locale
+Esegue una lettura-modifica-scrittura dell'entry con la chiave specificata. La funzione modify riceve l'entry
+memorizzata, oppure nil se assente, e ritorna quella da scrivere o un errore che interrompe l'operazione.
+La scrittura avviene solo se il documento non è cambiato dalla lettura, altrimenti si rilegge l'entry e si
+invoca di nuovo modify, così che nessuna scrittura concorrente venga sovrascritta.
 */
-func (cli *MongoInstance) uploadToS3(key string) {
-	utils.PrintHeaderL3("Uploading Entry to S3")
-	filename := key + ".csv"
-
-	keys := cli.getEntryListFromS3()
-
-	if utils.StringInSlice(key, keys) {
-		utils.PrintTs("Entry on Cloud System. Checking most recent...\n")
-		cli.getLatestEntryCSV(key)
+func (cli *MongoInstance) modifyEntry(key string, modify func(stored *MongoEntry) (*MongoEntry, error)) error {
+retry:
+	stored := cli.ReadEntry(key)
+	if stored == nil {
+		updated, err := modify(nil)
+		if err != nil {
+			return err
+		}
+		_, err = cli.Collection.InsertOne(context.TODO(), encodeEntry(*updated))
+		if err != nil && strings.Contains(err.Error(), "E11000") {
+			goto retry
+		}
+		return err
 	}
 
-	utils.PrintTs("Exporting csv " + filename)
-	cli.ExportDocument(key, utils.CLOUD_EXPORT_PATH+filename)
-	sess := communication.CreateSession()
-	uploader := s3manager.NewUploader(sess)
-
-	f, err := os.Open(utils.CLOUD_EXPORT_PATH + filename)
+	filter := versionFilter(*stored)
+	updated, err := modify(stored)
 	if err != nil {
-		utils.PrintTs("Open Error: " + err.Error())
-		return
+		return err
 	}
-
-	// Carica il file su S3
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(utils.BUCKET_NAME),
-		Key:    aws.String(filename),
-		Body:   f,
-	})
+	result, err := cli.Collection.ReplaceOne(context.TODO(), filter, encodeEntry(*updated))
 	if err != nil {
-		utils.PrintTs(err.Error())
-		return
+		return err
 	}
-	utils.PrintTs("Entry succesfully uploaded to cloud storage")
-
-	// Caricato il file da s3 lo rimuovo in locale, e salvo il fatto che è presente sul cloud
-	utils.PrintTs("Removing entry from local storage")
-	cli.CloudKeys = append(cli.CloudKeys, key)
-	cli.DeleteEntry(key)
-	utils.PrintTs("Migration to S3 completed")
-
+	if result.MatchedCount == 0 {
+		utils.PrintTs("Entry " + key + " changed during the update, reading it again")
+		goto retry
+	}
+	return nil
 }
 
 /*
-Ottiene la chiave specificata dal bucket S3, salvandola in un file locale
+Rimuove fisicamente l'entry con la chiave specificata se la funzione check, invocata sull'entry memorizzata,
+non ritorna errore. La rimozione avviene solo se il documento non è cambiato dalla lettura, altrimenti
+si rilegge l'entry e la si verifica di nuovo.
 */
-func (cli *MongoInstance) downloadEntryFromS3(key string) {
-	utils.PrintHeaderL3("Downlaoding Entry from S3")
-	sess := communication.CreateSession()
-	filename := key + utils.CSV
-	downloader := s3manager.NewDownloader(sess)
-
-	// Crea il file in cui verrà scritto l'oggetto scaricato da S3
-	f, err := os.Create(utils.CLOUD_RECEIVE_PATH + filename)
+func (cli *MongoInstance) purgeIf(key string, check func(stored MongoEntry) error) error {
+retry:
+	stored := cli.ReadEntry(key)
+	if stored == nil {
+		return errors.New("EntryNotFound")
+	}
+	err := check(*stored)
 	if err != nil {
-		utils.PrintTs(fmt.Sprintf("failed to create file %q, %v", filename, err))
-		return
+		return err
 	}
-
-	// Scrive il contenuto dell'oggetto S3 sul file
-	_, err = downloader.Download(f, &s3.GetObjectInput{
-		Bucket: aws.String(utils.BUCKET_NAME),
-		Key:    aws.String(filename),
-	})
+	result, err := cli.Collection.DeleteOne(context.TODO(), versionFilter(*stored))
 	if err != nil {
-		utils.PrintTs(fmt.Sprintf("failed to download file, %v", err))
-		return
+		return err
 	}
-	utils.PrintTs("Entry succesfully retrieved form cloud storage")
+	if result.DeletedCount == 0 {
+		utils.PrintTs("Entry " + key + " changed during the purge, reading it again")
+		goto retry
+	}
+	return nil
 }
 
 /*
-Scarica l'entry richiesta da S3, la confronta con quella locale e mantiene l'export della chiave più recente tra le due
-In questo modo l'upload sul cloud avrà sempre l'entry più aggiornata e riconciliata.
+Ritorna il filtro che seleziona il documento dell'entry solamente se non è cambiato dalla sua lettura.
+L'ultimo accesso non viene confrontato, così che le letture non interrompano le scritture.
 */
-func (cli *MongoInstance) getLatestEntryCSV(key string) {
-	cli.downloadEntryFromS3(key)
-	remote, _ := ParseCSV(utils.CLOUD_RECEIVE_PATH + key + utils.CSV)
-
-	cli.ExportDocument(key, utils.CLOUD_EXPORT_PATH+key+utils.CSV)
-	local, _ := ParseCSV(utils.CLOUD_EXPORT_PATH + key + utils.CSV)
-
-	merged := MergeEntries(local, remote)
-
-	cli.PutMongoEntry(merged[0])
-	cli.ExportDocument(key, utils.CLOUD_EXPORT_PATH+key+utils.CSV)
+func versionFilter(entry MongoEntry) bson.D {
+	return bson.D{primitive.E{Key: ID, Value: entry.Key}, primitive.E{Key: VERSION, Value: entry.Version.String()},
+		primitive.E{Key: TIME, Value: entry.Timest}, primitive.E{Key: SIBLINGS, Value: EncodeSiblings(entry.Siblings)},
+		primitive.E{Key: MAX_VERSIONS, Value: entry.MaxVersions}, primitive.E{Key: EXPIRES, Value: FormatExpiry(entry.Expires)}}
+}
 
-	utils.PrintTs("Latest CSV created succesfully for key " + key)
+/*
+Unisce un gruppo di entry ricevute con quelle locali, una alla volta, ritornando il primo errore
+*/
+func (cli *MongoInstance) MergeBatch(entries []MongoEntry) error {
+	var first error
+	for _, entry := range entries {
+		err := cli.MergeEntry(entry)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
 /*
-Elimina l'entry specificata dal Bucket S3.
+Inserisce un oggetto MongoEntry nel db.
+Utilizzata durante l'aggiornamento delle entry del DB locale.
 */
-func (cli *MongoInstance) deleteEntryFromS3(key string) error {
-	utils.PrintHeaderL3("Downlaoding Entry from S3")
-	sess := communication.CreateSession()
-	svc := s3.New(sess)
-	filename := key + utils.CSV
-
-	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(utils.BUCKET_NAME),
-		Key:    aws.String(filename),
-	})
+func (cli *MongoInstance) PutMongoEntry(entry MongoEntry) {
+	coll := cli.Collection
+	_, err := coll.InsertOne(context.TODO(), encodeEntry(entry))
 	if err != nil {
-		return err
+		utils.PrintTs("PutMongoEntry Error: " + err.Error())
+		return
 	}
+}
 
-	err = svc.WaitUntilObjectNotExists(&s3.HeadObjectInput{
-		Bucket: aws.String(utils.BUCKET_NAME),
-		Key:    aws.String(filename),
-	})
+/*
+Esporta una collezione, scrivendola su un file csv
+*/
+func (cli *MongoInstance) ExportCollection(filename string) error {
+	app := "mongoexport"
+	arg1 := "--collection=" + COLL_NAME
+	arg2 := "--db=" + DB_NAME
+	arg3 := "--type=csv"
+	arg4 := FIELDS
+	arg5 := "--out=" + filename
+
+	cmd := exec.Command(app, arg1, arg2, arg3, arg4, arg5)
+	_, err := cmd.Output()
 	if err != nil {
+		utils.PrintTs(err.Error())
 		return err
 	}
-
+	utils.PrintTs("Mongo: Collection exported successfully: " + filename)
 	return nil
 }
 
 /*
-Permette al client di ottenere una lista di tutte le entry presenti sul cloud storage
+Esporta una entry specifica in formato CSV.
 */
-func (cli *MongoInstance) getEntryListFromS3() []string {
-	var keys []string
-
-	sess := communication.CreateSession()
-	svc := s3.New(sess)
+func (cli *MongoInstance) ExportDocument(key string, filename string) error {
+	app := "mongoexport"
+	arg1 := "--collection=" + COLL_NAME
+	arg2 := "--db=" + DB_NAME
+	arg3 := "--type=csv"
+	arg4 := FIELDS
+	arg5 := "--query={_id : '" + key + "'}"
+	arg6 := "--out=" + filename
 
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String(utils.BUCKET_NAME),
-		Prefix: aws.String(""),
-	}
-	resp, _ := svc.ListObjects(params)
-	for _, k := range resp.Contents {
-		keys = append(keys, k.String())
+	cmd := exec.Command(app, arg1, arg2, arg3, arg4, arg5, arg6)
+	_, err := cmd.Output()
+	if err != nil {
+		utils.PrintTs(err.Error())
+		return err
 	}
-	return keys
+	utils.PrintTs("Mongo: Document exported successfully: " + filename)
+	return nil
+}
+
+/*
+Ritorna le chiavi migrate sul cloud storage
+*/
+func (cli *MongoInstance) ListCloudKeys() []string {
+	return append([]string(nil), cli.Cloud.Keys...)
 }
 
 /*
@@ -439,73 +518,47 @@ Routine che ogni ora controlla tutte le entry per vedere se è possibile
 effettuare una migrazione delle risorse verso il cloud S3
 */
 func (cli *MongoInstance) CheckRarelyAccessed() {
-	for {
-		time.Sleep(utils.RARELY_ACCESSED_CHECK_INTERVAL)
-		opts := options.Find().SetSort(bson.D{primitive.E{Key: ID, Value: 1}})
-		cursor, _ := cli.Collection.Find(context.TODO(), bson.D{}, opts)
-		var results []bson.M
-
-		if err := cursor.All(context.TODO(), &results); err != nil {
-			log.Fatal(err)
-		}
-		utils.PrintHeaderL2("Check Rarely Acccessed Entries")
-		for _, result := range results {
-			key := result[ID].(string)
-			entry := cli.ReadEntry(key)
-			if entry != nil {
-				timeNow, _ := ntp.Time("0.beevik-ntp.pool.ntp.org")
-				diff := timeNow.Sub(entry.LastAcc)
-				utils.PrintTs("Key " + key + " non-accessed since " + diff.String())
-				if diff >= utils.RARELY_ACCESSED_TIME {
-					utils.PrintTs("Entry not accessed for a long time. Migrating on Cloud")
-					cli.uploadToS3(entry.Key)
-				}
-			}
-		}
-	}
+	cli.Cloud.CheckRarelyAccessed(cli)
 }
 
 /*
-Invocata dalla goroutine ListenUpdates quando un nodo sta inviando le informazioni nel proprio DB
-Effettua l'export del DB locale, si unisce il CSV con quello ricevuto e si aggiorna il DB.
+Ritorna tutte le entry della collezione ordinate per chiave, senza aggiornarne l'ultimo accesso
 */
-func (cli *MongoInstance) MergeCollection(exportFile string, receivedFile string) {
-	utils.PrintHeaderL3("Merging mongo local storage")
-	cli.ExportCollection(exportFile)
-	localExport, local_err := ParseCSV(exportFile)
-	receivedUpdate, recvd_err := ParseCSV(receivedFile)
-	if local_err != nil || recvd_err != nil {
-		return
+func (cli *MongoInstance) ListEntries() []MongoEntry {
+	var entries []MongoEntry
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: ID, Value: 1}})
+	cursor, err := cli.Collection.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		utils.PrintTs("List Error: " + err.Error())
+		return entries
 	}
-	mergedEntries := MergeEntries(localExport, receivedUpdate)
-	cli.Collection.Drop(context.TODO())
-	for _, entry := range mergedEntries {
-		cli.PutMongoEntry(entry)
+	var results []bson.M
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		utils.PrintTs("List Error: " + err.Error())
+		return entries
 	}
-	cli.Collection.Find(context.TODO(), nil)
-	utils.PrintTs("Collection merged succesfully")
+	for _, result := range results {
+		entries = append(entries, decodeEntry(result))
+	}
+	return entries
 }
 
 /*
-Invocato quando si riceve un update di riconciliazione. Si utilizza
-last-write-wins per risolvere i conflitti tra le entry
+Invocata quando un nodo sta inviando le informazioni nel proprio DB. Ogni entry del CSV ricevuto viene unita
+con quella locale tramite MergeEntry, senza ricreare la collezione, così che le scritture concorrenti non vadano perse.
 */
-func (cli *MongoInstance) ReconciliateCollection(exportFile string, receivedFile string) {
-	utils.PrintHeaderL3("Resolving conflicts on mongo local storage")
-
-	cli.ExportCollection(exportFile) // Dump del database Locale
-	localExport, local_err := ParseCSV(exportFile)
-	receivedUpdate, recvd_err := ParseCSV(receivedFile)
-	if local_err != nil || recvd_err != nil {
-		return
+func (cli *MongoInstance) MergeCollection(exportFile string, receivedFile string) error {
+	utils.PrintHeaderL3("Merging mongo local storage")
+	receivedUpdate, err := ParseCSV(receivedFile)
+	if err != nil {
+		return err
 	}
-	reconEntries := ReconciliateEntries(localExport, receivedUpdate)
-	cli.Collection.Drop(context.TODO())
-	for _, entry := range reconEntries {
-		cli.PutMongoEntry(entry)
+	err = cli.MergeBatch(receivedUpdate)
+	if err != nil {
+		return err
 	}
-	cli.Collection.Find(context.TODO(), nil)
-	utils.PrintTs("Local collection reconciliated correctly")
+	utils.PrintTs("Collection merged succesfully")
+	return nil
 }
 
 /*
@@ -519,20 +572,63 @@ func (cli *MongoInstance) ReadEntry(key string) *MongoEntry {
 		utils.PrintTs("Read Error: " + err.Error())
 		return nil
 	}
-	entry := MongoEntry{}
-	id := result[ID].(string)
-	value := result[VALUE].(string)
-	timest := result[TIME].(primitive.DateTime)
-
-	lastAcc := result[LAST_ACC].(primitive.DateTime)
-	entry.Key = id
-	entry.Value = value
-	entry.Timest = timest.Time()
-	entry.LastAcc = lastAcc.Time()
+	entry := decodeEntry(result)
 	utils.PrintTs("Read:" + entry.Format())
 	return &entry
 }
 
+/*
+Converte un documento della collezione in un oggetto MongoEntry
+*/
+func decodeEntry(result bson.M) MongoEntry {
+	entry := MongoEntry{}
+	entry.Key = result[ID].(string)
+	entry.Value = DecodeValue(result[VALUE].(string))
+	if contentType, ok := result[CONTENT_TYPE].(string); ok {
+		entry.ContentType = contentType
+	}
+	entry.Timest = result[TIME].(primitive.DateTime).Time()
+	if lastAcc, ok := result[LAST_ACC].(primitive.DateTime); ok {
+		entry.LastAcc = lastAcc.Time()
+	}
+	if version, ok := result[VERSION].(string); ok {
+		entry.Version = ParseVectorClock(version)
+	}
+	if siblings, ok := result[SIBLINGS].(string); ok {
+		entry.Siblings = DecodeSiblings(siblings)
+	}
+	if deleted, ok := result[DELETED].(bool); ok {
+		entry.Deleted = deleted
+	}
+	if history, ok := result[HISTORY].(string); ok {
+		entry.History = DecodeSiblings(history)
+	}
+	switch max := result[MAX_VERSIONS].(type) {
+	case int32:
+		entry.MaxVersions = int(max)
+	case int64:
+		entry.MaxVersions = int(max)
+	}
+	if expires, ok := result[EXPIRES].(string); ok {
+		entry.Expires = ParseExpiry(expires)
+	}
+	return entry
+}
+
+/*
+Converte un oggetto MongoEntry in un documento della collezione. Vector clock e siblings vengono salvati
+come stringhe, così che mongoexport li esporti in un'unica colonna del CSV, mentre il valore viene salvato
+in base64 come nei CSV generati dagli altri motori
+*/
+func encodeEntry(entry MongoEntry) bson.D {
+	return bson.D{primitive.E{Key: ID, Value: entry.Key}, primitive.E{Key: VALUE, Value: EncodeValue(entry.Value)},
+		primitive.E{Key: TIME, Value: entry.Timest}, primitive.E{Key: LAST_ACC, Value: entry.LastAcc},
+		primitive.E{Key: VERSION, Value: entry.Version.String()}, primitive.E{Key: SIBLINGS, Value: EncodeSiblings(entry.Siblings)},
+		primitive.E{Key: DELETED, Value: entry.Deleted}, primitive.E{Key: HISTORY, Value: EncodeSiblings(entry.History)},
+		primitive.E{Key: MAX_VERSIONS, Value: entry.MaxVersions}, primitive.E{Key: EXPIRES, Value: FormatExpiry(entry.Expires)},
+		primitive.E{Key: CONTENT_TYPE, Value: entry.ContentType}}
+}
+
 /*
 Chiude la connessione con il database
 */
@@ -0,0 +1,144 @@
+package mongo
+
+import (
+	"JDSys/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+/*
+Interfaccia comune a tutti i motori di storage locale del nodo. Permette al nodo di utilizzare indifferentemente
+MongoDB, un DB in memoria oppure un DB embedded su file, selezionato tramite utils.STORAGE_ENGINE
+*/
+type StorageEngine interface {
+	// Operazioni sulle singole entry
+	GetEntry(key string) *MongoEntry
+	ReadEntry(key string) *MongoEntry
+	GetVersions(key string) *MongoEntry
+	ListEntries() []MongoEntry
+	PutEntry(key string, value []byte, contentType string, clock VectorClock, opts WriteOptions) error
+	ConditionalPut(key string, value []byte, contentType string, cond Condition, opts WriteOptions) error
+	PutMongoEntry(entry MongoEntry)
+	AppendValue(key string, arg1 []byte, opts WriteOptions) error
+	DeleteEntry(key string) error
+	PurgeEntry(key string) error
+	PurgeTombstone(key string, olderThan time.Time) error
+	PurgeVersion(key string, context VectorClock) error
+	ExpireEntry(key string) error
+	MergeEntry(entry MongoEntry) error
+	MergeBatch(entries []MongoEntry) error
+
+	// Export e aggiornamento dello storage tramite file CSV
+	ExportCollection(filename string) error
+	ExportDocument(key string, filename string) error
+	MergeCollection(exportFile string, receivedFile string) error
+
+	// Gestione del motore di storage
+	ListCloudKeys() []string
+	CheckRarelyAccessed()
+	CloseConnection()
+}
+
+/*
+Inizializza il motore di storage locale configurato, rimuovendo eventuali entry residue nel sistema.
+L'indirizzo del nodo identifica le scritture locali all'interno dei vector clock delle entry.
+*/
+func InitLocalSystem(addr string) StorageEngine {
+	nodeID := NodeClockID(addr)
+	switch utils.STORAGE_ENGINE {
+	case utils.MEMORY_ENGINE:
+		return InitMemorySystem(nodeID)
+	case utils.FILE_ENGINE:
+		return InitFileSystem(nodeID, utils.STORAGE_FILE)
+	default:
+		return InitMongoSystem(nodeID)
+	}
+}
+
+/*
+Routine che periodicamente rimuove fisicamente i tombstone più vecchi del periodo di grazia. Il periodo deve
+superare l'intervallo di riconciliazione, così che tutte le repliche abbiano ricevuto la cancellazione prima della rimozione.
+La lista delle entry è solo una fotografia: il motore verifica di nuovo il tombstone prima di rimuoverlo.
+*/
+func CollectTombstones(engine StorageEngine) {
+	for {
+		time.Sleep(utils.TOMBSTONE_GC_INTERVAL)
+		utils.PrintHeaderL2("Collecting expired tombstones")
+		olderThan := getTimestamp().Add(-utils.TOMBSTONE_GRACE_PERIOD)
+		for _, entry := range engine.ListEntries() {
+			if entry.IsDeleted() && !entry.Timest.After(olderThan) {
+				utils.PrintTs("Tombstone for key " + entry.Key + " expired, removing it")
+				engine.PurgeTombstone(entry.Key, olderThan)
+			}
+		}
+	}
+}
+
+/*
+Entry ritornata da una scansione, OnCloud indica una chiave migrata sul cloud, che viene ritornata senza valore
+*/
+type ScannedEntry struct {
+	MongoEntry
+	OnCloud bool
+}
+
+/*
+Ritorna le entry visibili ai client la cui chiave inizia con il prefisso e segue il cursore, in ordine di chiave.
+Vengono considerate solamente le chiavi per cui owns è vero, fino ad un massimo di limit entry: il secondo valore
+ritornato indica se sono presenti altre entry oltre il limite. Le chiavi migrate sul cloud vengono ritornate senza
+valore, per non scaricarle da S3.
+*/
+func ScanEntries(engine StorageEngine, prefix string, cursor string, limit int, owns func(key string) bool) ([]ScannedEntry, bool) {
+	var entries []ScannedEntry
+	now := getTimestamp()
+	for _, entry := range engine.ListEntries() {
+		if entry.IsLive(now) {
+			entries = append(entries, ScannedEntry{MongoEntry: entry})
+		}
+	}
+	for _, key := range engine.ListCloudKeys() {
+		entries = append(entries, ScannedEntry{MongoEntry: MongoEntry{Key: key}, OnCloud: true})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	var scanned []ScannedEntry
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, prefix) || entry.Key <= cursor || !owns(entry.Key) {
+			continue
+		}
+		if len(scanned) == limit {
+			return scanned, true
+		}
+		scanned = append(scanned, entry)
+	}
+	return scanned, false
+}
+
+/*
+Ritorna l'istante di scadenza di un'entry scritta adesso con il time-to-live specificato
+*/
+func ExpiryAfter(ttl time.Duration) time.Time {
+	return getTimestamp().Add(ttl)
+}
+
+/*
+Ritorna il tempo attuale secondo il clock del motore di storage, da utilizzare nei confronti con timestamp e scadenze delle entry
+*/
+func Now() time.Time {
+	return getTimestamp()
+}
+
+/*
+Ritorna il tempo attuale ottenuto dal server NTP. Se il server non è raggiungibile si utilizza il clock locale,
+così che il nodo possa funzionare anche senza accesso alla rete esterna.
+*/
+func getTimestamp() time.Time {
+	timestamp, err := ntp.Time(utils.NTP_SERVER)
+	if err != nil {
+		return time.Now()
+	}
+	return timestamp
+}
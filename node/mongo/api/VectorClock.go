@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Vector clock associato ad una versione di un'entry. Per ogni nodo che ha scritto l'entry
+mantiene il numero di scritture effettuate da quel nodo.
+*/
+type VectorClock map[string]uint64
+
+/*
+Ritorna l'identificativo di un nodo utilizzato nei vector clock, cioè il suo indirizzo IP. Un identificativo
+ottenuto da una parte dell'hash potrebbe coincidere per due nodi, che condividerebbero lo stesso contatore.
+L'indirizzo non contiene i separatori ';' e '=', così che il client possa inserirlo come contesto di una Put.
+*/
+func NodeClockID(addr string) string {
+	return addr
+}
+
+/*
+Ritorna una copia del vector clock
+*/
+func (vc VectorClock) Copy() VectorClock {
+	copied := make(VectorClock)
+	for node, counter := range vc {
+		copied[node] = counter
+	}
+	return copied
+}
+
+/*
+Ritorna un nuovo vector clock che contiene, per ogni nodo, il massimo tra i due contatori
+*/
+func (vc VectorClock) Merge(other VectorClock) VectorClock {
+	merged := vc.Copy()
+	for node, counter := range other {
+		if counter > merged[node] {
+			merged[node] = counter
+		}
+	}
+	return merged
+}
+
+/*
+Indica se il vector clock discende da other, cioè se ogni suo contatore è maggiore o uguale a quello di other
+*/
+func (vc VectorClock) Descends(other VectorClock) bool {
+	for node, counter := range other {
+		if vc[node] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Indica se i due vector clock sono uguali
+*/
+func (vc VectorClock) Equal(other VectorClock) bool {
+	return vc.Descends(other) && other.Descends(vc)
+}
+
+/*
+Indica se le due versioni sono concorrenti, cioè se nessuna delle due discende dall'altra
+*/
+func (vc VectorClock) Concurrent(other VectorClock) bool {
+	return !vc.Descends(other) && !other.Descends(vc)
+}
+
+/*
+Formatta il vector clock come lista ordinata di coppie nodo=contatore separate da ';'
+*/
+func (vc VectorClock) String() string {
+	var nodes []string
+	for node := range vc {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	var pairs []string
+	for _, node := range nodes {
+		pairs = append(pairs, node+"="+strconv.FormatUint(vc[node], 10))
+	}
+	return strings.Join(pairs, ";")
+}
+
+/*
+Ottiene un vector clock dalla sua rappresentazione testuale. Le coppie non valide vengono ignorate,
+una stringa vuota restituisce un vector clock nil.
+*/
+func ParseVectorClock(str string) VectorClock {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil
+	}
+	vc := make(VectorClock)
+	for _, pair := range strings.Split(str, ";") {
+		fields := strings.Split(pair, "=")
+		if len(fields) != 2 {
+			continue
+		}
+		counter, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		vc[strings.TrimSpace(fields[0])] = counter
+	}
+	return vc
+}
@@ -0,0 +1,105 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVectorClockCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       VectorClock
+		descends   bool
+		equal      bool
+		concurrent bool
+	}{
+		{"both empty", nil, nil, true, true, false},
+		{"descends from empty", VectorClock{"n1": 1}, nil, true, false, false},
+		{"equal", VectorClock{"n1": 2, "n2": 1}, VectorClock{"n1": 2, "n2": 1}, true, true, false},
+		{"greater counter", VectorClock{"n1": 3, "n2": 1}, VectorClock{"n1": 2, "n2": 1}, true, false, false},
+		{"extra node", VectorClock{"n1": 2, "n2": 1}, VectorClock{"n1": 2}, true, false, false},
+		{"precedes", VectorClock{"n1": 1}, VectorClock{"n1": 2}, false, false, false},
+		{"concurrent", VectorClock{"n1": 2, "n2": 1}, VectorClock{"n1": 1, "n2": 2}, false, false, true},
+		{"disjoint nodes", VectorClock{"n1": 1}, VectorClock{"n2": 1}, false, false, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Descends(tt.b); got != tt.descends {
+			t.Errorf("%s: Descends = %v, want %v", tt.name, got, tt.descends)
+		}
+		if got := tt.a.Equal(tt.b); got != tt.equal {
+			t.Errorf("%s: Equal = %v, want %v", tt.name, got, tt.equal)
+		}
+		if got := tt.a.Concurrent(tt.b); got != tt.concurrent {
+			t.Errorf("%s: Concurrent = %v, want %v", tt.name, got, tt.concurrent)
+		}
+	}
+}
+
+func TestVectorClockMerge(t *testing.T) {
+	a := VectorClock{"n1": 3, "n2": 1}
+	b := VectorClock{"n1": 1, "n2": 4, "n3": 2}
+	merged := a.Merge(b)
+	want := VectorClock{"n1": 3, "n2": 4, "n3": 2}
+	if !merged.Equal(want) {
+		t.Fatalf("Merge = %s, want %s", merged, want)
+	}
+	if !merged.Descends(a) || !merged.Descends(b) {
+		t.Fatalf("Merge %s does not descend from both clocks", merged)
+	}
+	if a["n2"] != 1 {
+		t.Fatalf("Merge modified the receiver: %s", a)
+	}
+}
+
+func TestVectorClockParse(t *testing.T) {
+	tests := []struct {
+		str  string
+		want VectorClock
+	}{
+		{"", nil},
+		{"10.0.0.1=2", VectorClock{"10.0.0.1": 2}},
+		{"10.0.0.1=2;10.0.0.2=5", VectorClock{"10.0.0.1": 2, "10.0.0.2": 5}},
+		{" 10.0.0.1 = 2 ; bad ; 10.0.0.2=x ", VectorClock{"10.0.0.1": 2}},
+	}
+	for _, tt := range tests {
+		got := ParseVectorClock(tt.str)
+		if (got == nil) != (tt.want == nil) || !got.Equal(tt.want) {
+			t.Errorf("ParseVectorClock(%q) = %s, want %s", tt.str, got, tt.want)
+		}
+		if round := ParseVectorClock(got.String()); !round.Equal(got) {
+			t.Errorf("round trip of %q = %s, want %s", tt.str, round, got)
+		}
+	}
+}
+
+func TestMergeVersionsKeepsConcurrentSiblings(t *testing.T) {
+	now := time.Now()
+	base := MongoEntry{Key: "k"}
+	base.Update([]byte("v0"), "", nil, "n1", now)
+
+	local := base
+	local.Update([]byte("local"), "", base.Context(), "n1", now.Add(time.Second))
+	remote := base
+	remote.Update([]byte("remote"), "", base.Context(), "n2", now.Add(2*time.Second))
+
+	merged := MergeVersions(local, remote)
+	if len(merged.Versions()) != 2 {
+		t.Fatalf("concurrent writes kept %d versions, want 2", len(merged.Versions()))
+	}
+	if string(merged.Value) != "remote" {
+		t.Fatalf("main version = %q, want the most recent write", merged.Value)
+	}
+
+	// Una scrittura con il contesto del merge sostituisce entrambe le versioni
+	resolved := merged
+	resolved.Update([]byte("resolved"), "", merged.Context(), "n1", now.Add(3*time.Second))
+	if len(resolved.Versions()) != 1 || string(resolved.Value) != "resolved" {
+		t.Fatalf("resolving write kept %d versions", len(resolved.Versions()))
+	}
+
+	// Una copia più vecchia non sostituisce la versione che la segue
+	stale := MergeVersions(resolved, base)
+	if len(stale.Versions()) != 1 || string(stale.Value) != "resolved" {
+		t.Fatalf("merging an older copy changed the entry to %q", stale.Value)
+	}
+}
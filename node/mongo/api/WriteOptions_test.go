@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteOptionsSetWithTheWrite(t *testing.T) {
+	cli := InitMemorySystem("n1")
+	expires := time.Now().Add(time.Hour)
+
+	cli.PutEntry("k", []byte("v1"), "", nil, WriteOptions{MaxVersions: 2, Expires: expires})
+	entry := cli.ReadEntry("k")
+	if !entry.Expires.Equal(expires) || entry.MaxVersions != 2 {
+		t.Fatalf("put: expires %v, max versions %d", entry.Expires, entry.MaxVersions)
+	}
+
+	// Un append senza scadenza mantiene quella della chiave, con una nuova scadenza la sostituisce
+	cli.AppendValue("k", []byte("v2"), WriteOptions{})
+	if entry = cli.ReadEntry("k"); !entry.Expires.Equal(expires) || entry.MaxVersions != 2 {
+		t.Fatalf("append: expires %v, max versions %d", entry.Expires, entry.MaxVersions)
+	}
+	later := expires.Add(time.Hour)
+	cli.AppendValue("k", []byte("v3"), WriteOptions{Expires: later})
+	if entry = cli.ReadEntry("k"); !entry.Expires.Equal(later) {
+		t.Fatalf("append with ttl: expires %v, want %v", entry.Expires, later)
+	}
+
+	// Una put senza scadenza la rimuove
+	cli.PutEntry("k", []byte("v4"), "", entry.Context(), WriteOptions{})
+	if entry = cli.ReadEntry("k"); !entry.Expires.IsZero() || entry.MaxVersions != 2 || len(entry.History) != 1 {
+		t.Fatalf("put without ttl: expires %v, max versions %d, history %d", entry.Expires, entry.MaxVersions, len(entry.History))
+	}
+
+	cli.ConditionalPut("c", []byte("v1"), "", Condition{Mode: COND_ABSENT}, WriteOptions{Expires: expires})
+	if entry = cli.ReadEntry("c"); entry == nil || !entry.Expires.Equal(expires) {
+		t.Fatalf("conditional put: entry %v", entry)
+	}
+}
+
+func TestWriteOptionsReachReplicas(t *testing.T) {
+	primary := InitMemorySystem("n1")
+	replica := InitMemorySystem("n2")
+	primary.PutEntry("k", []byte("v1"), "", nil, WriteOptions{})
+	replica.MergeEntry(*primary.ReadEntry("k"))
+
+	// La scadenza viaggia con la nuova versione, così che la replica la riceva con il merge
+	expires := time.Now().Add(time.Hour)
+	primary.PutEntry("k", []byte("v2"), "", primary.ReadEntry("k").Context(), WriteOptions{Expires: expires})
+	replica.MergeEntry(*primary.ReadEntry("k"))
+	if entry := replica.ReadEntry("k"); !entry.Expires.Equal(expires) {
+		t.Fatalf("replica expires %v, want %v", entry.Expires, expires)
+	}
+}
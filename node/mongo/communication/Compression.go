@@ -0,0 +1,112 @@
+package communication
+
+import (
+	"JDSys/utils"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Algoritmi di compressione dei trasferimenti
+const (
+	NO_COMPRESSION = "none"
+	GZIP           = "gzip"
+	SNAPPY         = "snappy"
+)
+
+/*
+Writer che comprime i dati scritti. Flush invia al writer sottostante i dati compressi fino a quel momento,
+Close termina lo stream compresso.
+*/
+type Compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+/*
+Compressor che non comprime i dati
+*/
+type plainWriter struct {
+	io.Writer
+}
+
+func (plainWriter) Flush() error { return nil }
+func (plainWriter) Close() error { return nil }
+
+/*
+Ritorna gli algoritmi di compressione proposti dal mittente, in ordine di preferenza:
+quello configurato per il cluster e, se il ricevente non lo supporta, nessuna compressione
+*/
+func OfferedCompressions() []string {
+	if !supportedCompression(utils.TRANSFER_COMPRESSION) || utils.TRANSFER_COMPRESSION == NO_COMPRESSION {
+		return []string{NO_COMPRESSION}
+	}
+	return []string{utils.TRANSFER_COMPRESSION, NO_COMPRESSION}
+}
+
+/*
+Sceglie l'algoritmo di compressione tra quelli proposti dal mittente: quello configurato sul ricevente
+se è stato proposto, altrimenti nessuna compressione
+*/
+func ChooseCompression(offered []string) string {
+	for _, compression := range offered {
+		if compression == utils.TRANSFER_COMPRESSION && supportedCompression(compression) {
+			return compression
+		}
+	}
+	return NO_COMPRESSION
+}
+
+/*
+Ritorna true se l'algoritmo di compressione è supportato
+*/
+func supportedCompression(compression string) bool {
+	return compression == NO_COMPRESSION || compression == GZIP || compression == SNAPPY
+}
+
+/*
+Crea un Compressor che scrive su w i dati compressi con l'algoritmo specificato
+*/
+func NewCompressor(w io.Writer, compression string) (Compressor, error) {
+	switch compression {
+	case NO_COMPRESSION:
+		return plainWriter{w}, nil
+	case GZIP:
+		return gzip.NewWriter(w), nil
+	case SNAPPY:
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nil, errors.New("Unsupported compression " + compression)
+}
+
+/*
+Crea un reader che decomprime i dati letti da r con l'algoritmo specificato
+*/
+func NewDecompressor(r io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case NO_COMPRESSION:
+		return r, nil
+	case GZIP:
+		return gzip.NewReader(r)
+	case SNAPPY:
+		return snappy.NewReader(r), nil
+	}
+	return nil, errors.New("Unsupported compression " + compression)
+}
+
+/*
+Writer che conta i byte scritti sulla connessione
+*/
+type CountingWriter struct {
+	W     io.Writer
+	Bytes int64
+}
+
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.W.Write(p)
+	cw.Bytes += int64(n)
+	return n, err
+}
@@ -2,156 +2,281 @@ package communication
 
 import (
 	"JDSys/utils"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"os"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 )
 
-// Dimensione del buffer per trasferire il file di aggiornamento
-const BUFFERSIZE = 1024
-
 /*
 Goroutine in cui ogni nodo è in attesa di connessioni per ricevere l'export CSV del DB di altri nodi. Tramite mode si specifica
-il servizio specifico, e quindi la porta su cui il nodo si metterà in ascolto
+il servizio specifico, e quindi la porta su cui il nodo si metterà in ascolto. Ogni file ricevuto ed integro viene passato ad apply,
+e si conferma il trasferimento al mittente solamente se apply lo ha applicato senza errori.
+Fino a TRANSFER_MAX_STREAMS trasferimenti vengono ricevuti ed applicati contemporaneamente, ognuno nel proprio file,
+per cui apply deve poter essere invocata in parallelo.
 */
-func StartReceiver(fileChannel chan string, mutex *sync.Mutex, mode string) {
+func StartReceiver(mode string, apply func(filename string) error) {
 	var port string
 	switch mode {
-	case utils.REPLN:
-		port = utils.FILETR_REPLICATION_PORT
-	case utils.RECON:
-		port = utils.FILETR_RECONCILIATION_PORT
 	case utils.MIGRN:
 		port = utils.FILETR_MIGRATION_PORT
 	}
-	server, err := net.Listen("tcp", port)
+	server, err := utils.Listen(port)
 	if err != nil {
 		utils.PrintTs("Listening Error: " + err.Error())
+		return
 	}
+	slots := make(chan struct{}, utils.TRANSFER_MAX_STREAMS)
 	for {
 		connection, err := server.Accept()
 		if err != nil {
 			utils.PrintTs("Accept Error: " + err.Error())
+			continue
 		}
-		receiveFile(connection, fileChannel, mutex, mode)
-		connection.Close()
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+			receiveFile(connection, mode, apply)
+			connection.Close()
+		}()
 	}
 }
 
 /*
 Apre la connessione verso un altro nodo per trasmettere un file. Mode specifica il servizio su cui si vuole inviare il messaggio, e quindi
-su quale porta inviare il file CSV
+su quale porta inviare il file CSV. Ritorna nil solamente dopo che il nodo ha confermato di aver applicato il file.
+Se il trasferimento si interrompe si ritenta fino a TRANSFER_RETRIES volte, riprendendo dall'ultimo byte salvato dal ricevente.
 */
 func StartSender(filename string, address string, mode string) error {
 	var addr string
 	switch mode {
-	case utils.REPLN:
-		addr = address + utils.FILETR_REPLICATION_PORT
-	case utils.RECON:
-		addr = address + utils.FILETR_RECONCILIATION_PORT
 	case utils.MIGRN:
 		addr = address + utils.FILETR_MIGRATION_PORT
 	}
-	connection, err := net.DialTimeout("tcp", addr, 20*time.Second)
+	digest, err := fileDigest(filename)
 	if err != nil {
 		utils.PrintTs(err.Error())
 		return err
 	}
-	defer connection.Close()
-	utils.PrintTs("Ready to send DB export")
-	return sendFile(connection, filename)
+
+	attempts := 0
+retry:
+	connection, err := utils.DialTimeout(addr, 20*time.Second)
+	if err == nil {
+		utils.PrintTs("Ready to send DB export")
+		err = sendFile(connection, filename, digest, address, mode)
+		connection.Close()
+	}
+	if err != nil {
+		attempts++
+		utils.PrintTs("Transfer to " + address + " failed: " + err.Error())
+		if attempts < utils.TRANSFER_RETRIES {
+			time.Sleep(utils.TRANSFER_RETRY_TIME)
+			utils.PrintTs("Resuming transfer to " + address + ", attempt " + strconv.Itoa(attempts+1))
+			goto retry
+		}
+	}
+	return err
 }
 
 /*
-Utility per ricevere un file tramite la connessione
+Utility per ricevere un file tramite la connessione. Si verifica il checksum di ogni frame e, al termine,
+dimensione e digest dell'intero file, che viene salvato su disco prima di essere applicato.
+Al mittente si risponde con un ACK se il file è stato applicato, altrimenti con un NACK con il motivo del rifiuto.
+Il file parziale viene rimosso solamente dopo essere stato applicato, così che un nuovo tentativo non debba riceverlo di nuovo.
 */
-func receiveFile(connection net.Conn, fileChannel chan string, mutex *sync.Mutex, mode string) {
-	var receivedBytes int64
-	var newFile *os.File
-	var err error
-
-	bufferFileSize := make([]byte, 10)
-
-	mutex.Lock()
-	connection.Read(bufferFileSize)
-	fileSize, _ := strconv.ParseInt(strings.Trim(string(bufferFileSize), ":"), 10, 64)
-
+func receiveFile(connection net.Conn, mode string, apply func(filename string) error) {
+	var dir string
 	switch mode {
-	case utils.REPLN:
-		utils.PrintHeaderL2("A node wants to send his replica updates via TCP")
-		newFile, err = os.Create(utils.REPLICATION_RECEIVE_FILE)
-
-	case utils.RECON:
-		utils.PrintHeaderL2("A node wants to send a Reconciliation message via TCP")
-		newFile, err = os.Create(utils.RECONCILIATION_RECEIVE_FILE)
-
 	case utils.MIGRN:
 		utils.PrintHeaderL2("A node wants to send his entries via TCP")
-		newFile, err = os.Create(utils.MIGRATION_RECEIVE_FILE)
+		dir = utils.MIGRATION_RECEIVE_PATH
 	}
+	cleanPartials(dir)
 
+	filename, key, err := receivePayload(connection, mode, dir)
+	if key != "" {
+		defer releasePartial(key)
+	}
+	if err == nil {
+		utils.PrintTs("File received correctly")
+		err = apply(filename)
+	}
 	if err != nil {
-		utils.PrintTs(err.Error())
+		utils.PrintTs("Transfer rejected: " + err.Error())
+		writeFrame(connection, FRAME_NACK, []byte(err.Error()))
+		return
 	}
-	for {
-		if (fileSize - receivedBytes) < BUFFERSIZE {
-			io.CopyN(newFile, connection, (fileSize - receivedBytes))
-			connection.Read(make([]byte, (receivedBytes+BUFFERSIZE)-fileSize))
-			break
-		}
-		io.CopyN(newFile, connection, BUFFERSIZE)
-		receivedBytes += BUFFERSIZE
+	removePartial(filename)
+	writeFrame(connection, FRAME_ACK, nil)
+}
+
+/*
+Riceve i frame di un trasferimento e ne scrive il contenuto decompresso nel file parziale del trasferimento,
+ritornandone il percorso e la chiave, che il chiamante deve rilasciare. Nell'accettare il trasferimento si comunicano
+la compressione, scelta tra quelle proposte dal mittente, ed il numero di byte già salvati da un tentativo precedente,
+da cui il mittente riprende l'invio. Un file già in ricezione da un'altra connessione viene rifiutato.
+*/
+func receivePayload(connection net.Conn, mode string, dir string) (string, string, error) {
+	connection.SetReadDeadline(time.Now().Add(utils.TRANSFER_TIMEOUT))
+	kind, payload, err := readFrame(connection)
+	if err != nil {
+		return "", "", err
+	}
+	var header transferHeader
+	if kind != FRAME_START || json.Unmarshal(payload, &header) != nil {
+		return "", "", errors.New("Transfer not opened correctly")
+	}
+	if header.Mode != mode {
+		return "", "", errors.New("Unexpected transfer mode " + header.Mode)
+	}
+	id, err := hex.DecodeString(header.Id)
+	if err != nil || len(id) != sha256.Size {
+		return "", "", errors.New("Invalid transfer id")
+	}
+	key := transferKey(connection, mode, header.Id)
+	if !claimPartial(key) {
+		return "", "", errors.New("Transfer already in progress")
+	}
+
+	partial, err := openPartial(dir, key, header.Size)
+	if err != nil {
+		return "", key, err
+	}
+	defer partial.Close()
+	if partial.stored > 0 {
+		utils.PrintTs("Resuming transfer from byte " + strconv.FormatInt(partial.stored, 10) + " of " + strconv.FormatInt(header.Size, 10))
+	}
+	compression := ChooseCompression(header.Compressions)
+	accept, _ := json.Marshal(transferAccept{Compression: compression, Offset: partial.stored})
+	err = writeFrame(connection, FRAME_ACCEPT, accept)
+	if err != nil {
+		return "", key, err
+	}
+
+	frames := &frameReader{conn: connection}
+	reader, err := NewDecompressor(frames, compression)
+	if err == nil {
+		_, err = io.Copy(partial, reader)
+	}
+	// Quanto ricevuto viene salvato anche se il trasferimento si interrompe
+	commitErr := partial.commit()
+	if err == nil {
+		err = commitErr
+	}
+	if err != nil {
+		return "", key, err
+	}
+	if !frames.ended {
+		return "", key, errors.New("Transfer not terminated")
 	}
-	defer newFile.Close()
-	utils.PrintTs("File received correctly")
-	fileChannel <- "rcvd"
+	if partial.stored != header.Size {
+		removePartial(partial.path)
+		return "", key, errors.New("Received " + strconv.FormatInt(partial.stored, 10) + " bytes, expected " + strconv.FormatInt(header.Size, 10))
+	}
+	digest, err := fileDigest(partial.path)
+	if err != nil {
+		return "", key, err
+	}
+	if !bytes.Equal(frames.end, digest) || !bytes.Equal(id, digest) {
+		removePartial(partial.path)
+		return "", key, errors.New("File digest mismatch")
+	}
+	return partial.path, key, nil
 }
 
 /*
-Utility per inviare un file tramite la connessione
+Utility per inviare un file tramite la connessione. Il file viene compresso con l'algoritmo accettato dal ricevente
+ed inviato, a partire dal byte indicato dal ricevente, in frame di al massimo FRAME_SIZE byte, seguiti dal digest
+dell'intero file, e si attende l'esito dal ricevente. Si registrano i byte trasmessi ed il tempo impiegato,
+fino alla conferma del ricevente.
 */
-func sendFile(connection net.Conn, filename string) error {
+func sendFile(connection net.Conn, filename string, digest []byte, address string, mode string) error {
+	started := time.Now()
 	file, err := os.Open(filename)
 	if err != nil {
-		utils.PrintTs(err.Error())
 		return err
 	}
+	defer file.Close()
 	fileInfo, err := file.Stat()
 	if err != nil {
-		utils.PrintTs(err.Error())
 		return err
 	}
 
-	fileSize := fillString(strconv.FormatInt(fileInfo.Size(), 10), 10)
-	connection.Write([]byte(fileSize))
-	sendBuffer := make([]byte, BUFFERSIZE)
-	utils.PrintTs("Start sending file via TCP")
-	for {
-		_, err = file.Read(sendBuffer)
-		if err == io.EOF {
-			break
-		}
-		connection.Write(sendBuffer)
+	header, _ := json.Marshal(transferHeader{Mode: mode, Id: hex.EncodeToString(digest), Size: fileInfo.Size(), Compressions: OfferedCompressions()})
+	err = writeFrame(connection, FRAME_START, header)
+	if err != nil {
+		return err
+	}
+	connection.SetReadDeadline(time.Now().Add(utils.TRANSFER_TIMEOUT))
+	kind, payload, err := readFrame(connection)
+	if err != nil {
+		return err
+	}
+	if kind == FRAME_NACK {
+		return errors.New("Transfer rejected by receiver: " + string(payload))
+	}
+	var accept transferAccept
+	if kind != FRAME_ACCEPT || json.Unmarshal(payload, &accept) != nil {
+		return errors.New("Unexpected frame type " + strconv.Itoa(int(kind)))
+	}
+	if accept.Offset < 0 || accept.Offset > fileInfo.Size() {
+		return errors.New("Invalid resume offset " + strconv.FormatInt(accept.Offset, 10))
+	}
+	_, err = file.Seek(accept.Offset, io.SeekStart)
+	if err != nil {
+		return err
 	}
-	utils.PrintTs("File sent correctly!")
-	return nil
-}
 
-/*
-Riempie una stringa per raggiungere una lunghezza specificata
-*/
-func fillString(retunString string, toLength int) string {
-	for {
-		lengtString := len(retunString)
-		if lengtString < toLength {
-			retunString = retunString + ":"
-			continue
-		}
-		break
+	// I dati compressi vengono raccolti in frame pieni prima di essere inviati
+	frames := &frameWriter{w: connection}
+	buffered := bufio.NewWriterSize(frames, FRAME_SIZE)
+	compressor, err := NewCompressor(buffered, accept.Compression)
+	if err != nil {
+		return err
+	}
+	if accept.Offset > 0 {
+		utils.PrintTs("Receiver already stored " + strconv.FormatInt(accept.Offset, 10) + " bytes, resuming from there")
+	}
+	utils.PrintTs("Start sending file via TCP with compression " + accept.Compression)
+	_, err = io.Copy(compressor, file)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = writeFrame(connection, FRAME_END, digest)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Il ricevente risponde dopo aver applicato il file
+	connection.SetReadDeadline(time.Now().Add(utils.TRANSFER_ACK_TIMEOUT))
+	kind, payload, err = readFrame(connection)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case FRAME_ACK:
+		elapsed := time.Since(started)
+		sent := fileInfo.Size() - accept.Offset
+		RecordTransfer(mode, address, accept.Compression, sent, frames.bytes, elapsed)
+		utils.PrintTs("File sent correctly! " + strconv.FormatInt(sent, 10) + " bytes sent as " +
+			strconv.FormatInt(frames.bytes, 10) + " (" + accept.Compression + ") in " + elapsed.String())
+		return nil
+	case FRAME_NACK:
+		return errors.New("Transfer rejected by receiver: " + string(payload))
+	default:
+		return errors.New("Unexpected frame type " + strconv.Itoa(int(kind)))
 	}
-	return retunString
 }
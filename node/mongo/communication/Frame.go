@@ -0,0 +1,152 @@
+package communication
+
+import (
+	"JDSys/utils"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Versione del formato dei frame, un nodo rifiuta i frame con una versione diversa
+const FRAME_VERSION = 3
+
+// Dimensione massima del payload di un frame di dati
+const FRAME_SIZE = 64 * 1024
+
+// Dimensione dell'header: versione, tipo, lunghezza del payload e checksum CRC32 del payload
+const FRAME_HEADER_SIZE = 10
+
+// Tipi di messaggio del protocollo di trasferimento
+const (
+	FRAME_START  byte = iota + 1 // Apertura del trasferimento, con servizio, digest e dimensione del file e compressioni proposte
+	FRAME_ACCEPT                 // Il ricevente accetta il trasferimento, con la compressione scelta ed i byte già salvati
+	FRAME_DATA                   // Porzione del file compresso
+	FRAME_END                    // Fine del file, con il digest SHA-256 dell'intero file non compresso
+	FRAME_ACK                    // Il ricevente ha applicato il file
+	FRAME_NACK                   // Il ricevente ha rifiutato il file, con il motivo
+)
+
+/*
+Header del frame di apertura di un trasferimento. Id è il digest del file, con cui il ricevente
+ritrova il file parziale di un tentativo precedente.
+*/
+type transferHeader struct {
+	Mode         string
+	Id           string
+	Size         int64
+	Compressions []string
+}
+
+/*
+Risposta del ricevente all'apertura di un trasferimento: compressione scelta e byte del file già salvati,
+da cui il mittente riprende l'invio
+*/
+type transferAccept struct {
+	Compression string
+	Offset      int64
+}
+
+/*
+Writer che suddivide i dati scritti in frame di dati
+*/
+type frameWriter struct {
+	w     io.Writer
+	bytes int64
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + FRAME_SIZE
+		if end > len(p) {
+			end = len(p)
+		}
+		err := writeFrame(fw.w, FRAME_DATA, p[written:end])
+		if err != nil {
+			return written, err
+		}
+		fw.bytes += int64(end - written)
+		written = end
+	}
+	return written, nil
+}
+
+/*
+Reader che ritorna il contenuto dei frame di dati fino al frame di fine, di cui mantiene il payload.
+Ogni frame deve arrivare entro TRANSFER_TIMEOUT.
+*/
+type frameReader struct {
+	conn    net.Conn
+	pending []byte
+	end     []byte
+	ended   bool
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.pending) == 0 {
+		if fr.ended {
+			return 0, io.EOF
+		}
+		fr.conn.SetReadDeadline(time.Now().Add(utils.TRANSFER_TIMEOUT))
+		kind, payload, err := readFrame(fr.conn)
+		if err != nil {
+			return 0, err
+		}
+		switch kind {
+		case FRAME_DATA:
+			fr.pending = payload
+		case FRAME_END:
+			fr.end = payload
+			fr.ended = true
+		default:
+			return 0, errors.New("Unexpected frame type " + strconv.Itoa(int(kind)))
+		}
+	}
+	n := copy(p, fr.pending)
+	fr.pending = fr.pending[n:]
+	return n, nil
+}
+
+/*
+Scrive un frame sulla connessione: header seguito dal payload
+*/
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	header := make([]byte, FRAME_HEADER_SIZE)
+	header[0] = FRAME_VERSION
+	header[1] = kind
+	binary.BigEndian.PutUint32(header[2:6], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[6:10], crc32.ChecksumIEEE(payload))
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+/*
+Legge un frame dalla connessione, verificandone versione, lunghezza e checksum
+*/
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, FRAME_HEADER_SIZE)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[0] != FRAME_VERSION {
+		return 0, nil, errors.New("Unsupported frame version " + strconv.Itoa(int(header[0])))
+	}
+	length := binary.BigEndian.Uint32(header[2:6])
+	if length > FRAME_SIZE {
+		return 0, nil, errors.New("Frame too large: " + strconv.FormatUint(uint64(length), 10) + " bytes")
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[6:10]) {
+		return 0, nil, errors.New("Frame checksum mismatch")
+	}
+	return header[1], payload, nil
+}
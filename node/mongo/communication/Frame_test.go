@@ -0,0 +1,103 @@
+package communication
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		kind    byte
+		payload []byte
+	}{
+		{FRAME_START, []byte(`{"Mode":"migr"}`)},
+		{FRAME_DATA, bytes.Repeat([]byte{0xab}, FRAME_SIZE)},
+		{FRAME_END, nil},
+		{FRAME_ACK, []byte{}},
+	}
+	var buf bytes.Buffer
+	for _, tt := range tests {
+		if err := writeFrame(&buf, tt.kind, tt.payload); err != nil {
+			t.Fatalf("writeFrame(%d): %v", tt.kind, err)
+		}
+	}
+	for _, tt := range tests {
+		kind, payload, err := readFrame(&buf)
+		if err != nil {
+			t.Fatalf("readFrame(%d): %v", tt.kind, err)
+		}
+		if kind != tt.kind || !bytes.Equal(payload, tt.payload) {
+			t.Errorf("readFrame = %d with %d bytes, want %d with %d bytes", kind, len(payload), tt.kind, len(tt.payload))
+		}
+	}
+	if _, _, err := readFrame(&buf); err != io.EOF {
+		t.Fatalf("readFrame at the end = %v, want EOF", err)
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	frame := func(kind byte, payload []byte) []byte {
+		var buf bytes.Buffer
+		writeFrame(&buf, kind, payload)
+		return buf.Bytes()
+	}
+
+	badVersion := frame(FRAME_DATA, []byte("data"))
+	badVersion[0] = FRAME_VERSION - 1
+
+	tooLarge := frame(FRAME_DATA, nil)
+	tooLarge[2], tooLarge[3], tooLarge[4], tooLarge[5] = 0, 1, 0, 1
+
+	corrupted := frame(FRAME_DATA, []byte("data"))
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	truncated := frame(FRAME_DATA, []byte("data"))
+	truncated = truncated[:len(truncated)-2]
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{"bad version", badVersion, "Unsupported frame version 2"},
+		{"too large", tooLarge, "Frame too large: 65537 bytes"},
+		{"checksum mismatch", corrupted, "Frame checksum mismatch"},
+		{"truncated payload", truncated, io.ErrUnexpectedEOF.Error()},
+		{"truncated header", badVersion[:FRAME_HEADER_SIZE-1], io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		_, _, err := readFrame(bytes.NewReader(tt.frame))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: readFrame error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFrameWriterSplitsData(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &frameWriter{w: &buf}
+	data := bytes.Repeat([]byte("0123456789"), FRAME_SIZE/4)
+	if n, err := fw.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write = %d, %v, want %d bytes", n, err, len(data))
+	}
+	if fw.bytes != int64(len(data)) {
+		t.Fatalf("frameWriter counted %d bytes, want %d", fw.bytes, len(data))
+	}
+	var received []byte
+	frames := 0
+	for buf.Len() > 0 {
+		kind, payload, err := readFrame(&buf)
+		if err != nil || kind != FRAME_DATA {
+			t.Fatalf("frame %d: kind %d, error %v", frames, kind, err)
+		}
+		if len(payload) > FRAME_SIZE {
+			t.Fatalf("frame %d carries %d bytes", frames, len(payload))
+		}
+		received = append(received, payload...)
+		frames++
+	}
+	if frames != 3 || !bytes.Equal(received, data) {
+		t.Fatalf("data split in %d frames, reassembled equal = %v", frames, bytes.Equal(received, data))
+	}
+}
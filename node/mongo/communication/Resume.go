@@ -0,0 +1,182 @@
+package communication
+
+import (
+	"JDSys/utils"
+	"crypto/sha256"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+File parziale di un trasferimento, identificato dal mittente, dal servizio e dal digest del file inviato.
+Ogni TRANSFER_SYNC_BYTES byte ricevuti il file viene salvato su disco e se ne registra la dimensione, così che
+dopo una disconnessione il mittente possa riprendere l'invio dall'ultimo byte salvato invece che dall'inizio.
+*/
+type partialFile struct {
+	path    string
+	file    *os.File
+	stored  int64
+	written int64
+}
+
+var claimMutex sync.Mutex
+var claimed = make(map[string]bool)
+
+/*
+Ritorna la chiave del file parziale di un trasferimento: mittenti diversi che inviano lo stesso contenuto,
+ad esempio due esportazioni vuote, ricevono in file parziali distinti, mentre i tentativi successivi
+di uno stesso mittente riprendono dal suo file parziale
+*/
+func transferKey(connection net.Conn, mode string, id string) string {
+	sender, _, err := net.SplitHostPort(connection.RemoteAddr().String())
+	if err != nil {
+		sender = connection.RemoteAddr().String()
+	}
+	return strings.NewReplacer(":", "_", "%", "_", "/", "_").Replace(sender) + "-" + mode + "-" + id
+}
+
+/*
+Riserva il file parziale di un trasferimento alla connessione che lo sta ricevendo.
+Ritorna false se lo stesso file è già in ricezione da un'altra connessione.
+*/
+func claimPartial(key string) bool {
+	claimMutex.Lock()
+	defer claimMutex.Unlock()
+	if claimed[key] {
+		return false
+	}
+	claimed[key] = true
+	return true
+}
+
+/*
+Rilascia il file parziale di un trasferimento al termine della ricezione
+*/
+func releasePartial(key string) {
+	claimMutex.Lock()
+	defer claimMutex.Unlock()
+	delete(claimed, key)
+}
+
+/*
+Ritorna true se il file parziale di un trasferimento è in ricezione
+*/
+func isClaimed(key string) bool {
+	claimMutex.Lock()
+	defer claimMutex.Unlock()
+	return claimed[key]
+}
+
+/*
+Apre il file parziale di un trasferimento, troncandolo all'ultimo byte salvato. Se il file non esiste,
+o l'offset salvato non è valido, il trasferimento riparte dall'inizio.
+*/
+func openPartial(dir string, key string, size int64) (*partialFile, error) {
+	os.MkdirAll(dir, 0755)
+	partial := &partialFile{path: filepath.Join(dir, key+".part")}
+	data, err := os.ReadFile(partial.path + ".offset")
+	if err == nil {
+		partial.stored, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	partial.file, err = os.OpenFile(partial.path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := partial.file.Stat()
+	if err != nil || partial.stored < 0 || partial.stored > size || partial.stored > info.Size() {
+		partial.stored = 0
+	}
+	err = partial.file.Truncate(partial.stored)
+	if err == nil {
+		_, err = partial.file.Seek(partial.stored, io.SeekStart)
+	}
+	if err != nil {
+		partial.file.Close()
+		return nil, err
+	}
+	partial.written = partial.stored
+	return partial, nil
+}
+
+/*
+Scrive i dati ricevuti nel file parziale, salvandolo su disco ogni TRANSFER_SYNC_BYTES byte
+*/
+func (partial *partialFile) Write(p []byte) (int, error) {
+	n, err := partial.file.Write(p)
+	partial.written += int64(n)
+	if err == nil && partial.written-partial.stored >= int64(utils.TRANSFER_SYNC_BYTES) {
+		err = partial.commit()
+	}
+	return n, err
+}
+
+/*
+Salva su disco i dati scritti e registra la dimensione del file, da cui riprenderà un trasferimento interrotto
+*/
+func (partial *partialFile) commit() error {
+	err := partial.file.Sync()
+	if err != nil {
+		return err
+	}
+	tmp := partial.path + ".offset.tmp"
+	err = os.WriteFile(tmp, []byte(strconv.FormatInt(partial.written, 10)), 0644)
+	if err == nil {
+		err = os.Rename(tmp, partial.path+".offset")
+	}
+	if err == nil {
+		partial.stored = partial.written
+	}
+	return err
+}
+
+func (partial *partialFile) Close() error {
+	return partial.file.Close()
+}
+
+/*
+Rimuove il file parziale di un trasferimento ed il suo offset
+*/
+func removePartial(path string) {
+	os.Remove(path)
+	os.Remove(path + ".offset")
+}
+
+/*
+Rimuove i file parziali dei trasferimenti non ripresi da almeno TRANSFER_PARTIAL_TTL, e non in ricezione
+*/
+func cleanPartials(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.part"))
+	for _, file := range files {
+		if isClaimed(strings.TrimSuffix(filepath.Base(file), ".part")) {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err == nil && time.Since(info.ModTime()) >= utils.TRANSFER_PARTIAL_TTL {
+			utils.PrintTs("Removing abandoned partial transfer " + filepath.Base(file))
+			removePartial(file)
+		}
+	}
+}
+
+/*
+Calcola il digest SHA-256 di un file
+*/
+func fileDigest(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	digest := sha256.New()
+	_, err = io.Copy(digest, file)
+	if err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
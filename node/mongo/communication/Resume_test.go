@@ -0,0 +1,133 @@
+package communication
+
+import (
+	"JDSys/utils"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+Avvia un ricevente di migrazioni su una porta locale, che applica i file ricevuti con apply
+*/
+func startTestReceiver(t *testing.T, apply func(filename string) error) string {
+	receivePath := utils.MIGRATION_RECEIVE_PATH
+	utils.MIGRATION_RECEIVE_PATH = t.TempDir()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		utils.MIGRATION_RECEIVE_PATH = receivePath
+	})
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				receiveFile(connection, utils.MIGRN, apply)
+				connection.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+/*
+Invia un file al ricevente da un indirizzo locale specificato, così da simulare mittenti diversi
+*/
+func sendFrom(t *testing.T, local string, receiver string, filename string) error {
+	digest, err := fileDigest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)}, Timeout: time.Second}
+	connection, err := dialer.Dial("tcp", receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	return sendFile(connection, filename, digest, local, utils.MIGRN)
+}
+
+func writeTestFile(t *testing.T, data []byte) string {
+	filename := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestConcurrentReceiveOfSameContent(t *testing.T) {
+	// Il ricevente applica i file solo quando entrambi i trasferimenti sono in corso
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	receiver := startTestReceiver(t, func(filename string) error {
+		arrived.Done()
+		arrived.Wait()
+		return nil
+	})
+	filename := writeTestFile(t, nil)
+
+	errs := make(chan error, 2)
+	for _, local := range []string{"127.0.0.1", "127.0.0.2"} {
+		go func(local string) { errs <- sendFrom(t, local, receiver, filename) }(local)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("transfer of the same content from two senders failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("transfers of the same content from two senders did not run concurrently")
+		}
+	}
+}
+
+func TestReceiveResumesFromSavedOffset(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	statsMutex.Lock()
+	transferStats = make(map[string]*TransferStats)
+	statsMutex.Unlock()
+	var received []byte
+	receiver := startTestReceiver(t, func(filename string) error {
+		received, _ = os.ReadFile(filename)
+		return nil
+	})
+	filename := writeTestFile(t, data)
+	digest, _ := fileDigest(filename)
+
+	// Un tentativo precedente dello stesso mittente ha salvato la prima metà del file
+	key := "127.0.0.1-" + utils.MIGRN + "-" + hex.EncodeToString(digest)
+	partial, err := openPartial(utils.MIGRATION_RECEIVE_PATH, key, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.Write(data[:len(data)/2])
+	partial.commit()
+	partial.Close()
+
+	if err := sendFrom(t, "127.0.0.1", receiver, filename); err != nil {
+		t.Fatalf("resumed transfer failed: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("resumed transfer applied %d bytes, want the original %d", len(received), len(data))
+	}
+	if stats := Stats(); len(stats) != 1 || stats[0].RawBytes != int64(len(data)/2) {
+		t.Errorf("resumed transfer stats = %+v, want only the missing %d bytes sent", stats, len(data)/2)
+	}
+
+	// Il file parziale viene rimosso dopo essere stato applicato
+	if _, err := os.Stat(partial.path); !os.IsNotExist(err) {
+		t.Errorf("partial file not removed after being applied")
+	}
+}
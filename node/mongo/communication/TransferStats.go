@@ -0,0 +1,72 @@
+package communication
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Statistiche dei trasferimenti inviati da un nodo verso un altro nodo per un servizio: byte prima e dopo
+la compressione, e tempo complessivo dei trasferimenti
+*/
+type TransferStats struct {
+	Mode        string
+	Peer        string
+	Compression string
+	Transfers   int
+	RawBytes    int64
+	WireBytes   int64
+	Duration    time.Duration
+}
+
+/*
+Ritorna il rapporto tra i byte trasmessi e quelli originali, 1 se non è stato trasferito nulla
+*/
+func (stats TransferStats) Ratio() float64 {
+	if stats.RawBytes == 0 {
+		return 1
+	}
+	return float64(stats.WireBytes) / float64(stats.RawBytes)
+}
+
+var statsMutex sync.Mutex
+var transferStats = make(map[string]*TransferStats)
+
+/*
+Registra un trasferimento verso un nodo
+*/
+func RecordTransfer(mode string, peer string, compression string, rawBytes int64, wireBytes int64, elapsed time.Duration) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	id := mode + "/" + peer
+	stats, ok := transferStats[id]
+	if !ok {
+		stats = &TransferStats{Mode: mode, Peer: peer}
+		transferStats[id] = stats
+	}
+	stats.Compression = compression
+	stats.Transfers++
+	stats.RawBytes += rawBytes
+	stats.WireBytes += wireBytes
+	stats.Duration += elapsed
+}
+
+/*
+Ritorna le statistiche dei trasferimenti inviati dal nodo, ordinate per servizio e nodo
+*/
+func Stats() []TransferStats {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	var all []TransferStats
+	for _, stats := range transferStats {
+		all = append(all, *stats)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Mode != all[j].Mode {
+			return all[i].Mode < all[j].Mode
+		}
+		return all[i].Peer < all[j].Peer
+	})
+	return all
+}
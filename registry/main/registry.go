@@ -164,7 +164,12 @@ func InitRegistry() *http.Server {
 	rpc.Register(service)
 	rpc.HandleHTTP()
 
-	go server.ListenAndServe()
+	listener, err := utils.Listen(utils.REGISTRY_PORT)
+	if err != nil {
+		utils.PrintTs("Listening Error: " + err.Error())
+		os.Exit(1)
+	}
+	go server.Serve(listener)
 	utils.PrintTs("Service Registry waiting for incoming connections")
 
 	go StartCheckTerminatingNodes()
@@ -2,7 +2,6 @@ package impl
 
 import (
 	"JDSys/utils"
-	"net/rpc"
 )
 
 var WORKLOAD_GET []int
@@ -77,7 +76,7 @@ func GetRPC(key string, print bool) {
 
 	var reply *string
 
-	client, _ := rpc.DialHTTP("tcp", utils.LB_DNS_NAME+utils.RPC_PORT)
+	client, _ := utils.HttpConnectTimeout(utils.LB_DNS_NAME, utils.RPC_PORT, utils.RR1_TIMEOUT)
 	if client != nil {
 		client.Call(GET, args, &reply)
 		client.Close()
@@ -94,7 +93,7 @@ func PutRPC(key string, value string, print bool) {
 
 	var reply *string
 
-	client, _ := rpc.DialHTTP("tcp", utils.LB_DNS_NAME+utils.RPC_PORT)
+	client, _ := utils.HttpConnectTimeout(utils.LB_DNS_NAME, utils.RPC_PORT, utils.RR1_TIMEOUT)
 	if client != nil {
 		client.Call(PUT, args, &reply)
 		client.Close()
@@ -110,7 +109,7 @@ func AppendRPC(key string, value string, print bool) {
 	args.Value = value
 
 	var reply *string
-	client, _ := rpc.DialHTTP("tcp", utils.LB_DNS_NAME+utils.RPC_PORT)
+	client, _ := utils.HttpConnectTimeout(utils.LB_DNS_NAME, utils.RPC_PORT, utils.RR1_TIMEOUT)
 	if client != nil {
 		client.Call(APP, args, &reply)
 		client.Close()
@@ -125,7 +124,7 @@ func DeleteRPC(key string, print bool) {
 	args.Key = key
 
 	var reply *string
-	client, _ := rpc.DialHTTP("tcp", utils.LB_DNS_NAME+utils.RPC_PORT)
+	client, _ := utils.HttpConnectTimeout(utils.LB_DNS_NAME, utils.RPC_PORT, utils.RR1_TIMEOUT)
 	if client != nil {
 		client.Call(DEL, args, &reply)
 		client.Close()
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsOnce sync.Once
+var tlsConfig *tls.Config
+var tlsErr error
+
+/*
+Ritorna la configurazione TLS del nodo, caricata una sola volta dai file TLS_CA_FILE, TLS_CERT_FILE e TLS_KEY_FILE.
+La stessa configurazione è utilizzata in ascolto ed in connessione: si presenta il certificato del nodo e si accettano
+solamente i peer con un certificato firmato dalla CA del cluster.
+*/
+func TLSConfig() (*tls.Config, error) {
+	tlsOnce.Do(func() {
+		tlsConfig, tlsErr = loadTLSConfig()
+	})
+	return tlsConfig, tlsErr
+}
+
+/*
+Carica la CA del cluster ed il certificato del nodo
+*/
+func loadTLSConfig() (*tls.Config, error) {
+	caPEM, err := os.ReadFile(TLS_CA_FILE)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("No CA certificate found in " + TLS_CA_FILE)
+	}
+	cert, err := tls.LoadX509KeyPair(TLS_CERT_FILE, TLS_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ServerName:   TLS_SERVER_NAME,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+/*
+Si mette in ascolto sull'indirizzo specificato. Con TLS_ENABLED le connessioni vengono cifrate, e quelle dei peer
+senza un certificato firmato dalla CA del cluster vengono rifiutate durante l'handshake.
+*/
+func Listen(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil || !TLS_ENABLED {
+		return listener, err
+	}
+	config, err := TLSConfig()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return tls.NewListener(listener, config), nil
+}
+
+/*
+Apre una connessione verso l'indirizzo specificato con il dialer fornito. Con TLS_ENABLED si completa l'handshake
+prima di ritornare, verificando che il peer abbia un certificato firmato dalla CA del cluster.
+*/
+func Dial(dialer *net.Dialer, address string) (net.Conn, error) {
+	if !TLS_ENABLED {
+		return dialer.Dial("tcp", address)
+	}
+	config, err := TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(dialer, "tcp", address, config)
+}
+
+/*
+Apre una connessione verso l'indirizzo specificato, attendendo al massimo timeout
+*/
+func DialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	return Dial(&net.Dialer{Timeout: timeout}, address)
+}
+
+/*
+Genera la chiave ed il certificato autofirmato di una CA, in formato PEM
+*/
+func GenerateCA(name string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certTemplate(name, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificate(der, key)
+}
+
+/*
+Genera la chiave ed il certificato di un nodo, firmato dalla CA specificata. Il certificato è valido sia in ascolto
+che in connessione, per TLS_SERVER_NAME e per gli host specificati, indirizzi IP o nomi DNS.
+*/
+func GenerateCertificate(caCertPEM []byte, caKeyPEM []byte, name string, hosts []string, validity time.Duration) ([]byte, []byte, error) {
+	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certTemplate(name, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.DNSNames = []string{TLS_SERVER_NAME}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificate(der, key)
+}
+
+/*
+Crea il template di un certificato con numero di serie casuale
+*/
+func certTemplate(name string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"JDSys"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}, nil
+}
+
+/*
+Decodifica certificato e chiave della CA in formato PEM
+*/
+func parseCA(certPEM []byte, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("Invalid CA certificate or key")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+/*
+Codifica certificato e chiave in formato PEM
+*/
+func encodeCertificate(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
@@ -28,24 +28,82 @@ var TEST_STEADY_TIME = 5 * time.Second                              // Tempo per
 var WAIT_SUCC_TIME = 10 * time.Second                               // Tempo che il nodo attende prima di provare a ricontattare il suo successore
 var DIAL_RETRY = 3 * time.Second                                    // Tempo prima di effettuare un retry sulla Dial Http
 var CHORD_STEADY_TIME = 20 * time.Second                            // Tempo necessario a chord per aggiornare tutte le finger table
+var TOMBSTONE_GRACE_PERIOD time.Duration = time.Hour                // Dopo quanto tempo un tombstone viene rimosso definitivamente, deve superare START_CONSISTENCY_INTERVAL
+var TOMBSTONE_GC_INTERVAL time.Duration = 15 * time.Minute          // Ogni quanto controlliamo i tombstone scaduti
+var EXPIRY_CHECK_INTERVAL time.Duration = time.Minute               // Ogni quanto controlliamo le entry con time-to-live scaduto
+var REPLICA_CHECK_INTERVAL time.Duration = 10 * time.Second         // Ogni quanto ricalcoliamo il replica set dalla lista dei successori
+var REPLICA_TIMEOUT time.Duration = 5 * time.Second                 // Tempo massimo di attesa della risposta di una replica per letture con quorum e read repair
+var WRITE_ACK_TIMEOUT time.Duration = 5 * time.Second               // Tempo massimo di attesa delle conferme delle repliche prima di rispondere ad una scrittura
+var OPLOG_RETRY_TIME time.Duration = 5 * time.Second                // Tempo prima di riaprire lo stream del log di replicazione verso una replica
+var OWNERSHIP_CHECK_INTERVAL time.Duration = 10 * time.Second       // Ogni quanto controlliamo se predecessore o successore sono cambiati, ricalcolando il ruolo delle entry
+var REPLICA_GC_INTERVAL time.Duration = 10 * time.Minute            // Ogni quanto rimuoviamo le entry di cui il nodo non è più responsabile
+var REPLICA_GC_GRACE time.Duration = 30 * time.Minute               // Dopo quanto tempo un'entry di cui il nodo non è più responsabile può essere rimossa
+var MERKLE_CACHE_TIME time.Duration = 30 * time.Second              // Per quanto tempo un nodo mantiene il Merkle tree di un intervallo durante l'anti-entropy
+var TRANSFER_TIMEOUT time.Duration = 30 * time.Second               // Tempo massimo di attesa di un frame durante il trasferimento di un file
+var TRANSFER_ACK_TIMEOUT time.Duration = 10 * time.Minute           // Tempo massimo di attesa della conferma del ricevente dopo l'invio di un file
+var TRANSFER_RETRY_TIME time.Duration = 5 * time.Second             // Tempo prima di riprendere un trasferimento interrotto
+var TRANSFER_PARTIAL_TTL time.Duration = time.Hour                  // Dopo quanto tempo il file parziale di un trasferimento non ripreso viene rimosso
+var STORAGE_FLUSH_INTERVAL time.Duration = time.Minute              // Ogni quanto il motore embedded salva su file gli ultimi accessi in lettura
 
 //—————————————————————————————————————————————
 // Port Settings
 //—————————————————————————————————————————————
-var HEARTBEAT_PORT string = ":8888"             // Porta su cui il nodo ascolta i segnali da load balancer e registry
-var FILETR_REPLICATION_PORT string = ":7777"    // Porta su cui il nodo ascolta l'update mongo da altri nodi
-var FILETR_RECONCILIATION_PORT string = ":6666" // Porta su cui il nodo ascolta l'update mongo per reconciliation da altri nodi
-var FILETR_MIGRATION_PORT string = ":5555"      // Porta su cui il nodo ascolta l'update mongo per migration da altri nodi
-var RPC_PORT string = ":80"                     // Porta su cui il nodo ascolta le chiamate RPC
-var REGISTRY_PORT string = ":4444"              // Porta tramite cui il nodo instaura una connessione con il Service Registry
-var CHORD_PORT string = ":3333"                 // Porta tramite cui il nodo riceve ed invia i messaggi necessari ad aggiornare la DHT Chord
+var HEARTBEAT_PORT string = ":8888"        // Porta su cui il nodo ascolta i segnali da load balancer e registry
+var OPLOG_PORT string = ":7777"            // Porta su cui il nodo riceve gli stream dei log di replicazione degli altri nodi
+var FILETR_MIGRATION_PORT string = ":5555" // Porta su cui il nodo ascolta l'update mongo per migration da altri nodi
+var RPC_PORT string = ":80"                // Porta su cui il nodo ascolta le chiamate RPC
+var REGISTRY_PORT string = ":4444"         // Porta tramite cui il nodo instaura una connessione con il Service Registry
+var CHORD_PORT string = ":3333"            // Porta tramite cui il nodo riceve ed invia i messaggi necessari ad aggiornare la DHT Chord
+
+//—————————————————————————————————————————————
+// Security Settings
+//—————————————————————————————————————————————
+var TLS_ENABLED bool = false                     // Cifra RPC, Chord e trasferimenti con mTLS, rifiutando i peer senza un certificato della CA del cluster
+var TLS_PATH string = "../../certs/"             // Cartella con la CA del cluster ed il certificato del nodo, generati con certgen
+var TLS_CA_FILE string = TLS_PATH + "ca.pem"     // Certificato della CA del cluster
+var TLS_CERT_FILE string = TLS_PATH + "node.pem" // Certificato del nodo, firmato dalla CA del cluster
+var TLS_KEY_FILE string = TLS_PATH + "node.key"  // Chiave privata del certificato del nodo
+var TLS_SERVER_NAME string = "jdsys.cluster"     // Nome presente nel certificato di ogni nodo, verificato al posto dell'indirizzo IP
 
 //—————————————————————————————————————————————
 // Update Messages
 //—————————————————————————————————————————————
-var RECON string = "reconciliation"
-var REPLN string = "replication"
 var MIGRN string = "migration"
+var REPLN string = "replication"
+var ANTIE string = "anti-entropy"
+
+//—————————————————————————————————————————————
+// Storage Engine Settings
+//—————————————————————————————————————————————
+var MONGO_ENGINE string = "mongo"
+var MEMORY_ENGINE string = "memory"
+var FILE_ENGINE string = "file"
+
+var STORAGE_ENGINE string = MONGO_ENGINE               // Motore di storage locale utilizzato dal nodo (mongo, memory, file)
+var STORAGE_PATH string = "../mongo/storage/"          // Cartella in cui il motore embedded salva le entry
+var STORAGE_FILE string = STORAGE_PATH + "storage.csv" // File in cui il motore embedded salva le entry
+var OPLOG_FILE string = STORAGE_PATH + "oplog.log"     // File in cui il nodo salva il log delle scritture da replicare
+var OFFSETS_FILE string = STORAGE_PATH + "acked.json"  // File in cui il nodo salva gli offset applicati dei log degli altri nodi
+var NTP_SERVER string = "0.beevik-ntp.pool.ntp.org"    // Server NTP per i timestamp delle entry
+var MAX_VERSIONS int = 10                              // Numero di versioni mantenute per ogni chiave, se non specificato dal client
+var SCAN_DEFAULT_LIMIT int = 100                       // Numero di chiavi per pagina di una scansione, se non specificato dal client
+var SCAN_MAX_LIMIT int = 1000                          // Numero massimo di chiavi per pagina di una scansione
+var CHUNK_THRESHOLD int = 1 << 20                      // Dimensione in byte oltre la quale un valore viene suddiviso in chunk
+var CHUNK_SIZE int = 256 << 10                         // Dimensione in byte di ogni chunk
+var REPLICATION_FACTOR int = 2                         // Numero di copie di ogni entry, compresa quella del nodo che la gestisce
+var READ_REPAIR bool = true                            // Le Get confrontano le copie delle repliche aggiornando quelle rimaste indietro
+var WRITE_CONSISTENCY string = "ONE"                   // Consistenza delle scritture se non specificata dal client, con QUORUM o ALL si attendono le repliche
+var MERKLE_DEPTH int = 10                              // Profondità dei Merkle tree confrontati dall'anti-entropy, con 2^MERKLE_DEPTH foglie
+var ANTI_ENTROPY_BATCH int = 100                       // Numero massimo di entry trasferite con una singola RPC durante l'anti-entropy
+var OPLOG_MAX_RECORDS int = 100000                     // Numero massimo di operazioni mantenute nel log di replicazione
+var OPLOG_BATCH int = 100                              // Numero massimo di operazioni del log inviate ad una replica con un unico ack
+var STORAGE_JOURNAL_MAX int = 10000                    // Numero di modifiche registrate nel journal del motore embedded prima di riscriverne il file
+var TRANSFER_COMPRESSION string = "snappy"             // Compressione di migrazioni e stream di replicazione (none, gzip, snappy), uguale su tutto il cluster
+var TRANSFER_RETRIES int = 5                           // Numero di tentativi di un trasferimento interrotto, ognuno ripreso dall'ultimo byte salvato dal ricevente
+var TRANSFER_SYNC_BYTES int = 4 << 20                  // Ogni quanti byte ricevuti il file parziale di un trasferimento viene salvato su disco
+var TRANSFER_MAX_SENDS int = 4                         // Numero massimo di trasferimenti inviati contemporaneamente dal nodo
+var TRANSFER_MAX_STREAMS int = 4                       // Numero massimo di trasferimenti ricevuti ed applicati contemporaneamente dal nodo
+var APPLY_STRIPES int = 64                             // Gruppi di chiavi in cui sono suddivise le entry ricevute, applicati in parallelo da trasferimenti diversi
 
 //—————————————————————————————————————————————
 // MongoDB Settings
@@ -57,23 +115,6 @@ var CLOUD_EXPORT_PATH string = "../mongo/communication/cloud/export/"
 var CLOUD_RECEIVE_PATH string = "../mongo/communication/cloud/receive/"
 var CLOUD_EXPORT_FILE string = CLOUD_EXPORT_PATH + "exported.csv"
 
-// Replication Path
-var REPLICATION_SEND_PATH string = "../mongo/communication/repl/send/"
-var REPLICATION_RECEIVE_PATH string = "../mongo/communication/repl/receive/"
-var REPLICATION_SEND_FILE string = REPLICATION_SEND_PATH + "exported.csv"
-var REPLICATION_RECEIVE_FILE string = REPLICATION_RECEIVE_PATH + "received.csv"
-var REPLICATION_EXPORT_FILE string = REPLICATION_RECEIVE_PATH + "exported.csv"
-
-// Reconciliation Path
-var RECONCILIATION_SEND_PATH string = "../mongo/communication/recon/send/"
-var RECONCILIATION_RECEIVE_PATH string = "../mongo/communication/recon/receive/"
-var RECONCILIATION_SEND_FILE string = RECONCILIATION_SEND_PATH + "exported.csv"
-var RECONCILIATION_RECEIVE_FILE string = RECONCILIATION_RECEIVE_PATH + "received.csv"
-var RECONCILIATION_EXPORT_FILE string = RECONCILIATION_RECEIVE_PATH + "exported.csv"
-
 // Migration Path
 var MIGRATION_SEND_PATH string = "../mongo/communication/migr/send/"
 var MIGRATION_RECEIVE_PATH string = "../mongo/communication/migr/receive/"
-var MIGRATION_SEND_FILE string = MIGRATION_SEND_PATH + "exported.csv"
-var MIGRATION_RECEIVE_FILE string = MIGRATION_RECEIVE_PATH + "received.csv"
-var MIGRATION_EXPORT_FILE string = MIGRATION_RECEIVE_PATH + "exported.csv"
@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"net/rpc"
 	"time"
 )
@@ -12,7 +16,7 @@ Permette di instaurare una connessione HTTP con il server all'indirizzo e porta
 */
 func HttpConnect(addr string, port string) (*rpc.Client, error) {
 retry:
-	client, err := rpc.DialHTTP("tcp", addr+port)
+	client, err := rpcConnect(addr+port, 0)
 	if err != nil {
 		time.Sleep(DIAL_RETRY)
 		goto retry
@@ -20,6 +24,39 @@ retry:
 	return client, err
 }
 
+/*
+Instaura una connessione HTTP con il server all'indirizzo e porta specificati, senza ritentare in caso di errore.
+Utilizzata quando un nodo non raggiungibile non deve bloccare il chiamante, ad esempio verso le repliche.
+*/
+func HttpConnectTimeout(addr string, port string, timeout time.Duration) (*rpc.Client, error) {
+	return rpcConnect(addr+port, timeout)
+}
+
+/*
+Apre la connessione, cifrata con TLS_ENABLED, e richiede al server HTTP il passaggio al protocollo RPC.
+Con timeout nullo non si pone un limite all'attesa.
+*/
+func rpcConnect(address string, timeout time.Duration) (*rpc.Client, error) {
+	conn, err := Dial(&net.Dialer{Timeout: timeout}, address)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != "200 Connected to Go RPC" {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
+
 /*
 Restituisce l'indirizzo IP in uscita preferito della macchina che hosta il nodo
 */
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -123,6 +124,23 @@ func StringInBoxL2(msg1 string, msg2 string) string {
 	return top + middle1 + middle2 + bottom
 }
 
+/*
+Formatta una lista di messaggi all'interno di un unico Box
+*/
+func StringInBoxLines(msgs []string) string {
+	lenght := 0
+	for _, msg := range msgs {
+		if len(msg) > lenght {
+			lenght = len(msg)
+		}
+	}
+	box := "+" + strings.Repeat("—", lenght+2) + "+\n"
+	for _, msg := range msgs {
+		box += "| " + msg + strings.Repeat(" ", lenght-len(msg)) + " |\n"
+	}
+	return box + "+" + strings.Repeat("—", lenght+2) + "+"
+}
+
 /*
 Stampa due messaggi, formattandoli all'interno di un unico Box
 */
@@ -153,21 +171,23 @@ Stampa la lista di comandi disponibili sul client
 func PrintClientCommandsList() {
 	fmt.Print(StringInBox("COMMANDS LIST"))
 
-	get := "Get"
-	put := "Put"
-	del := "Delete"
-	app := "Append"
-	ext := "Exit"
-
-	top := "+" + strings.Repeat("—", 15) + "+\n"
-	row1 := "| 1 |  " + get + strings.Repeat(" ", 3) + "   |\n"
-	row2 := "| 2 |  " + put + strings.Repeat(" ", 3) + "   |\n"
-	row3 := "| 3 |  " + del + strings.Repeat(" ", 0) + "   |\n"
-	row4 := "| 4 |  " + app + strings.Repeat(" ", 0) + "   |\n"
-	row5 := "| 5 |  " + ext + strings.Repeat(" ", 2) + "   |\n"
-	bottom := "+" + strings.Repeat("—", 15) + "+"
-
-	fmt.Println(top + row1 + row2 + row3 + row4 + row5 + bottom)
+	commands := []string{"Get", "Put", "Delete", "Append", "Resolve", "Versions", "Conditional", "Scan", "Batch", "Upload", "Download", "Stats", "Exit"}
+	width := 0
+	for _, cmd := range commands {
+		if len(cmd) > width {
+			width = len(cmd)
+		}
+	}
+
+	digits := len(strconv.Itoa(len(commands)))
+	line := "+" + strings.Repeat("—", width+digits+8) + "+"
+	rows := line + "\n"
+	for i, cmd := range commands {
+		index := strconv.Itoa(i + 1)
+		rows += "| " + index + strings.Repeat(" ", digits-len(index)) + " |  " + cmd + strings.Repeat(" ", width-len(cmd)) + "   |\n"
+	}
+
+	fmt.Println(rows + line)
 	PrintLineL1()
 }
 
@@ -37,6 +37,21 @@ func StringInSlice(a string, list []string) bool {
 	return false
 }
 
+/*
+Indica se due slice contengono le stesse stringhe nello stesso ordine
+*/
+func EqualStringSlices(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 /*
 Rimuove un elemento da una slice
 */
@@ -76,35 +91,6 @@ func HashString(str string) [32]byte {
 	return sha256.Sum256([]byte(str))
 }
 
-/*
-Formatta la stringa per un Value in mongo
-*/
-func FormatValue(str string) string {
-	if strings.Contains(str, "[") && strings.Contains(str, "]") {
-		return str
-	}
-	return "[" + str + "]"
-}
-
-/*
-Restituisce la stringa contenuta tra due parentesi quadre
-*/
-func RemoveBrackets(str string) string {
-	if strings.Contains(str, "[") && strings.Contains(str, "]") {
-		return str[1 : len(str)-1]
-	}
-	return str
-}
-
-/*
-Appende un valore ad una stringa, mantenendo la lista contenuta tra due parentesi quadre
-*/
-func AppendValue(str string, arg1 string) string {
-	temp := GetStringInBetween(str, "[", "]")
-	append := temp + "," + arg1
-	return FormatValue(append)
-}
-
 /*
 Rimuove la porta dall'indirizzo di Chord Lookup, e aggiunge la porta per effettuare le RPC.
 */